@@ -0,0 +1,413 @@
+package concepts
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/jmcvetta/neoism"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j/dbtype"
+)
+
+//Message of the errors that a batch fails with when Neo4j rolls it back, the same as over HTTP
+const boltTransactionErrorMessage = "Error with a query inside a transaction."
+
+//Code of the errors that a transaction fails with when Neo4j terminates it because its timeout passed
+const transactionTimedOutCode = "Neo.ClientError.Transaction.TransactionTimedOut"
+
+//Code of the error that creating an index or constraint fails with on Neo4j 4.x if it already exists
+const equivalentSchemaRuleCode = "Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists"
+
+var boltSchemes = map[string]bool{
+	"bolt":      true,
+	"bolt+s":    true,
+	"bolt+ssc":  true,
+	"neo4j":     true,
+	"neo4j+s":   true,
+	"neo4j+ssc": true,
+}
+
+// IsBoltURL - whether the URL is of a Neo4j server to connect to over Bolt, rather than the HTTP endpoint
+func IsBoltURL(neoURL string) bool {
+	u, err := url.Parse(neoURL)
+	return err == nil && boltSchemes[u.Scheme]
+}
+
+// BoltConfig - the credentials and certificates to connect to Neo4j over Bolt with. Whether the connection is encrypted
+// is decided by the scheme of the URL: bolt+s and neo4j+s verify the server's certificate, while bolt+ssc and neo4j+ssc
+// trust any certificate it has.
+type BoltConfig struct {
+	//No authentication is used if the username is empty
+	Username string
+	Password string
+	//PEM file of the certificate authorities to verify the server's certificate with, instead of those of the system
+	CAFile string
+}
+
+// BoltConnection - connection to Neo4j over Bolt, which runs each batch of queries in a single transaction. Schemes
+// starting with neo4j route the queries to the leader of a cluster, and those starting with bolt connect to a single
+// server.
+type BoltConnection struct {
+	driver neo4j.Driver
+
+	versionLock sync.Mutex
+	version     []int
+}
+
+// NewBoltConnection - connection to the Neo4j at the bolt or neo4j URL, which is returned along with the error if Neo4j
+// can't be reached yet, so that it can be used once it is
+func NewBoltConnection(neoURL string, conf BoltConfig) (*BoltConnection, error) {
+	auth := neo4j.NoAuth()
+	if conf.Username != "" {
+		auth = neo4j.BasicAuth(conf.Username, conf.Password, "")
+	}
+
+	var rootCAs *x509.CertPool
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", conf.CAFile)
+		}
+	}
+
+	driver, err := neo4j.NewDriver(neoURL, auth, func(c *neo4j.Config) {
+		c.RootCAs = rootCAs
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltConnection{driver: driver}, driver.VerifyConnectivity()
+}
+
+// CypherBatch - run the queries in order in a single transaction, decoding the rows each returns into its result, and
+// commit it only if they all succeed
+func (c *BoltConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherBatchContext(context.Background(), queries)
+}
+
+// CypherBatchContext - CypherBatch, which is rolled back if the context is done before it is committed. The context's
+// deadline is also the timeout of the transaction, so that Neo4j gives up on it as well if it is stuck.
+func (c *BoltConnection) CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	var txConfig []func(*neo4j.TransactionConfig)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		txConfig = append(txConfig, neo4j.WithTxTimeout(timeout))
+	}
+
+	session := c.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	tx, err := session.BeginTransaction(txConfig...)
+	if err != nil {
+		return boltBatchError(ctx, err, false)
+	}
+	defer tx.Close()
+
+	for _, query := range queries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		params, err := boltParameters(query.Parameters)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Run(query.Statement, params)
+		if err != nil {
+			return boltBatchError(ctx, err, false)
+		}
+		records, err := result.Collect()
+		if err != nil {
+			return boltBatchError(ctx, err, false)
+		}
+		if query.Result != nil {
+			if err := decodeRecords(records, query.Result); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return boltBatchError(ctx, tx.Commit(), true)
+}
+
+// EnsureIndexes - create an index on each property of the label it is mapped from, if there isn't one already
+func (c *BoltConnection) EnsureIndexes(indexes map[string]string) error {
+	version, err := c.serverVersion()
+	if err != nil {
+		return err
+	}
+	for label, property := range indexes {
+		if err := c.createSchemaRule(indexStatement(version, label, property)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureConstraints - create a unique constraint on each property of the label it is mapped from, if there isn't one
+// already
+func (c *BoltConnection) EnsureConstraints(constraints map[string]string) error {
+	version, err := c.serverVersion()
+	if err != nil {
+		return err
+	}
+	for label, property := range constraints {
+		if err := c.createSchemaRule(constraintStatement(version, label, property)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckWritable - whether a write transaction can be started, which over a neo4j URL is routed to the leader of the
+// cluster. The role of the server is not checked as it is over HTTP, as dbms.cluster.role() needs a database from 4.0.
+func (c *BoltConnection) CheckWritable() error {
+	session := c.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	tx, err := session.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	return tx.Rollback()
+}
+
+// Close - close every connection to Neo4j
+func (c *BoltConnection) Close() error {
+	return c.driver.Close()
+}
+
+func (c *BoltConnection) String() string {
+	target := c.driver.Target()
+	return fmt.Sprintf("BoltConnection(%s)", target.String())
+}
+
+//Schema changes can't be in the same transaction as anything else, so each is run in one of its own
+func (c *BoltConnection) createSchemaRule(statement string) error {
+	session := c.driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close()
+
+	logger.Debugf("Creating schema rule: %s", statement)
+	result, err := session.Run(statement, nil)
+	if err == nil {
+		_, err = result.Consume()
+	}
+	if neoErr, ok := err.(*neo4j.Neo4jError); ok && neoErr.Code == equivalentSchemaRuleCode {
+		return nil
+	}
+	return err
+}
+
+//The major and minor version of the Neo4j server, which decides the syntax of the schema statements
+func (c *BoltConnection) serverVersion() ([]int, error) {
+	c.versionLock.Lock()
+	defer c.versionLock.Unlock()
+
+	if c.version != nil {
+		return c.version, nil
+	}
+	var results []struct {
+		Version string `json:"version"`
+	}
+	query := &neoism.CypherQuery{
+		Statement: `
+			CALL dbms.components() YIELD name, versions
+			WHERE name = 'Neo4j Kernel'
+			RETURN versions[0] AS version`,
+		Result: &results,
+	}
+	if err := c.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("could not find the version of Neo4j")
+	}
+	version, err := parseServerVersion(results[0].Version)
+	if err != nil {
+		return nil, err
+	}
+	c.version = version
+	return version, nil
+}
+
+func parseServerVersion(version string) ([]int, error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("unknown version of Neo4j: %s", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("unknown version of Neo4j: %s", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("unknown version of Neo4j: %s", version)
+	}
+	return []int{major, minor}, nil
+}
+
+//Whether the version has the FOR ... REQUIRE schema syntax, which replaces ON ... ASSERT removed in 5.0
+func hasForSchemaSyntax(version []int) bool {
+	return version[0] > 4 || (version[0] == 4 && version[1] >= 4)
+}
+
+//Index statements are idempotent on 3.x, while on 4.x an index which already exists fails them with
+//equivalentSchemaRuleCode unless IF NOT EXISTS is supported
+func indexStatement(version []int, label string, property string) string {
+	if hasForSchemaSyntax(version) {
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS FOR (n:%s) ON (n.%s)", label, property)
+	}
+	return fmt.Sprintf("CREATE INDEX ON :%s(%s)", label, property)
+}
+
+func constraintStatement(version []int, label string, property string) string {
+	if hasForSchemaSyntax(version) {
+		return fmt.Sprintf("CREATE CONSTRAINT IF NOT EXISTS FOR (n:%s) REQUIRE n.%s IS UNIQUE", label, property)
+	}
+	return fmt.Sprintf("CREATE CONSTRAINT ON (n:%s) ASSERT n.%s IS UNIQUE", label, property)
+}
+
+//Failure to connect to Neo4j before the commit of a batch was sent, so that nothing in it can have been committed
+type boltConnectError struct {
+	err error
+}
+
+func (e boltConnectError) Error() string {
+	return e.err.Error()
+}
+
+//A batch that fails once the context is done, or that Neo4j terminated because the timeout taken from the context's
+//deadline passed, fails with the context's error rather than as a conflict
+func boltBatchError(ctx context.Context, err error, committing bool) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if neoErr, ok := err.(*neo4j.Neo4jError); ok && strings.HasPrefix(neoErr.Code, transactionTimedOutCode) {
+		return context.DeadlineExceeded
+	}
+	return boltError(err, committing)
+}
+
+//Errors from Neo4j are returned as they are over HTTP, so that a batch rolled back by Neo4j is a conflict whatever the
+//connection. A connection lost while committing is returned as it is, as the batch could have been committed.
+func boltError(err error, committing bool) error {
+	if err == nil {
+		return nil
+	}
+	if neoErr, ok := err.(*neo4j.Neo4jError); ok {
+		return rwapi.ConstraintOrTransactionError{
+			Message: boltTransactionErrorMessage,
+			Details: []string{neoErr.Code + ": " + neoErr.Msg},
+		}
+	}
+	if !committing && neo4j.IsConnectivityError(err) {
+		return boltConnectError{err}
+	}
+	return err
+}
+
+//Parameters are sent as they would be encoded as JSON over HTTP, as the driver can't send structs and other types
+//that aren't a value of Cypher
+func boltParameters(parameters map[string]interface{}) (map[string]interface{}, error) {
+	if parameters == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	var decoded map[string]interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return boltValue(decoded).(map[string]interface{}), nil
+}
+
+func boltValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = boltValue(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = boltValue(v[k])
+		}
+	}
+	return value
+}
+
+//Decode the records into the result as the rows of the HTTP endpoint are, each an object of the columns returned
+func decodeRecords(records []*neo4j.Record, result interface{}) error {
+	rows := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		row := map[string]interface{}{}
+		for i, key := range record.Keys {
+			row[key] = rowValue(record.Values[i])
+		}
+		rows = append(rows, row)
+	}
+
+	encoded, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, result)
+}
+
+//Nodes and relationships are returned as their properties, as they are in the rows of the HTTP endpoint
+func rowValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case dbtype.Node:
+		return v.Props
+	case dbtype.Relationship:
+		return v.Props
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = rowValue(v[i])
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for k := range v {
+			values[k] = rowValue(v[k])
+		}
+		return values
+	}
+	return value
+}
+
+var _ neoutils.NeoConnection = (*BoltConnection)(nil)
@@ -0,0 +1,132 @@
+package concepts
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j/dbtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBoltURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{"http://localhost:7474/db/data", false},
+		{"https://neo4j.example.com:7473/db/data", false},
+		{"memory", false},
+		{"bolt://localhost:7687", true},
+		{"bolt+s://neo4j.example.com:7687", true},
+		{"bolt+ssc://neo4j.example.com:7687", true},
+		{"neo4j://neo4j.example.com:7687", true},
+		{"neo4j+s://neo4j.example.com:7687", true},
+		{"neo4j+ssc://neo4j.example.com:7687", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			assert.Equal(t, test.expected, IsBoltURL(test.url))
+		})
+	}
+}
+
+func TestSchemaStatementsForServerVersion(t *testing.T) {
+	tests := []struct {
+		version            string
+		expectedIndex      string
+		expectedConstraint string
+	}{
+		{"3.5.35", "CREATE INDEX ON :Thing(authorityValue)", "CREATE CONSTRAINT ON (n:Thing) ASSERT n.uuid IS UNIQUE"},
+		{"4.2.19", "CREATE INDEX ON :Thing(authorityValue)", "CREATE CONSTRAINT ON (n:Thing) ASSERT n.uuid IS UNIQUE"},
+		{"4.4.26", "CREATE INDEX IF NOT EXISTS FOR (n:Thing) ON (n.authorityValue)", "CREATE CONSTRAINT IF NOT EXISTS FOR (n:Thing) REQUIRE n.uuid IS UNIQUE"},
+		{"5.13.0", "CREATE INDEX IF NOT EXISTS FOR (n:Thing) ON (n.authorityValue)", "CREATE CONSTRAINT IF NOT EXISTS FOR (n:Thing) REQUIRE n.uuid IS UNIQUE"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			version, err := parseServerVersion(test.version)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedIndex, indexStatement(version, "Thing", "authorityValue"))
+			assert.Equal(t, test.expectedConstraint, constraintStatement(version, "Thing", "uuid"))
+		})
+	}
+
+	_, err := parseServerVersion("unknown")
+	assert.Error(t, err)
+}
+
+func TestBoltErrors(t *testing.T) {
+	otherErr := errors.New("invalid parameter")
+	tests := []struct {
+		name        string
+		err         error
+		committing  bool
+		expectedErr error
+	}{
+		{"No error", nil, false, nil},
+		{
+			"Failed guard",
+			&neo4j.Neo4jError{Code: "Neo.ClientError.Statement.ArithmeticError", Msg: "/ by zero"},
+			false,
+			rwapi.ConstraintOrTransactionError{Message: boltTransactionErrorMessage, Details: []string{"Neo.ClientError.Statement.ArithmeticError: / by zero"}},
+		},
+		{
+			"Constraint violated on commit",
+			&neo4j.Neo4jError{Code: "Neo.ClientError.Schema.ConstraintValidationFailed", Msg: "Node(1) already exists"},
+			true,
+			rwapi.ConstraintOrTransactionError{Message: boltTransactionErrorMessage, Details: []string{"Neo.ClientError.Schema.ConstraintValidationFailed: Node(1) already exists"}},
+		},
+		{"Other error", otherErr, false, otherErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectedErr, boltError(test.err, test.committing))
+		})
+	}
+}
+
+func TestBoltParametersAreSentAsJSON(t *testing.T) {
+	params, err := boltParameters(map[string]interface{}{
+		"count": 2,
+		"score": 0.5,
+		"rows": []map[string]interface{}{
+			{"labels": []string{"Thing", "Concept"}, "relationship": struct {
+				UUID string `json:"uuid"`
+			}{"1234"}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"count": int64(2),
+		"score": 0.5,
+		"rows": []interface{}{
+			map[string]interface{}{"labels": []interface{}{"Thing", "Concept"}, "relationship": map[string]interface{}{"uuid": "1234"}},
+		},
+	}, params)
+}
+
+func TestRecordsAreDecodedAsRows(t *testing.T) {
+	records := []*neo4j.Record{
+		{
+			Keys:   []string{"uuid", "types", "node", "relationships"},
+			Values: []interface{}{"1234", []interface{}{"Thing", "Brand"}, dbtype.Node{Props: map[string]interface{}{"prefLabel": "Label"}}, []interface{}{dbtype.Relationship{Props: map[string]interface{}{"weight": int64(2)}}}},
+		},
+	}
+
+	var results []struct {
+		UUID          string                   `json:"uuid"`
+		Types         []string                 `json:"types"`
+		Node          map[string]string        `json:"node"`
+		Relationships []map[string]interface{} `json:"relationships"`
+	}
+	assert.NoError(t, decodeRecords(records, &results))
+	assert.Len(t, results, 1)
+	assert.Equal(t, "1234", results[0].UUID)
+	assert.Equal(t, []string{"Thing", "Brand"}, results[0].Types)
+	assert.Equal(t, map[string]string{"prefLabel": "Label"}, results[0].Node)
+	assert.Equal(t, []map[string]interface{}{{"weight": float64(2)}}, results[0].Relationships)
+}
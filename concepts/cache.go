@@ -0,0 +1,117 @@
+package concepts
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+//Least recently used cache of concepts as read, keyed by prefUUID. Entries are dropped when a write or delete changes
+//them, and once they are older than maxAge, so that concepts changed by other instances of the service are read again.
+type conceptCache struct {
+	sync.Mutex
+	size       int
+	maxAge     time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
+	generation uint64
+	hits       metrics.Counter
+	misses     metrics.Counter
+	evictions  metrics.Counter
+}
+
+type conceptCacheEntry struct {
+	prefUUID string
+	concept  AggregatedConcept
+	added    time.Time
+}
+
+func newConceptCache(size int, maxAge time.Duration, registry metrics.Registry) *conceptCache {
+	return &conceptCache{
+		size:      size,
+		maxAge:    maxAge,
+		entries:   map[string]*list.Element{},
+		order:     list.New(),
+		hits:      metrics.GetOrRegisterCounter("concepts.cache.hits", registry),
+		misses:    metrics.GetOrRegisterCounter("concepts.cache.misses", registry),
+		evictions: metrics.GetOrRegisterCounter("concepts.cache.evictions", registry),
+	}
+}
+
+//Get a copy of the cached concept, along with the generation of the cache to pass to add if it has to be read instead
+func (c *conceptCache) get(prefUUID string) (AggregatedConcept, bool, uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[prefUUID]
+	if ok && c.maxAge > 0 && time.Since(element.Value.(*conceptCacheEntry).added) > c.maxAge {
+		c.remove(element)
+		ok = false
+	}
+	if !ok {
+		c.misses.Inc(1)
+		return AggregatedConcept{}, false, c.generation
+	}
+
+	c.hits.Inc(1)
+	c.order.MoveToFront(element)
+	return copyConcept(element.Value.(*conceptCacheEntry).concept), true, c.generation
+}
+
+//Add a concept that was read while the cache was at the given generation. A concept that any write may have changed
+//since is not added, as the write's invalidation could have happened before the concept was read.
+func (c *conceptCache) add(concept AggregatedConcept, generation uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if element, ok := c.entries[concept.PrefUUID]; ok {
+		c.remove(element)
+	}
+	c.entries[concept.PrefUUID] = c.order.PushFront(&conceptCacheEntry{
+		prefUUID: concept.PrefUUID,
+		concept:  copyConcept(concept),
+		added:    time.Now(),
+	})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.evictions.Inc(1)
+	}
+}
+
+//Drop the concepts with any of the given prefUUIDs
+func (c *conceptCache) invalidate(prefUUIDs ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+	for _, prefUUID := range prefUUIDs {
+		if element, ok := c.entries[prefUUID]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *conceptCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*conceptCacheEntry).prefUUID)
+}
+
+//Copy the concept, so that the cached concept can't be changed by whoever it is returned to
+func copyConcept(concept AggregatedConcept) AggregatedConcept {
+	data, err := json.Marshal(concept)
+	if err != nil {
+		return concept
+	}
+	copied := AggregatedConcept{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return concept
+	}
+	copied.AggregatedHashVersion = concept.AggregatedHashVersion
+	return copied
+}
@@ -0,0 +1,84 @@
+package concepts
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConceptCacheCountsHitsMissesAndEvictions(t *testing.T) {
+	registry := metrics.NewRegistry()
+	cache := newConceptCache(2, 0, registry)
+
+	for i := 0; i < 3; i++ {
+		_, ok, generation := cache.get(fmt.Sprintf("uuid-%d", i))
+		assert.False(t, ok)
+		cache.add(AggregatedConcept{PrefUUID: fmt.Sprintf("uuid-%d", i)}, generation)
+	}
+
+	_, ok, _ := cache.get("uuid-0")
+	assert.False(t, ok, "The least recently used concept should have been evicted")
+	concept, ok, _ := cache.get("uuid-2")
+	assert.True(t, ok)
+	assert.Equal(t, "uuid-2", concept.PrefUUID)
+
+	assert.Equal(t, int64(1), registry.Get("concepts.cache.hits").(metrics.Counter).Count())
+	assert.Equal(t, int64(4), registry.Get("concepts.cache.misses").(metrics.Counter).Count())
+	assert.Equal(t, int64(1), registry.Get("concepts.cache.evictions").(metrics.Counter).Count())
+}
+
+func TestConceptCacheInvalidate(t *testing.T) {
+	cache := newConceptCache(10, 0, metrics.NewRegistry())
+	_, _, generation := cache.get("uuid-1")
+	cache.add(AggregatedConcept{PrefUUID: "uuid-1"}, generation)
+	cache.add(AggregatedConcept{PrefUUID: "uuid-2"}, generation)
+
+	cache.invalidate("uuid-1", "uuid-3")
+	_, ok, _ := cache.get("uuid-1")
+	assert.False(t, ok)
+	_, ok, _ = cache.get("uuid-2")
+	assert.True(t, ok, "Concepts which weren't invalidated should still be cached")
+}
+
+func TestConceptCacheDoesNotAddConceptsReadBeforeAWrite(t *testing.T) {
+	cache := newConceptCache(10, 0, metrics.NewRegistry())
+	_, _, generation := cache.get("uuid-1")
+	cache.invalidate("uuid-1")
+	cache.add(AggregatedConcept{PrefUUID: "uuid-1"}, generation)
+
+	_, ok, _ := cache.get("uuid-1")
+	assert.False(t, ok, "A concept read before a write finished may be out of date")
+}
+
+func TestConceptCacheExpiresEntries(t *testing.T) {
+	cache := newConceptCache(10, time.Millisecond, metrics.NewRegistry())
+	_, _, generation := cache.get("uuid-1")
+	cache.add(AggregatedConcept{PrefUUID: "uuid-1"}, generation)
+
+	time.Sleep(5 * time.Millisecond)
+	_, ok, _ := cache.get("uuid-1")
+	assert.False(t, ok)
+	assert.Empty(t, cache.entries)
+}
+
+func TestConceptCacheReturnsCopies(t *testing.T) {
+	cache := newConceptCache(10, 0, metrics.NewRegistry())
+	concept := AggregatedConcept{
+		PrefUUID:              "uuid-1",
+		Aliases:               []string{"alias"},
+		SourceRepresentations: []Concept{{UUID: "uuid-1", Aliases: []string{"alias"}}},
+	}
+	_, _, generation := cache.get("uuid-1")
+	cache.add(concept, generation)
+	concept.Aliases[0] = "changed"
+
+	cached, _, _ := cache.get("uuid-1")
+	cached.SourceRepresentations[0].Aliases[0] = "changed"
+
+	cached, _, _ = cache.get("uuid-1")
+	assert.Equal(t, []string{"alias"}, cached.Aliases)
+	assert.Equal(t, []string{"alias"}, cached.SourceRepresentations[0].Aliases)
+}
@@ -1,31 +1,80 @@
 package concepts
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 )
 
 type mockConceptService struct {
-	write      func(thing interface{}, transID string) (interface{}, error)
-	read       func(uuid string, transID string) (interface{}, bool, error)
-	decodeJSON func(*json.Decoder) (interface{}, string, error)
-	check      func() error
+	write             func(ctx context.Context, thing interface{}, transID string) (interface{}, error)
+	writeWithOptions  func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error)
+	read              func(ctx context.Context, uuid string, transID string) (interface{}, bool, error)
+	delete            func(ctx context.Context, uuid string, transID string) (interface{}, bool, error)
+	export            func(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error)
+	resolveIdentifier func(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error)
+	events            func(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error)
+	acknowledgeEvents func(ctx context.Context, upTo string, transID string) (int, error)
+	decodeJSON        func(*json.Decoder) (interface{}, string, error)
+	check             func() error
 }
 
-func (mcs *mockConceptService) Write(thing interface{}, transID string) (interface{}, error) {
+func (mcs *mockConceptService) Write(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
 	if mcs.write != nil {
-		return mcs.write(thing, transID)
+		return mcs.write(ctx, thing, transID)
 	}
 	return nil, errors.New("not implemented")
 }
 
-func (mcs *mockConceptService) Read(uuid string, transID string) (interface{}, bool, error) {
+func (mcs *mockConceptService) WriteWithOptions(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+	if mcs.writeWithOptions != nil {
+		return mcs.writeWithOptions(ctx, thing, transID, options)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (mcs *mockConceptService) Read(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
 	if mcs.read != nil {
-		return mcs.read(uuid, transID)
+		return mcs.read(ctx, uuid, transID)
 	}
 	return nil, false, errors.New("not implemented")
 }
 
+func (mcs *mockConceptService) Delete(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+	if mcs.delete != nil {
+		return mcs.delete(ctx, uuid, transID)
+	}
+	return nil, false, errors.New("not implemented")
+}
+
+func (mcs *mockConceptService) Export(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+	if mcs.export != nil {
+		return mcs.export(ctx, conceptType, after, limit, transID)
+	}
+	return nil, "", errors.New("not implemented")
+}
+
+func (mcs *mockConceptService) ResolveIdentifier(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error) {
+	if mcs.resolveIdentifier != nil {
+		return mcs.resolveIdentifier(ctx, authority, authorityValue, transID)
+	}
+	return nil, false, errors.New("not implemented")
+}
+
+func (mcs *mockConceptService) Events(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error) {
+	if mcs.events != nil {
+		return mcs.events(ctx, after, limit, transID)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (mcs *mockConceptService) AcknowledgeEvents(ctx context.Context, upTo string, transID string) (int, error) {
+	if mcs.acknowledgeEvents != nil {
+		return mcs.acknowledgeEvents(ctx, upTo, transID)
+	}
+	return 0, errors.New("not implemented")
+}
+
 func (mcs *mockConceptService) DecodeJSON(d *json.Decoder) (interface{}, string, error) {
 	if mcs.decodeJSON != nil {
 		return mcs.decodeJSON(d)
@@ -40,6 +89,10 @@ func (mcs *mockConceptService) Check() error {
 	return errors.New("not implemented")
 }
 
-func (mcs *mockConceptService) Initialise() error {
-	return nil
+func (mcs *mockConceptService) MigrateUp(ctx context.Context) ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+func (mcs *mockConceptService) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return nil, nil
 }
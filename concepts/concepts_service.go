@@ -1,18 +1,21 @@
 package concepts
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	logger "github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/neo-model-utils-go/mapper"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
 	"github.com/jmcvetta/neoism"
-	"github.com/mitchellh/hashstructure"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rcrowley/go-metrics"
 )
 
 const (
@@ -27,57 +30,74 @@ var concordancesSources = []string{"ManagedLocation", "Smartlogic"}
 
 // ConceptService - CypherDriver - CypherDriver
 type ConceptService struct {
-	conn neoutils.NeoConnection
+	store     ConceptStore
+	publisher EventPublisher
+	locks     *keyedLocks
+	cache     *conceptCache
+	metrics   *conceptMetrics
 }
 
 // ConceptServicer defines the functions any read-write application needs to implement
 type ConceptServicer interface {
-	Write(thing interface{}, transID string) (updatedIds interface{}, err error)
-	Read(uuid string, transID string) (thing interface{}, found bool, err error)
+	Write(ctx context.Context, thing interface{}, transID string) (updatedIds interface{}, err error)
+	WriteWithOptions(ctx context.Context, thing interface{}, transID string, options WriteOptions) (updatedIds interface{}, err error)
+	Read(ctx context.Context, uuid string, transID string) (thing interface{}, found bool, err error)
+	Delete(ctx context.Context, uuid string, transID string) (updatedIds interface{}, found bool, err error)
+	Export(ctx context.Context, conceptType string, after string, limit int, transID string) (concepts []AggregatedConcept, next string, err error)
+	ResolveIdentifier(ctx context.Context, authority string, authorityValue string, transID string) (resolutions interface{}, found bool, err error)
+	Events(ctx context.Context, after string, limit int, transID string) (events []OutboxEvent, err error)
+	AcknowledgeEvents(ctx context.Context, upTo string, transID string) (acknowledged int, err error)
 	DecodeJSON(*json.Decoder) (thing interface{}, identity string, err error)
 	Check() error
-	Initialise() error
+	MigrateUp(ctx context.Context) (applied []MigrationStatus, err error)
+	MigrationStatus(ctx context.Context) (migrations []MigrationStatus, err error)
+}
+
+// WriteOptions - optional behaviour for a single write
+type WriteOptions struct {
+	// DryRun runs the full write pipeline, including validation and concordance checks, and returns the changes
+	// it would make without executing any of the write queries
+	DryRun bool
+	// Precondition, if set, must hold against the stored aggregate hash for the write to go ahead
+	Precondition *Precondition
+}
+
+// Precondition - entity tags, as aggregate hashes, which the stored concept must or must not match.
+// A value of "*" matches any stored concept.
+type Precondition struct {
+	IfMatch     []string
+	IfNoneMatch []string
 }
 
 // NewConceptService instantiate driver
 func NewConceptService(cypherRunner neoutils.NeoConnection) ConceptService {
-	return ConceptService{cypherRunner}
+	return NewConceptServiceWithPublisher(cypherRunner, responseOnlyEventPublisher{})
 }
 
-// Initialise - Would this be better as an extension in Neo4j? i.e. that any Thing has this constraint added on creation
-func (s *ConceptService) Initialise() error {
-	err := s.conn.EnsureIndexes(map[string]string{
-		"Identifier": "value",
-		"Concept":    "leiCode",
-	})
-	if err != nil {
-		logger.WithError(err).Error("Could not run db index")
-		return err
-	}
+// NewConceptServiceWithPublisher instantiate driver which publishes the events of every write and delete
+func NewConceptServiceWithPublisher(cypherRunner neoutils.NeoConnection, publisher EventPublisher) ConceptService {
+	return NewConceptServiceWithStore(NewNeo4jConceptStore(cypherRunner), publisher)
+}
 
-	err = s.conn.EnsureIndexes(map[string]string{
-		"Thing":   "authorityValue",
-		"Concept": "authorityValue",
-	})
-	if err != nil {
-		logger.WithError(err).Error("Could not run DB constraints")
-		return err
+// NewConceptServiceWithStore instantiate driver which stores concepts in the given store, and publishes the events of
+// every write and delete if it is given a publisher
+func NewConceptServiceWithStore(store ConceptStore, publisher EventPublisher) ConceptService {
+	if publisher == nil {
+		publisher = responseOnlyEventPublisher{}
 	}
+	return ConceptService{store, publisher, newKeyedLocks(), nil, newConceptMetrics(prometheus.DefaultRegisterer)}
+}
 
-	err = s.conn.EnsureConstraints(map[string]string{
-		"Thing":    "prefUUID",
-		"Concept":  "prefUUID",
-		"Location": "iso31661",
-	})
-	if err != nil {
-		logger.WithError(err).Error("Could not run db constraints")
-		return err
-	}
-	return s.conn.EnsureConstraints(constraintMap)
+// EnableReadCache - keeps up to size concepts in memory as they are read, each for at most maxAge or, if maxAge is 0,
+// until this instance writes or deletes it. Hits, misses and evictions are counted in the default metrics registry. It
+// must be called before the service is started or used.
+func (s *ConceptService) EnableReadCache(size int, maxAge time.Duration) {
+	s.cache = newConceptCache(size, maxAge, metrics.DefaultRegistry)
 }
 
 type neoAggregatedConcept struct {
 	AggregateHash         string           `json:"aggregateHash,omitempty"`
+	AggregateHashVersion  int              `json:"aggregateHashVersion,omitempty"`
 	Aliases               []string         `json:"aliases,omitempty"`
 	Authority             string           `json:"authority,omitempty"`
 	AuthorityValue        string           `json:"authorityValue,omitempty"`
@@ -188,199 +208,198 @@ type equivalenceResult struct {
 	Authority   string   `json:"authority"`
 }
 
-//Read - read service
-func (s *ConceptService) Read(uuid string, transID string) (interface{}, bool, error) {
-	var results []neoAggregatedConcept
-
-	query := &neoism.CypherQuery{
-		Statement: `
-			MATCH (canonical:Thing {prefUUID:{uuid}})<-[:EQUIVALENT_TO]-(source:Thing)
-			OPTIONAL MATCH (source)-[:HAS_BROADER]->(broader:Thing)
-			OPTIONAL MATCH (source)-[:HAS_MEMBER]->(person:Thing)
-			OPTIONAL MATCH (source)-[:HAS_ORGANISATION]->(org:Thing)
-			OPTIONAL MATCH (source)-[:HAS_PARENT]->(parent:Thing)
-			OPTIONAL MATCH (source)-[:IS_RELATED_TO]->(related:Thing)
-			OPTIONAL MATCH (source)-[:SUPERSEDED_BY]->(supersededBy:Thing)
-			OPTIONAL MATCH (source)-[:IMPLIED_BY]->(impliedBy:Thing)
-			OPTIONAL MATCH (source)-[:HAS_FOCUS]->(hasFocus:Thing)
-			OPTIONAL MATCH (source)-[:ISSUED_BY]->(issuer:Thing)
-			OPTIONAL MATCH (source)-[roleRel:HAS_ROLE]->(role:Thing)
-			OPTIONAL MATCH (source)-[:SUB_ORGANISATION_OF]->(parentOrg:Thing)
-			OPTIONAL MATCH (source)-[:COUNTRY_OF_OPERATIONS]->(coo:Thing)
-			OPTIONAL MATCH (source)-[:COUNTRY_OF_RISK]->(cor:Thing)
-			OPTIONAL MATCH (source)-[:COUNTRY_OF_INCORPORATION]->(coi:Thing)
-			WITH
-				collect(DISTINCT broader.uuid) as broaderUUIDs,
-				canonical,
-				issuer,
-				org,
-				parent,
-				person,
-				collect(DISTINCT related.uuid) as relatedUUIDs,
-				collect(DISTINCT supersededBy.uuid) as supersededByUUIDs,
-				collect(DISTINCT impliedBy.uuid) as impliedByUUIDs,
-				collect(DISTINCT hasFocus.uuid) as hasFocusUUIDs,
-				role,
-				roleRel,
-				parentOrg,
-				coo,
-				cor,
-				coi,
-				source
-				ORDER BY
-					source.uuid,
-					role.uuid
-			WITH
-				canonical,
-				issuer,
-				org,
-				person,
-				{
-					authority: source.authority,
-					authorityValue: source.authorityValue,
-					broaderUUIDs: broaderUUIDs,
-					supersededByUUIDs: supersededByUUIDs,
-					figiCode: source.figiCode,
-					issuedBy: issuer.uuid,
-					lastModifiedEpoch: source.lastModifiedEpoch,
-					membershipRoles: collect({
-						membershipRoleUUID: role.uuid,
-						inceptionDate: roleRel.inceptionDate,
-						terminationDate: roleRel.terminationDate,
-						inceptionDateEpoch: roleRel.inceptionDateEpoch,
-						terminationDateEpoch: roleRel.terminationDateEpoch
-					}),
-					organisationUUID: org.uuid,
-					parentUUIDs: collect(parent.uuid),
-					personUUID: person.uuid,
-					parentOrganisation: parentOrg.uuid,
-					prefLabel: source.prefLabel,
-					relatedUUIDs: relatedUUIDs,
-					impliedByUUIDs: impliedByUUIDs,
-					hasFocusUUIDs: hasFocusUUIDs,
-					types: labels(source),
-					uuid: source.uuid,
-					isDeprecated: source.isDeprecated,
-					countryOfIncorporationUUID: coi.uuid,
-					countryOfOperationsUUID: coo.uuid,
-					countryOfRiskUUID: cor.uuid
-				} as sources,
-				collect({
-					inceptionDate: roleRel.inceptionDate,
-					inceptionDateEpoch: roleRel.inceptionDateEpoch,
+//All the relationships and properties of the sources matched against each canonical node, which is expected to
+//be bound to "canonical" with each of its sources bound to "source", aggregated into a single row per canonical.
+//Each relationship of a source is read with its own pattern comprehension, so that a source with many relationships of
+//one type doesn't multiply the rows read for every other type. The memberships, issuer, organisation and person of the
+//concept are those of the first of its sources to have them.
+const readConceptReturnClause = `
+		WITH canonical, source
+			ORDER BY source.uuid
+		WITH canonical, collect({
+				authority: source.authority,
+				authorityValue: source.authorityValue,
+				broaderUUIDs: [(source)-[:HAS_BROADER]->(broader:Thing) | broader.uuid],
+				supersededByUUIDs: [(source)-[:SUPERSEDED_BY]->(supersededBy:Thing) | supersededBy.uuid],
+				figiCode: source.figiCode,
+				issuedBy: head([(source)-[:ISSUED_BY]->(issuer:Thing) | issuer.uuid]),
+				lastModifiedEpoch: source.lastModifiedEpoch,
+				membershipRoles: [(source)-[roleRel:HAS_ROLE]->(role:Thing) | {
 					membershipRoleUUID: role.uuid,
+					inceptionDate: roleRel.inceptionDate,
 					terminationDate: roleRel.terminationDate,
+					inceptionDateEpoch: roleRel.inceptionDateEpoch,
 					terminationDateEpoch: roleRel.terminationDateEpoch
-				}) as membershipRoles
-			RETURN
-				canonical.aggregateHash as aggregateHash,
-				canonical.aliases as aliases,
-				canonical.descriptionXML as descriptionXML,
-				canonical.emailAddress as emailAddress,
-				canonical.facebookPage as facebookPage,
-				canonical.figiCode as figiCode,
-				canonical.imageUrl as imageUrl,
-				canonical.inceptionDate as inceptionDate,
-				canonical.inceptionDateEpoch as inceptionDateEpoch,
-				canonical.prefLabel as prefLabel,
-				canonical.prefUUID as prefUUID,
-				canonical.scopeNote as scopeNote,
-				canonical.shortLabel as shortLabel,
-				canonical.strapline as strapline,
-				canonical.terminationDate as terminationDate,
-				canonical.terminationDateEpoch as terminationDateEpoch,
-				canonical.twitterHandle as twitterHandle,
-				collect(sources) as sourceRepresentations,
-				issuer.uuid as issuedBy,
-				labels(canonical) as types,
-				membershipRoles,
-				org.uuid as organisationUUID,
-				person.uuid as personUUID,
-				canonical.properName as properName,
-				canonical.shortName as shortName,
-				canonical.tradeNames as tradeNames,
-				canonical.formerNames as formerNames,
-				canonical.countryCode as countryCode,
-				canonical.countryOfIncorporation as countryOfIncorporation,
-				canonical.countryOfOperations as countryOfOperations,
-				canonical.countryOfRisk as countryOfRisk,
-				canonical.postalCode as postalCode,
-				canonical.yearFounded as yearFounded,
-				canonical.leiCode as leiCode,
-				canonical.isDeprecated as isDeprecated,
-				canonical.salutation as salutation,
-				canonical.birthYear as birthYear,
-				canonical.iso31661 as iso31661
-			`,
-		Parameters: map[string]interface{}{
-			"uuid": uuid,
-		},
-		Result: &results,
+				}],
+				organisationUUID: head([(source)-[:HAS_ORGANISATION]->(org:Thing) | org.uuid]),
+				parentUUIDs: [(source)-[:HAS_PARENT]->(parent:Thing) | parent.uuid],
+				personUUID: head([(source)-[:HAS_MEMBER]->(person:Thing) | person.uuid]),
+				parentOrganisation: head([(source)-[:SUB_ORGANISATION_OF]->(parentOrg:Thing) | parentOrg.uuid]),
+				prefLabel: source.prefLabel,
+				relatedUUIDs: [(source)-[:IS_RELATED_TO]->(related:Thing) | related.uuid],
+				impliedByUUIDs: [(source)-[:IMPLIED_BY]->(impliedBy:Thing) | impliedBy.uuid],
+				hasFocusUUIDs: [(source)-[:HAS_FOCUS]->(hasFocus:Thing) | hasFocus.uuid],
+				types: labels(source),
+				uuid: source.uuid,
+				isDeprecated: source.isDeprecated,
+				countryOfIncorporationUUID: head([(source)-[:COUNTRY_OF_INCORPORATION]->(coi:Thing) | coi.uuid]),
+				countryOfOperationsUUID: head([(source)-[:COUNTRY_OF_OPERATIONS]->(coo:Thing) | coo.uuid]),
+				countryOfRiskUUID: head([(source)-[:COUNTRY_OF_RISK]->(cor:Thing) | cor.uuid])
+			}) as sources
+		RETURN
+			canonical.aggregateHash as aggregateHash,
+			canonical.aggregateHashVersion as aggregateHashVersion,
+			canonical.aliases as aliases,
+			canonical.descriptionXML as descriptionXML,
+			canonical.emailAddress as emailAddress,
+			canonical.facebookPage as facebookPage,
+			canonical.figiCode as figiCode,
+			canonical.imageUrl as imageUrl,
+			canonical.inceptionDate as inceptionDate,
+			canonical.inceptionDateEpoch as inceptionDateEpoch,
+			canonical.prefLabel as prefLabel,
+			canonical.prefUUID as prefUUID,
+			canonical.scopeNote as scopeNote,
+			canonical.shortLabel as shortLabel,
+			canonical.strapline as strapline,
+			canonical.terminationDate as terminationDate,
+			canonical.terminationDateEpoch as terminationDateEpoch,
+			canonical.twitterHandle as twitterHandle,
+			sources as sourceRepresentations,
+			head([s IN sources WHERE s.issuedBy IS NOT NULL | s.issuedBy]) as issuedBy,
+			labels(canonical) as types,
+			coalesce(head([s IN sources WHERE size(s.membershipRoles) > 0 | s.membershipRoles]), []) as membershipRoles,
+			head([s IN sources WHERE s.organisationUUID IS NOT NULL | s.organisationUUID]) as organisationUUID,
+			head([s IN sources WHERE s.personUUID IS NOT NULL | s.personUUID]) as personUUID,
+			canonical.properName as properName,
+			canonical.shortName as shortName,
+			canonical.tradeNames as tradeNames,
+			canonical.formerNames as formerNames,
+			canonical.countryCode as countryCode,
+			canonical.countryOfIncorporation as countryOfIncorporation,
+			canonical.countryOfOperations as countryOfOperations,
+			canonical.countryOfRisk as countryOfRisk,
+			canonical.postalCode as postalCode,
+			canonical.yearFounded as yearFounded,
+			canonical.leiCode as leiCode,
+			canonical.isDeprecated as isDeprecated,
+			canonical.salutation as salutation,
+			canonical.birthYear as birthYear,
+			canonical.iso31661 as iso31661
+		ORDER BY prefUUID`
+
+//Read - read service, which reads from the cache if it is enabled
+func (s *ConceptService) Read(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "ConceptService.Read", transactionIDKey.String(transID), prefUUIDKey.String(uuid))
+	concept, found, err := s.readCached(ctx, uuid, transID)
+	conceptType := unknownConceptType
+	if found {
+		conceptType = concept.(AggregatedConcept).Type
+		span.SetAttributes(conceptTypeKey.String(conceptType))
+	}
+	endSpan(span, err)
+	s.metrics.readDuration.WithLabelValues(conceptType).Observe(time.Since(start).Seconds())
+	return concept, found, err
+}
+
+func (s *ConceptService) readCached(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+	if s.cache == nil {
+		return s.read(ctx, uuid, transID)
 	}
 
-	err := s.conn.CypherBatch([]*neoism.CypherQuery{query})
+	cached, found, generation := s.cache.get(uuid)
+	if found {
+		logger.WithTransactionID(transID).WithUUID(uuid).Debug("Returned concept from cache")
+		return cached, true, nil
+	}
+	concept, found, err := s.read(ctx, uuid, transID)
+	if err == nil && found {
+		s.cache.add(concept.(AggregatedConcept), generation)
+	}
+	return concept, found, err
+}
+
+//Read the concept from neo4j. Writes always read the stored concept this way, as a concept cached before another
+//instance changed it would fail every write's checks that the concept is still as it was read.
+func (s *ConceptService) read(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+	aggregatedConcept, found, err := s.store.readConcept(ctx, uuid, transID)
 	if err != nil {
-		logger.WithError(err).WithTransactionID(transID).WithUUID(uuid).Error("Error executing neo4j read query")
 		return AggregatedConcept{}, false, err
 	}
-
-	if len(results) == 0 {
+	if !found {
 		logger.WithTransactionID(transID).WithUUID(uuid).Info("Concept not found in db")
 		return AggregatedConcept{}, false, nil
 	}
-	typeName, err := mapper.MostSpecificType(results[0].Types)
+	logger.WithTransactionID(transID).WithUUID(uuid).Debugf("Returned concept is %v", aggregatedConcept)
+	return aggregatedConcept, true, nil
+}
+
+// Export - returns a page of at most limit canonical concepts of the given type, ordered by prefUUID and starting
+// after the given prefUUID, along with the prefUUID to start the next page after. An empty after starts from the
+// beginning, and an empty next means there are no more pages.
+func (s *ConceptService) Export(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+	if prop, ok := constraintMap[conceptType]; !ok || prop != "uuid" {
+		return nil, "", requestError{formatError("recognised type", conceptType, transID)}
+	}
+	return s.store.exportConcepts(ctx, conceptType, after, limit, transID)
+}
+
+func buildAggregatedConcept(result neoAggregatedConcept, transID string) (AggregatedConcept, error) {
+	typeName, err := mapper.MostSpecificType(result.Types)
 	if err != nil {
-		logger.WithError(err).WithTransactionID(transID).WithUUID(uuid).Error("Returned concept had no recognized type")
-		return AggregatedConcept{}, false, err
+		logger.WithError(err).WithTransactionID(transID).WithUUID(result.PrefUUID).Error("Returned concept had no recognized type")
+		return AggregatedConcept{}, err
 	}
 
 	aggregatedConcept := AggregatedConcept{
-		AggregatedHash:   results[0].AggregateHash,
-		Aliases:          results[0].Aliases,
-		DescriptionXML:   results[0].DescriptionXML,
-		EmailAddress:     results[0].EmailAddress,
-		FacebookPage:     results[0].FacebookPage,
-		FigiCode:         results[0].FigiCode,
-		ImageURL:         results[0].ImageURL,
-		InceptionDate:    results[0].InceptionDate,
-		IssuedBy:         results[0].IssuedBy,
-		MembershipRoles:  cleanMembershipRoles(results[0].MembershipRoles),
-		OrganisationUUID: results[0].OrganisationUUID,
-		PersonUUID:       results[0].PersonUUID,
-		PrefLabel:        results[0].PrefLabel,
-		PrefUUID:         results[0].PrefUUID,
-		ScopeNote:        results[0].ScopeNote,
-		ShortLabel:       results[0].ShortLabel,
-		Strapline:        results[0].Strapline,
-		TerminationDate:  results[0].TerminationDate,
-		TwitterHandle:    results[0].TwitterHandle,
+		AggregatedHash:        result.AggregateHash,
+		AggregatedHashVersion: result.AggregateHashVersion,
+		Aliases:          result.Aliases,
+		DescriptionXML:   result.DescriptionXML,
+		EmailAddress:     result.EmailAddress,
+		FacebookPage:     result.FacebookPage,
+		FigiCode:         result.FigiCode,
+		ImageURL:         result.ImageURL,
+		InceptionDate:    result.InceptionDate,
+		IssuedBy:         result.IssuedBy,
+		MembershipRoles:  cleanMembershipRoles(result.MembershipRoles),
+		OrganisationUUID: result.OrganisationUUID,
+		PersonUUID:       result.PersonUUID,
+		PrefLabel:        result.PrefLabel,
+		PrefUUID:         result.PrefUUID,
+		ScopeNote:        result.ScopeNote,
+		ShortLabel:       result.ShortLabel,
+		Strapline:        result.Strapline,
+		TerminationDate:  result.TerminationDate,
+		TwitterHandle:    result.TwitterHandle,
 		Type:             typeName,
-		IsDeprecated:     results[0].IsDeprecated,
+		IsDeprecated:     result.IsDeprecated,
 		// Organisations
-		ProperName:             results[0].ProperName,
-		ShortName:              results[0].ShortName,
-		TradeNames:             results[0].TradeNames,
-		FormerNames:            results[0].FormerNames,
-		CountryCode:            results[0].CountryCode,
-		CountryOfIncorporation: results[0].CountryOfIncorporation,
-		CountryOfRisk:          results[0].CountryOfRisk,
-		CountryOfOperations:    results[0].CountryOfOperations,
-		PostalCode:             results[0].PostalCode,
-		YearFounded:            results[0].YearFounded,
-		LeiCode:                results[0].LeiCode,
+		ProperName:             result.ProperName,
+		ShortName:              result.ShortName,
+		TradeNames:             result.TradeNames,
+		FormerNames:            result.FormerNames,
+		CountryCode:            result.CountryCode,
+		CountryOfIncorporation: result.CountryOfIncorporation,
+		CountryOfRisk:          result.CountryOfRisk,
+		CountryOfOperations:    result.CountryOfOperations,
+		PostalCode:             result.PostalCode,
+		YearFounded:            result.YearFounded,
+		LeiCode:                result.LeiCode,
 		// Person
-		Salutation: results[0].Salutation,
-		BirthYear:  results[0].BirthYear,
+		Salutation: result.Salutation,
+		BirthYear:  result.BirthYear,
 		// Location
-		ISO31661: results[0].ISO31661,
+		ISO31661: result.ISO31661,
 	}
 
 	var sourceConcepts []Concept
-	for _, srcConcept := range results[0].SourceRepresentations {
+	for _, srcConcept := range result.SourceRepresentations {
 		conceptType, err := mapper.MostSpecificType(srcConcept.Types)
 		if err != nil {
-			logger.WithError(err).WithTransactionID(transID).WithUUID(uuid).Error("Returned source concept had no recognized type")
-			return AggregatedConcept{}, false, err
+			logger.WithError(err).WithTransactionID(transID).WithUUID(result.PrefUUID).Error("Returned source concept had no recognized type")
+			return AggregatedConcept{}, err
 		}
 
 		concept := Concept{
@@ -412,55 +431,102 @@ func (s *ConceptService) Read(uuid string, transID string) (interface{}, bool, e
 	}
 
 	aggregatedConcept.SourceRepresentations = sourceConcepts
-	logger.WithTransactionID(transID).WithUUID(uuid).Debugf("Returned concept is %v", aggregatedConcept)
-	return cleanConcept(aggregatedConcept), true, nil
+	return cleanConcept(aggregatedConcept), nil
 }
 
-func (s *ConceptService) Write(thing interface{}, transID string) (interface{}, error) {
+func (s *ConceptService) Write(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+	return s.WriteWithOptions(ctx, thing, transID, WriteOptions{})
+}
+
+// WriteWithOptions - Write with support for dry runs and conditional writes
+func (s *ConceptService) WriteWithOptions(ctx context.Context, thing interface{}, transID string, options WriteOptions) (_ interface{}, err error) {
 	// Read the aggregated concept - We need read the entire model first. This is because if we unconcord a TME concept
 	// then we need to add prefUUID to the lone node if it has been removed from the concordance listed against a Smartlogic concept
 	updateRecord := ConceptChanges{}
 	var updatedUUIDList []string
 	aggregatedConceptToWrite := thing.(AggregatedConcept)
+	ctx, span := startSpan(ctx, "ConceptService.Write",
+		transactionIDKey.String(transID),
+		prefUUIDKey.String(aggregatedConceptToWrite.PrefUUID),
+		sourceCountKey.Int(len(aggregatedConceptToWrite.SourceRepresentations)),
+	)
+	//The type is only used as a label once it is known to be valid, so that a client can't make up new ones
+	conceptType := unknownConceptType
+	defer func(start time.Time) {
+		endSpan(span, err)
+		s.metrics.writeDuration.WithLabelValues(conceptType).Observe(time.Since(start).Seconds())
+	}(time.Now())
 	aggregatedConceptToWrite = cleanSourceProperties(aggregatedConceptToWrite)
 	requestSourceData := getSourceData(aggregatedConceptToWrite.SourceRepresentations)
 
-	requestHash, err := hashstructure.Hash(aggregatedConceptToWrite, nil)
+	hashAsString, err := hashConcept(aggregatedConceptToWrite, currentHashVersion)
 	if err != nil {
 		logger.WithError(err).WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Error("Error hashing json from request")
 		return updateRecord, err
 	}
-
-	hashAsString := strconv.FormatUint(requestHash, 10)
+	//Stored hashes of the legacy version were taken before the concept was processed for writing, which sets the epochs
+	//of its dates and membership roles, so the request is hashed the same way now rather than once it has been processed
+	legacyHashAsString, legacyHashErr := hashConcept(aggregatedConceptToWrite, legacyHashVersion)
 
 	if err = validateObject(aggregatedConceptToWrite, transID); err != nil {
 		return updateRecord, err
 	}
+	conceptType = aggregatedConceptToWrite.Type
+	span.SetAttributes(conceptTypeKey.String(conceptType))
 
-	existingConcept, exists, err := s.Read(aggregatedConceptToWrite.PrefUUID, transID)
+	var requestSourceUUIDs []string
+	for sourceUUID := range requestSourceData {
+		requestSourceUUIDs = append(requestSourceUUIDs, sourceUUID)
+	}
+	readCtx, readSpan := startSpan(ctx, "Write.readExisting")
+	existingConcept, exists, unlock, err := s.readLocked(readCtx, aggregatedConceptToWrite.PrefUUID, requestSourceUUIDs, transID)
+	endSpan(readSpan, err)
 	if err != nil {
 		logger.WithError(err).WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Error("Read request for existing concordance resulted in error")
 		return updateRecord, err
 	}
+	defer unlock()
+
+	if options.Precondition != nil {
+		existingHash := ""
+		if exists {
+			existingHash = existingConcept.(AggregatedConcept).AggregatedHash
+		}
+		if !options.Precondition.isSatisfiedBy(exists, existingHash) {
+			return updateRecord, newPreconditionError(aggregatedConceptToWrite.PrefUUID, transID)
+		}
+	}
 
 	aggregatedConceptToWrite = processMembershipRoles(aggregatedConceptToWrite).(AggregatedConcept)
 
-	var queryBatch []*neoism.CypherQuery
-	var prefUUIDsToBeDeletedQueryBatch []*neoism.CypherQuery
+	write := conceptWrite{
+		prefUUID:     aggregatedConceptToWrite.PrefUUID,
+		precondition: options.Precondition,
+	}
 	if exists {
 		existingAggregateConcept := existingConcept.(AggregatedConcept)
-		if existingAggregateConcept.AggregatedHash == "" {
-			existingAggregateConcept.AggregatedHash = "0"
-		}
-		currentHash, err := strconv.ParseUint(existingAggregateConcept.AggregatedHash, 10, 64)
-		if err != nil {
-			logger.WithError(err).WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Info("Error whilst parsing existing concept hash")
-			return updateRecord, nil
+		write.sourceCount = len(existingAggregateConcept.SourceRepresentations)
+		write.aggregateHash = existingAggregateConcept.AggregatedHash
+
+		//A stored hash of an older version is compared with the request hashed the same way, so that concepts
+		//are only rewritten with the current version of the hash once they have actually changed
+		requestHashOfStoredVersion := hashAsString
+		if existingAggregateConcept.AggregatedHashVersion != currentHashVersion {
+			hashErr := legacyHashErr
+			requestHashOfStoredVersion = legacyHashAsString
+			if version := existingAggregateConcept.AggregatedHashVersion; version != 0 && version != legacyHashVersion {
+				hashErr = fmt.Errorf("Unknown aggregate hash version: %d", version)
+			}
+			if hashErr != nil {
+				logger.WithError(hashErr).WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Info("Error whilst hashing concept to compare with existing concept hash")
+				requestHashOfStoredVersion = hashAsString
+			}
 		}
-		logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Debugf("Currently stored concept has hash of %d", currentHash)
-		logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Debugf("Aggregated concept has hash of %d", requestHash)
-		if currentHash == requestHash {
+		logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Debugf("Currently stored concept has hash of %s (version %d)", existingAggregateConcept.AggregatedHash, existingAggregateConcept.AggregatedHashVersion)
+		logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Debugf("Aggregated concept has hash of %s", requestHashOfStoredVersion)
+		if existingAggregateConcept.AggregatedHash == requestHashOfStoredVersion {
 			logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Info("This concept has not changed since most recent update")
+			s.metrics.unchanged.WithLabelValues(aggregatedConceptToWrite.Type).Inc()
 			return updateRecord, nil
 		}
 		logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Info("This concept is different to record stored in db, updating...")
@@ -481,18 +547,14 @@ func (s *ConceptService) Write(thing interface{}, transID string) (interface{},
 
 		//Handle scenarios for transferring source id from an existing concordance to this concordance
 		if len(conceptsToTransferConcordance) > 0 {
-			prefUUIDsToBeDeletedQueryBatch, err = s.handleTransferConcordance(conceptsToTransferConcordance, &updateRecord, hashAsString, aggregatedConceptToWrite, transID)
+			write.deletedCanonicals, write.transferred, err = s.handleTransferConcordance(ctx, conceptsToTransferConcordance, &updateRecord, hashAsString, aggregatedConceptToWrite, transID)
 			if err != nil {
-				return updateRecord, err
+				//Nothing has been written, so the events of the sources checked before the one that failed never happened
+				return ConceptChanges{}, err
 			}
 
 		}
 
-		clearDownQuery := s.clearDownExistingNodes(aggregatedConceptToWrite)
-		for _, query := range clearDownQuery {
-			queryBatch = append(queryBatch, query)
-		}
-
 		for idToUnconcord := range conceptsToUnconcord {
 			for _, concept := range existingAggregateConcept.SourceRepresentations {
 				if idToUnconcord == concept.UUID {
@@ -500,8 +562,7 @@ func (s *ConceptService) Write(thing interface{}, transID string) (interface{},
 					//set this to 0 as otherwise it is empty
 					//TODO fix this up at some point to do it properly?
 					concept.Hash = "0"
-					unconcordQuery := s.writeCanonicalNodeForUnconcordedConcepts(concept)
-					queryBatch = append(queryBatch, unconcordQuery)
+					write.unconcorded = append(write.unconcorded, concept)
 
 					//We will need to send a notification of ids that have been removed from current concordance
 					updatedUUIDList = append(updatedUUIDList, idToUnconcord)
@@ -522,14 +583,9 @@ func (s *ConceptService) Write(thing interface{}, transID string) (interface{},
 			}
 		}
 	} else {
-		prefUUIDsToBeDeletedQueryBatch, err = s.handleTransferConcordance(requestSourceData, &updateRecord, hashAsString, aggregatedConceptToWrite, transID)
+		write.deletedCanonicals, write.transferred, err = s.handleTransferConcordance(ctx, requestSourceData, &updateRecord, hashAsString, aggregatedConceptToWrite, transID)
 		if err != nil {
-			return updateRecord, err
-		}
-
-		clearDownQuery := s.clearDownExistingNodes(aggregatedConceptToWrite)
-		for _, query := range clearDownQuery {
-			queryBatch = append(queryBatch, query)
+			return ConceptChanges{}, err
 		}
 
 		//Concept is new, send notification of all source ids
@@ -538,11 +594,8 @@ func (s *ConceptService) Write(thing interface{}, transID string) (interface{},
 		}
 	}
 
-	for _, query := range prefUUIDsToBeDeletedQueryBatch {
-		queryBatch = append(queryBatch, query)
-	}
 	aggregatedConceptToWrite.AggregatedHash = hashAsString
-	queryBatch = populateConceptQueries(queryBatch, aggregatedConceptToWrite)
+	write.concept = &aggregatedConceptToWrite
 
 	updateRecord.UpdatedIds = updatedUUIDList
 	updateRecord.ChangedRecords = append(updateRecord.ChangedRecords, Event{
@@ -555,79 +608,340 @@ func (s *ConceptService) Write(thing interface{}, transID string) (interface{},
 		},
 	})
 
-	logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Debug("Executing " + strconv.Itoa(len(queryBatch)) + " queries")
-	for _, query := range queryBatch {
-		logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Debug(fmt.Sprintf("Query: %v", query))
-	}
-
 	// check that the issuer is not already related to a different org
 	if aggregatedConceptToWrite.IssuedBy != "" {
-		var fiRes []map[string]string
-		issuerQuery := &neoism.CypherQuery{
-			Statement: `
-					MATCH (issuer:Thing {uuid: {issuerUUID}})<-[:ISSUED_BY]-(fi)
-					RETURN fi.uuid AS fiUUID
-				`,
-			Parameters: map[string]interface{}{
-				"issuerUUID": aggregatedConceptToWrite.IssuedBy,
-			},
-			Result: &fiRes,
-		}
-		if err := s.conn.CypherBatch([]*neoism.CypherQuery{issuerQuery}); err != nil {
-			logger.WithError(err).
-				WithTransactionID(transID).
-				WithUUID(aggregatedConceptToWrite.PrefUUID).
-				Error("Could not get existing issuer.")
+		issuerCtx, issuerSpan := startSpan(ctx, "Write.issuerCheck")
+		fiUUIDs, err := s.store.readIssued(issuerCtx, aggregatedConceptToWrite.IssuedBy, transID)
+		endSpan(issuerSpan, err)
+		if err != nil {
 			return updateRecord, err
 		}
 
-		if len(fiRes) > 0 {
-			for _, fi := range fiRes {
-				fiUUID, ok := fi["fiUUID"]
-				if !ok {
-					continue
-				}
-
-				if fiUUID == aggregatedConceptToWrite.PrefUUID {
-					continue
-				}
-
-				msg := fmt.Sprintf(
-					"Issuer for %s was changed from %s to %s",
-					aggregatedConceptToWrite.IssuedBy,
-					fiUUID,
-					aggregatedConceptToWrite.PrefUUID,
-				)
-				logger.WithTransactionID(transID).
-					WithUUID(aggregatedConceptToWrite.PrefUUID).
-					WithField("alert_tag", "ConceptLoadingLedToDifferentIssuer").Info(msg)
-
-				deleteIssuerRelations := &neoism.CypherQuery{
-					Statement: `
-					MATCH (issuer:Thing {uuid: {issuerUUID}})
-					MATCH (fi:Thing {uuid: {fiUUID}})
-					MATCH (issuer)<-[issuerRel:ISSUED_BY]-(fi)
-					DELETE issuerRel
-				`,
-					Parameters: map[string]interface{}{
-						"issuerUUID": aggregatedConceptToWrite.IssuedBy,
-						"fiUUID":     fiUUID,
-					},
-				}
-				queryBatch = append(queryBatch, deleteIssuerRelations)
+		for _, fiUUID := range fiUUIDs {
+			if fiUUID == aggregatedConceptToWrite.PrefUUID {
+				continue
 			}
+
+			msg := fmt.Sprintf(
+				"Issuer for %s was changed from %s to %s",
+				aggregatedConceptToWrite.IssuedBy,
+				fiUUID,
+				aggregatedConceptToWrite.PrefUUID,
+			)
+			logger.WithTransactionID(transID).
+				WithUUID(aggregatedConceptToWrite.PrefUUID).
+				WithField("alert_tag", alertLedToDifferentIssuer).Info(msg)
+			s.metrics.alert(alertLedToDifferentIssuer)
+			write.reissued = append(write.reissued, fiUUID)
 		}
 	}
 
-	if err = s.conn.CypherBatch(queryBatch); err != nil {
+	if options.DryRun {
+		logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Info("Dry run requested. Concept NOT written.")
+		return updateRecord, nil
+	}
+
+	write.events = updateRecord.ChangedRecords
+	commitCtx, commitSpan := startSpan(ctx, "Write.commit")
+	err = s.store.write(commitCtx, write, transID)
+	endSpan(commitSpan, err)
+	// a failed write is invalidated as well, in case it failed because the cached concept is out of date
+	s.invalidateCache(updateRecord, append(write.reissued, aggregatedConceptToWrite.PrefUUID)...)
+	if err != nil {
 		logger.WithError(err).WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Error("Error executing neo4j write queries. Concept NOT written.")
+		if options.Precondition != nil {
+			// the guard queries fail the batch if the concept was changed by another writer after it was read
+			current, exists, readErr := s.read(ctx, aggregatedConceptToWrite.PrefUUID, transID)
+			if readErr == nil && !options.Precondition.isSatisfiedBy(exists, current.(AggregatedConcept).AggregatedHash) {
+				return updateRecord, newPreconditionError(aggregatedConceptToWrite.PrefUUID, transID)
+			}
+		}
 		return updateRecord, err
 	}
 
 	logger.WithTransactionID(transID).WithUUID(aggregatedConceptToWrite.PrefUUID).Info("Concept written to db")
+	s.publishEvents(updateRecord.ChangedRecords, aggregatedConceptToWrite.PrefUUID, transID)
 	return updateRecord, nil
 }
 
+// Delete - removes the canonical node for the given prefUUID. The source which shares its prefUUID goes with it, as it
+// would for a lone concept, and is no longer read as a concept. Every other source concorded to it is given its own lone
+// canonical node, exactly as if it had been unconcorded by a write.
+func (s *ConceptService) Delete(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+	updateRecord := ConceptChanges{}
+
+	existingConcept, exists, unlock, err := s.readLocked(ctx, uuid, nil, transID)
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(uuid).Error("Read request for existing concordance resulted in error")
+		return updateRecord, false, err
+	}
+	defer unlock()
+	if !exists {
+		return updateRecord, false, nil
+	}
+
+	dependants, err := s.store.readDependants(ctx, uuid, transID)
+	if err != nil {
+		return updateRecord, true, err
+	}
+	if len(dependants) > 0 {
+		err := dependantsConflictError{prefUUID: uuid, dependants: dependants}
+		logger.WithTransactionID(transID).WithUUID(uuid).Info(err.Error())
+		return updateRecord, true, err
+	}
+
+	existingAggregateConcept := existingConcept.(AggregatedConcept)
+	write := conceptWrite{
+		prefUUID:          uuid,
+		sourceCount:       len(existingAggregateConcept.SourceRepresentations),
+		aggregateHash:     existingAggregateConcept.AggregatedHash,
+		deletedCanonicals: []string{uuid},
+	}
+	var updatedUUIDList []string
+	for _, concept := range existingAggregateConcept.SourceRepresentations {
+		updatedUUIDList = append(updatedUUIDList, concept.UUID)
+		if concept.UUID == uuid {
+			continue
+		}
+
+		concept.Hash = "0"
+		write.unconcorded = append(write.unconcorded, concept)
+		updateRecord.ChangedRecords = append(updateRecord.ChangedRecords, Event{
+			ConceptType:   concept.Type,
+			ConceptUUID:   concept.UUID,
+			AggregateHash: existingAggregateConcept.AggregatedHash,
+			TransactionID: transID,
+			EventDetails: ConcordanceEvent{
+				Type:  RemovedEvent,
+				OldID: uuid,
+				NewID: concept.UUID,
+			},
+		})
+	}
+	updateRecord.UpdatedIds = updatedUUIDList
+
+	write.events = updateRecord.ChangedRecords
+	err = s.store.write(ctx, write, transID)
+	s.invalidateCache(updateRecord, uuid)
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(uuid).Error("Error executing neo4j delete queries. Concept NOT deleted.")
+		return updateRecord, true, err
+	}
+
+	logger.WithTransactionID(transID).WithUUID(uuid).Info("Concept deleted from db")
+	s.publishEvents(updateRecord.ChangedRecords, uuid, transID)
+	return updateRecord, true, nil
+}
+
+//Drop every concept a write or delete may have changed from the cache: the given concepts, those of its updated ids and
+//events, and both the old and new concepts of every change of concordance, which include every transferred or
+//unconcorded source and every lone canonical node that has been removed
+func (s *ConceptService) invalidateCache(changes ConceptChanges, prefUUIDs ...string) {
+	if s.cache == nil {
+		return
+	}
+	prefUUIDs = append(prefUUIDs, changes.UpdatedIds...)
+	for _, event := range changes.ChangedRecords {
+		prefUUIDs = append(prefUUIDs, event.ConceptUUID)
+		if concordance, ok := event.EventDetails.(ConcordanceEvent); ok {
+			prefUUIDs = append(prefUUIDs, concordance.OldID, concordance.NewID)
+		}
+	}
+	s.cache.invalidate(prefUUIDs...)
+}
+
+//The write has already been committed, with its events in the outbox, so a failure to publish them is logged rather than
+//failing the request
+func (s *ConceptService) publishEvents(events []Event, prefUUID string, transID string) {
+	s.metrics.countEvents(events)
+	if len(events) == 0 || s.publisher == nil {
+		return
+	}
+	if err := s.publisher.Publish(events, transID); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(prefUUID).Error("Could not publish events for concept")
+	}
+}
+
+// ResolveIdentifier - returns the concepts identified either by an authority value, through the identifier nodes
+// written alongside each source, or by one of the natural keys held on canonical nodes
+func (s *ConceptService) ResolveIdentifier(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error) {
+	_, isIdentifier := authorityToIdentifierLabelMap[authority]
+	_, isIdentifierKey := naturalKeyToIdentifierLabelMap[authority]
+	_, isNaturalKey := naturalKeyToLabelMap[authority]
+	if !isIdentifier && !isIdentifierKey && !isNaturalKey {
+		return []IdentifierResolution{}, false, requestError{formatError("recognised authority or natural key", authorityValue, transID)}
+	}
+
+	results, err := s.store.readIdentified(ctx, authority, authorityValue, transID)
+	if err != nil {
+		return []IdentifierResolution{}, false, err
+	}
+
+	resolutions := []IdentifierResolution{}
+	for _, result := range results {
+		conceptType, err := mapper.MostSpecificType(result.Types)
+		if err != nil {
+			logger.WithError(err).WithTransactionID(transID).WithUUID(result.UUID).Error("Identified concept had no recognized type")
+			return []IdentifierResolution{}, false, err
+		}
+		resolutions = append(resolutions, IdentifierResolution{
+			UUID:     result.UUID,
+			PrefUUID: result.PrefUUID,
+			Type:     conceptType,
+		})
+	}
+	return resolutions, len(resolutions) > 0, nil
+}
+
+func identifierResolutionStatement(identifierLabel string) string {
+	return fmt.Sprintf(`
+		MATCH (i:Identifier:%s {value:$value})-[:IDENTIFIES]->(source:Concept)
+		OPTIONAL MATCH (source)-[:EQUIVALENT_TO]->(canonical:Thing)
+		RETURN DISTINCT source.uuid as uuid, canonical.prefUUID as prefUUID, coalesce(labels(canonical), labels(source)) as types
+		ORDER BY uuid`, identifierLabel)
+}
+
+func (p Precondition) isSatisfiedBy(exists bool, aggregateHash string) bool {
+	if len(p.IfMatch) > 0 {
+		if !exists || !(stringInArr("*", p.IfMatch) || stringInArr(aggregateHash, p.IfMatch)) {
+			return false
+		}
+	}
+	if len(p.IfNoneMatch) > 0 {
+		if exists && (stringInArr("*", p.IfNoneMatch) || stringInArr(aggregateHash, p.IfNoneMatch)) {
+			return false
+		}
+	}
+	return true
+}
+
+//Queries that fail the whole batch if the stored canonical node no longer satisfies the precondition. They are run
+//first in the batch so that the canonical node is locked until the rest of the write has been committed
+func preconditionGuardQueries(prefUUID string, p Precondition) []*neoism.CypherQuery {
+	if stringInArr("*", p.IfNoneMatch) {
+		// Creating the node takes the uniqueness constraint lock and fails if another writer created it first
+		return []*neoism.CypherQuery{
+			{
+				Statement: `CREATE (c:Thing {prefUUID:$prefUUID})`,
+				Parameters: map[string]interface{}{
+					"prefUUID": prefUUID,
+				},
+			},
+		}
+	}
+
+	// an empty ifMatch list only requires the node to exist
+	ifMatch := []string{}
+	if !stringInArr("*", p.IfMatch) {
+		ifMatch = append(ifMatch, p.IfMatch...)
+	}
+	ifNoneMatch := append([]string{}, p.IfNoneMatch...)
+
+	return []*neoism.CypherQuery{
+		{
+			Statement: `
+				OPTIONAL MATCH (c:Thing {prefUUID:$prefUUID})
+				FOREACH (n IN CASE WHEN c IS NULL THEN [] ELSE [c] END | SET n._lock = true REMOVE n._lock)
+				WITH c
+				WHERE ($mustExist AND c IS NULL)
+					OR (size($ifMatch) > 0 AND NOT coalesce(c.aggregateHash, "") IN $ifMatch)
+					OR (c IS NOT NULL AND coalesce(c.aggregateHash, "") IN $ifNoneMatch)
+				RETURN 1/0 AS preconditionFailed`,
+			Parameters: map[string]interface{}{
+				"prefUUID":    prefUUID,
+				"mustExist":   len(p.IfMatch) > 0,
+				"ifMatch":     ifMatch,
+				"ifNoneMatch": ifNoneMatch,
+			},
+		},
+	}
+}
+
+//Query that fails the whole batch if the canonical node no longer has the number of sources or the aggregate hash it
+//was read with, locking it so that no other writer can change the concept or concord or unconcord its sources until the
+//batch has been committed. As only the differences from the concept as read are written, it mustn't have changed since.
+func concordanceGuardQuery(prefUUID string, sourceCount int, aggregateHash string) *neoism.CypherQuery {
+	return &neoism.CypherQuery{
+		Statement: `
+			OPTIONAL MATCH (c:Thing {prefUUID:$prefUUID})
+			FOREACH (n IN CASE WHEN c IS NULL THEN [] ELSE [c] END | SET n._lock = true REMOVE n._lock)
+			WITH c
+			OPTIONAL MATCH (c)<-[eq:EQUIVALENT_TO]-(:Thing)
+			WITH c, count(DISTINCT eq) AS count
+			WHERE count <> $count OR coalesce(c.aggregateHash, "") <> $aggregateHash
+			RETURN 1/0 AS equivalenceChanged`,
+		Parameters: map[string]interface{}{
+			"prefUUID":      prefUUID,
+			"count":         sourceCount,
+			"aggregateHash": aggregateHash,
+		},
+	}
+}
+
+//Query that fails the whole batch if the source is no longer concorded as it was when its equivalence was read,
+//locking it and its canonical node until the batch has been committed
+func equivalenceGuardQuery(sourceUUID string, read []equivalenceResult) *neoism.CypherQuery {
+	exists := len(read) == 1
+	prefUUID := ""
+	count := 0
+	if exists {
+		prefUUID = read[0].PrefUUID
+		count = read[0].Equivalence
+	}
+	return &neoism.CypherQuery{
+		Statement: `
+			OPTIONAL MATCH (t:Thing {uuid:$id})
+			OPTIONAL MATCH (t)-[:EQUIVALENT_TO]->(c)
+			FOREACH (n IN [x IN [t, c] WHERE x IS NOT NULL] | SET n._lock = true REMOVE n._lock)
+			WITH t, c
+			OPTIONAL MATCH (c)<-[eq:EQUIVALENT_TO]-(:Thing)
+			WITH t, c, count(DISTINCT eq) AS count
+			WHERE (t IS NULL) = $exists
+				OR coalesce(c.prefUUID, "") <> $prefUUID
+				OR count <> $count
+			RETURN 1/0 AS equivalenceChanged`,
+		Parameters: map[string]interface{}{
+			"id":       sourceUUID,
+			"exists":   exists,
+			"prefUUID": prefUUID,
+			"count":    count,
+		},
+	}
+}
+
+//Lock the prefUUID and every source uuid of its concordance, both those being written and those currently stored, then
+//read the stored concept. Writes to overlapping concordances are run one at a time, rather than each acting on a
+//snapshot that the other is about to change. The returned function releases the locks.
+func (s *ConceptService) readLocked(ctx context.Context, prefUUID string, sourceUUIDs []string, transID string) (interface{}, bool, func(), error) {
+	keys := append([]string{prefUUID}, sourceUUIDs...)
+	for {
+		unlock, err := s.locks.lock(ctx, keys)
+		if err != nil {
+			return AggregatedConcept{}, false, nil, err
+		}
+		existingConcept, exists, err := s.read(ctx, prefUUID, transID)
+		if err != nil {
+			unlock()
+			return existingConcept, exists, nil, err
+		}
+		if !exists {
+			return existingConcept, exists, unlock, nil
+		}
+
+		var storedSourceUUIDs []string
+		for _, source := range existingConcept.(AggregatedConcept).SourceRepresentations {
+			storedSourceUUIDs = append(storedSourceUUIDs, source.UUID)
+		}
+		if keysHeld(storedSourceUUIDs, keys) {
+			return existingConcept, exists, unlock, nil
+		}
+
+		// sources were concorded to it since the locks were taken, so they are locked as well before reading it again
+		unlock()
+		keys = append(keys, storedSourceUUIDs...)
+	}
+}
+
 func validateObject(aggConcept AggregatedConcept, transID string) error {
 	if aggConcept.PrefLabel == "" {
 		return requestError{formatError("prefLabel", aggConcept.PrefUUID, transID)}
@@ -674,29 +988,23 @@ func filterIdsThatAreUniqueToFirstMap(firstMapConcepts map[string]string, second
 	return filteredMap
 }
 
-//Handle new source nodes that have been added to current concordance
-func (s *ConceptService) handleTransferConcordance(conceptData map[string]string, updateRecord *ConceptChanges, aggregateHash string, newAggregatedConcept AggregatedConcept, transID string) ([]*neoism.CypherQuery, error) {
-	var result []equivalenceResult
-	var deleteLonePrefUUIDQueries []*neoism.CypherQuery
+//Handle new source nodes that have been added to current concordance, returning the canonical nodes to delete as their
+//sources are transferred, and the equivalence of each source as it was read
+func (s *ConceptService) handleTransferConcordance(ctx context.Context, conceptData map[string]string, updateRecord *ConceptChanges, aggregateHash string, newAggregatedConcept AggregatedConcept, transID string) (deleteLonePrefUUIDs []string, transferred map[string][]equivalenceResult, err error) {
+	ctx, span := startSpan(ctx, "Write.transferAnalysis", sourceCountKey.Int(len(conceptData)))
+	defer func() {
+		endSpan(span, err)
+	}()
+	transferred = map[string][]equivalenceResult{}
 
 	for updatedSourceID := range conceptData {
-		equivQuery := &neoism.CypherQuery{
-			Statement: `
-					MATCH (t:Thing {uuid:{id}})
-					OPTIONAL MATCH (t)-[:EQUIVALENT_TO]->(c)
-					OPTIONAL MATCH (c)<-[eq:EQUIVALENT_TO]-(x:Thing)
-					RETURN t.uuid as sourceUuid, labels(t) as types, c.prefUUID as prefUuid, t.authority as authority, COUNT(DISTINCT eq) as count`,
-			Parameters: map[string]interface{}{
-				"id": updatedSourceID,
-			},
-			Result: &result,
-		}
-		err := s.conn.CypherBatch([]*neoism.CypherQuery{equivQuery})
+		result, err := s.store.readEquivalence(ctx, updatedSourceID, transID)
 		if err != nil {
-			logger.WithError(err).WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).Error("Requests for source nodes canonical information resulted in error")
-			return deleteLonePrefUUIDQueries, err
+			return deleteLonePrefUUIDs, transferred, err
 		}
 
+		transferred[updatedSourceID] = result
+
 		//source node does not currently exist in neo4j, nothing to tidy up
 		if len(result) == 0 {
 			logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).Info("No existing concordance record found")
@@ -730,14 +1038,14 @@ func (s *ConceptService) handleTransferConcordance(conceptData map[string]string
 			//this scenario should never happen
 			err = fmt.Errorf("Multiple source concepts found with matching uuid: %s", updatedSourceID)
 			logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).Error(err.Error())
-			return deleteLonePrefUUIDQueries, err
+			return deleteLonePrefUUIDs, transferred, err
 		}
 
 		entityEquivalence := result[0]
 		conceptType, err := mapper.MostSpecificType(entityEquivalence.Types)
 		if err != nil {
 			logger.WithError(err).WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).Errorf("could not return most specific type from source node: %v", entityEquivalence.Types)
-			return deleteLonePrefUUIDQueries, err
+			return deleteLonePrefUUIDs, transferred, err
 		}
 
 		logger.WithField("UUID", updatedSourceID).Debug("Existing prefUUID is " + entityEquivalence.PrefUUID + " equivalence count is " + strconv.Itoa(entityEquivalence.Equivalence))
@@ -748,7 +1056,7 @@ func (s *ConceptService) handleTransferConcordance(conceptData map[string]string
 			// Source exists in neo4j but is not concorded. It can be transferred without issue but its prefNode should be deleted
 			if updatedSourceID == entityEquivalence.PrefUUID {
 				logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).Debugf("Pref uuid node for source %s will need to be deleted as its source will be removed", updatedSourceID)
-				deleteLonePrefUUIDQueries = append(deleteLonePrefUUIDQueries, deleteLonePrefUUID(entityEquivalence.PrefUUID))
+				deleteLonePrefUUIDs = append(deleteLonePrefUUIDs, entityEquivalence.PrefUUID)
 				//concordance added
 				updateRecord.ChangedRecords = append(updateRecord.ChangedRecords, Event{
 					ConceptType:   conceptType,
@@ -765,8 +1073,9 @@ func (s *ConceptService) handleTransferConcordance(conceptData map[string]string
 			} else {
 				// Source is only source concorded to non-matching prefUUID; scenario should NEVER happen
 				err := fmt.Errorf("This source id: %s the only concordance to a non-matching node with prefUuid: %s", updatedSourceID, entityEquivalence.PrefUUID)
-				logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).WithField("alert_tag", "ConceptLoadingDodgyData").Error(err)
-				return deleteLonePrefUUIDQueries, err
+				logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).WithField("alert_tag", alertDodgyData).Error(err)
+				s.metrics.alert(alertDodgyData)
+				return deleteLonePrefUUIDs, transferred, err
 			}
 		} else {
 			if updatedSourceID == entityEquivalence.PrefUUID {
@@ -776,7 +1085,7 @@ func (s *ConceptService) handleTransferConcordance(conceptData map[string]string
 						logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).Debugf("Canonical node for main source %s will need to be deleted and all concordances will be transfered to the new concordance", updatedSourceID)
 						// just delete the lone prefUUID node because the other concordances to
 						// this node should already be in the new sourceRepresentations (aggregate-concept-transformer responsability)
-						deleteLonePrefUUIDQueries = append(deleteLonePrefUUIDQueries, deleteLonePrefUUID(entityEquivalence.PrefUUID))
+						deleteLonePrefUUIDs = append(deleteLonePrefUUIDs, entityEquivalence.PrefUUID)
 						updateRecord.ChangedRecords = append(updateRecord.ChangedRecords, Event{
 							ConceptType:   conceptType,
 							ConceptUUID:   updatedSourceID,
@@ -792,12 +1101,14 @@ func (s *ConceptService) handleTransferConcordance(conceptData map[string]string
 					}
 					// Source is prefUUID for a different concordance
 					err := fmt.Errorf("Cannot currently process this record as it will break an existing concordance with prefUuid: %s", updatedSourceID)
-					logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).WithField("alert_tag", "ConceptLoadingInvalidConcordance").Error(err)
-					return deleteLonePrefUUIDQueries, err
+					logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).WithField("alert_tag", alertInvalidConcordance).Error(err)
+					s.metrics.alert(alertInvalidConcordance)
+					return deleteLonePrefUUIDs, transferred, err
 				}
 			} else {
 				// Source was concorded to different concordance. Data on existing concordance is now out of date
-				logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).WithField("alert_tag", "ConceptLoadingStaleData").Infof("Need to re-ingest concordance record for prefUuid: %s as source: %s has been removed.", entityEquivalence.PrefUUID, updatedSourceID)
+				logger.WithTransactionID(transID).WithUUID(newAggregatedConcept.PrefUUID).WithField("alert_tag", alertStaleData).Infof("Need to re-ingest concordance record for prefUuid: %s as source: %s has been removed.", entityEquivalence.PrefUUID, updatedSourceID)
+				s.metrics.alert(alertStaleData)
 
 				updateRecord.ChangedRecords = append(updateRecord.ChangedRecords, Event{
 					ConceptType:   conceptType,
@@ -826,14 +1137,14 @@ func (s *ConceptService) handleTransferConcordance(conceptData map[string]string
 			}
 		}
 	}
-	return deleteLonePrefUUIDQueries, nil
+	return deleteLonePrefUUIDs, transferred, nil
 }
 
 //Clean up canonical nodes of a concept that has become a source of current concept
 func deleteLonePrefUUID(prefUUID string) *neoism.CypherQuery {
 	logger.WithField("UUID", prefUUID).Debug("Deleting orphaned prefUUID node")
 	equivQuery := &neoism.CypherQuery{
-		Statement: `MATCH (t:Thing {prefUUID:{id}}) DETACH DELETE t`,
+		Statement: `MATCH (t:Thing {prefUUID:$id}) DETACH DELETE t`,
 		Parameters: map[string]interface{}{
 			"id": prefUUID,
 		},
@@ -841,59 +1152,17 @@ func deleteLonePrefUUID(prefUUID string) *neoism.CypherQuery {
 	return equivQuery
 }
 
-//Clear down current concept node
-func (s *ConceptService) clearDownExistingNodes(ac AggregatedConcept) []*neoism.CypherQuery {
-	acUUID := ac.PrefUUID
-
-	var queryBatch []*neoism.CypherQuery
-
-	for _, sr := range ac.SourceRepresentations {
-		deletePreviousSourceIdentifiersLabelsAndPropertiesQuery := &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`MATCH (t:Thing {uuid:{id}})
-			OPTIONAL MATCH (t)<-[rel:IDENTIFIES]-(i)
-			OPTIONAL MATCH (t)-[eq:EQUIVALENT_TO]->(a:Thing)
-			OPTIONAL MATCH (t)-[x:HAS_PARENT]->(p)
-			OPTIONAL MATCH (t)-[relatedTo:IS_RELATED_TO]->(relNode)
-			OPTIONAL MATCH (t)-[supersededBy:SUPERSEDED_BY]->(supersedesNode)
-			OPTIONAL MATCH (t)-[broader:HAS_BROADER]->(brNode)
-			OPTIONAL MATCH (t)-[impliedBy:IMPLIED_BY]->(impliesNode)
-			OPTIONAL MATCH (t)-[hasFocus:HAS_FOCUS]->(hasFocusNode)
-			OPTIONAL MATCH (t)-[ho:HAS_ORGANISATION]->(org)
-			OPTIONAL MATCH (t)-[hm:HAS_MEMBER]->(memb)
-			OPTIONAL MATCH (t)-[hr:HAS_ROLE]->(mr)
-			OPTIONAL MATCH (t)-[issuerRel:ISSUED_BY]->(issuer)
-			OPTIONAL MATCH (t)-[parentOrgRel:SUB_ORGANISATION_OF]->(parentOrg)
-			OPTIONAL MATCH (t)-[cooRel:COUNTRY_OF_OPERATIONS]->(coo)
-			OPTIONAL MATCH (t)-[coiRel:COUNTRY_OF_INCORPORATION]->(coi)
-			OPTIONAL MATCH (t)-[corRel:COUNTRY_OF_RISK]->(cor)
-			REMOVE t:%s
-			SET t={uuid:{id}}
-			DELETE x, rel, i, eq, relatedTo, broader, impliedBy, hasFocus, ho, hm, hr, issuerRel, parentOrgRel, supersededBy, cooRel, coiRel, corRel`, getLabelsToRemove()),
-			Parameters: map[string]interface{}{
-				"id": sr.UUID,
-			},
-		}
-		queryBatch = append(queryBatch, deletePreviousSourceIdentifiersLabelsAndPropertiesQuery)
-	}
-
-	//cleanUP all the previous Equivalent to relationships
-	deletePreviousCanonicalIdentifiersLabelsAndPropertiesQuery := &neoism.CypherQuery{
-		Statement: fmt.Sprintf(`MATCH (t:Thing {prefUUID:{acUUID}})
-			OPTIONAL MATCH (t)<-[rel:EQUIVALENT_TO]-(s)
-			REMOVE t:%s
-			SET t={prefUUID:{acUUID}}
-			DELETE rel`, getLabelsToRemove()),
-		Parameters: map[string]interface{}{
-			"acUUID": acUUID,
-		},
+//Plan the write of the concept's canonical node and source nodes
+func conceptWritePlan(aggregatedConcept AggregatedConcept, storedCanonical *storedNode, storedSources map[string]*storedNode) *writePlan {
+	plan := newWritePlan().addNode(canonicalNodeState(aggregatedConcept), storedCanonical)
+	for _, sourceConcept := range aggregatedConcept.SourceRepresentations {
+		plan.addNode(sourceNodeState(sourceConcept, aggregatedConcept.PrefUUID), storedSources[sourceConcept.UUID])
 	}
-	queryBatch = append(queryBatch, deletePreviousCanonicalIdentifiersLabelsAndPropertiesQuery)
-
-	return queryBatch
+	return plan
 }
 
-//Curate all queries to populate concept nodes
-func populateConceptQueries(queryBatch []*neoism.CypherQuery, aggregatedConcept AggregatedConcept) []*neoism.CypherQuery {
+//The canonical node of the concept, with the concept's own properties
+func canonicalNodeState(aggregatedConcept AggregatedConcept) nodeState {
 	// Create a sourceConcept from the canonical information - WITH NO UUID
 	concept := Concept{
 		Aliases:              aggregatedConcept.Aliases,
@@ -935,278 +1204,131 @@ func populateConceptQueries(queryBatch []*neoism.CypherQuery, aggregatedConcept
 		ISO31661: aggregatedConcept.ISO31661,
 	}
 
-	queryBatch = append(queryBatch, createNodeQueries(concept, aggregatedConcept.PrefUUID, "")...)
-
-	// Repopulate
-	for _, sourceConcept := range aggregatedConcept.SourceRepresentations {
-		queryBatch = append(queryBatch, createNodeQueries(sourceConcept, "", sourceConcept.UUID)...)
-
-		equivQuery := &neoism.CypherQuery{
-			Statement: `MATCH (t:Thing {uuid:{uuid}}), (c:Thing {prefUUID:{prefUUID}})
-						MERGE (t)-[:EQUIVALENT_TO]->(c)`,
-			Parameters: map[string]interface{}{
-				"uuid":     sourceConcept.UUID,
-				"prefUUID": aggregatedConcept.PrefUUID,
-			},
-		}
-		queryBatch = append(queryBatch, equivQuery)
-
-		if len(sourceConcept.RelatedUUIDs) > 0 {
-			queryBatch = addRelationship(sourceConcept.UUID, sourceConcept.RelatedUUIDs, "IS_RELATED_TO", queryBatch)
-		}
-
-		if len(sourceConcept.BroaderUUIDs) > 0 {
-			queryBatch = addRelationship(sourceConcept.UUID, sourceConcept.BroaderUUIDs, "HAS_BROADER", queryBatch)
-		}
-
-		if len(sourceConcept.SupersededByUUIDs) > 0 {
-			queryBatch = addRelationship(sourceConcept.UUID, sourceConcept.SupersededByUUIDs, "SUPERSEDED_BY", queryBatch)
-		}
-
-		if len(sourceConcept.ImpliedByUUIDs) > 0 {
-			queryBatch = addRelationship(sourceConcept.UUID, sourceConcept.ImpliedByUUIDs, "IMPLIED_BY", queryBatch)
-		}
+	return conceptNodeState(concept, aggregatedConcept.PrefUUID, "")
+}
 
-		if len(sourceConcept.HasFocusUUIDs) > 0 {
-			queryBatch = addRelationship(sourceConcept.UUID, sourceConcept.HasFocusUUIDs, "HAS_FOCUS", queryBatch)
-		}
-	}
-	return queryBatch
+//The source node of a concept, which is equivalent to the concept's canonical node
+func sourceNodeState(sourceConcept Concept, prefUUID string) nodeState {
+	source := conceptNodeState(sourceConcept, "", sourceConcept.UUID)
+	source.relationships = append([]nodeRelationship{{Type: "EQUIVALENT_TO", UUID: prefUUID}}, source.relationships...)
+	return source
 }
 
 //Create concept nodes
 func createNodeQueries(concept Concept, prefUUID string, uuid string) []*neoism.CypherQuery {
-	var queryBatch []*neoism.CypherQuery
-	var createConceptQuery *neoism.CypherQuery
-
-	// Leaf or Lone Node
-	if uuid != "" {
-		allProps := setProps(concept, uuid, true)
-		createConceptQuery = &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`MERGE (n:Thing {uuid: {uuid}})
-											set n={allprops}
-											set n :%s`, getAllLabels(concept.Type)),
-			Parameters: map[string]interface{}{
-				"uuid":     uuid,
-				"allprops": allProps,
-			},
+	return newWritePlan().addNode(conceptNodeState(concept, prefUUID, uuid), nil).queries()
+}
+
+//The node a concept is written as. Without a uuid it is the canonical node, which has no relationships or identifiers
+//of its own.
+func conceptNodeState(concept Concept, prefUUID string, uuid string) nodeState {
+	labels := strings.Split(getAllLabels(concept.Type), ":")
+	if uuid == "" {
+		return nodeState{
+			key:    "prefUUID",
+			id:     prefUUID,
+			labels: labels,
+			props:  setProps(concept, prefUUID, false),
 		}
-	} else {
-		// Canonical node that doesn't have UUID
-		allProps := setProps(concept, prefUUID, false)
-		createConceptQuery = &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`MERGE (n:Thing {prefUUID: {prefUUID}})
-											set n={allprops}
-											set n :%s`, getAllLabels(concept.Type)),
-			Parameters: map[string]interface{}{
-				"prefUUID": prefUUID,
-				"allprops": allProps,
-			},
+	}
+
+	node := nodeState{
+		key:    "uuid",
+		id:     uuid,
+		labels: labels,
+		props:  setProps(concept, uuid, true),
+	}
+	seen := map[string]bool{}
+	addRelationship := func(relationshipType string, id string, props map[string]interface{}) {
+		rel := nodeRelationship{Type: relationshipType, UUID: id, Properties: props}
+		if id != "" && !seen[rel.id()] {
+			seen[rel.id()] = true
+			node.relationships = append(node.relationships, rel)
 		}
 	}
 
 	for _, parentUUID := range concept.ParentUUIDs {
-		writeParent := &neoism.CypherQuery{
-			Statement: `MERGE (o:Thing {uuid: {uuid}})
-						MERGE (parentupp:Identifier:UPPIdentifier {value: {parentUUID}})
-						MERGE (parent:Thing {uuid: {parentUUID}})
-						MERGE (parentupp)-[:IDENTIFIES]->(parent)
-						MERGE (o)-[:HAS_PARENT]->(parent)	`,
-			Parameters: neoism.Props{
-				"parentUUID": parentUUID,
-				"uuid":       concept.UUID,
-			},
-		}
-		queryBatch = append(queryBatch, writeParent)
-	}
-
-	if concept.OrganisationUUID != "" {
-		writeOrganisation := &neoism.CypherQuery{
-			Statement: `MERGE (membership:Thing {uuid: {uuid}})
-						MERGE (orgupp:Identifier:UPPIdentifier {value: {orgUUID}})
-						MERGE (org:Thing {uuid: {orgUUID}})
-						MERGE (orgupp)-[:IDENTIFIES]->(org)
-						MERGE (membership)-[:HAS_ORGANISATION]->(org)`,
-			Parameters: neoism.Props{
-				"orgUUID": concept.OrganisationUUID,
-				"uuid":    concept.UUID,
-			},
-		}
-		queryBatch = append(queryBatch, writeOrganisation)
-	}
-
-	if concept.PersonUUID != "" {
-		writePerson := &neoism.CypherQuery{
-			Statement: `MERGE (membership:Thing {uuid: {uuid}})
-						MERGE (personupp:Identifier:UPPIdentifier {value: {personUUID}})
-						MERGE (person:Thing {uuid: {personUUID}})
-						MERGE (personupp)-[:IDENTIFIES]->(person)
-						MERGE (membership)-[:HAS_MEMBER]->(person)`,
-			Parameters: neoism.Props{
-				"personUUID": concept.PersonUUID,
-				"uuid":       concept.UUID,
-			},
-		}
-		queryBatch = append(queryBatch, writePerson)
-	}
-
-	if uuid != "" && concept.IssuedBy != "" {
-		writeFinIns := &neoism.CypherQuery{
-			Statement: `MERGE (fi:Thing {uuid: {fiUUID}})
-						MERGE (org:Thing {uuid: {orgUUID}})
-						MERGE (fi)-[:ISSUED_BY]->(org)
-						MERGE (fiupp:Identifier:FIGIIdentifier {value: {fiCode}})
-						MERGE (fiupp)-[:IDENTIFIES]->(fi)
-						`,
-			Parameters: neoism.Props{
-				"fiUUID":  concept.UUID,
-				"fiCode":  concept.FigiCode,
-				"orgUUID": concept.IssuedBy,
-			},
-		}
-		queryBatch = append(queryBatch, writeFinIns)
-	}
-
-	if uuid != "" && concept.ParentOrganisation != "" {
-		writeParentOrganisation := &neoism.CypherQuery{
-			Statement: `MERGE (org:Thing {uuid: {uuid}})
-							MERGE (orgUPP:Identifier:UPPIdentifier {value: {orgUUID}})
-							MERGE (parentOrg:Thing {uuid: {orgUUID}})
-							MERGE (orgUPP)-[:IDENTIFIES]->(parentOrg)
-							MERGE (org)-[:SUB_ORGANISATION_OF]->(parentOrg)`,
-			Parameters: neoism.Props{
-				"orgUUID": concept.ParentOrganisation,
-				"uuid":    concept.UUID,
-			},
+		addRelationship("HAS_PARENT", parentUUID, nil)
+	}
+	addRelationship("HAS_ORGANISATION", concept.OrganisationUUID, nil)
+	addRelationship("HAS_MEMBER", concept.PersonUUID, nil)
+	addRelationship("ISSUED_BY", concept.IssuedBy, nil)
+	addRelationship("SUB_ORGANISATION_OF", concept.ParentOrganisation, nil)
+	addRelationship("COUNTRY_OF_RISK", concept.CountryOfRiskUUID, nil)
+	addRelationship("COUNTRY_OF_INCORPORATION", concept.CountryOfIncorporationUUID, nil)
+	addRelationship("COUNTRY_OF_OPERATIONS", concept.CountryOfOperationsUUID, nil)
+	for _, membershipRole := range concept.MembershipRoles {
+		props := map[string]interface{}{}
+		if membershipRole.InceptionDate != "" {
+			props["inceptionDate"] = membershipRole.InceptionDate
 		}
-		queryBatch = append(queryBatch, writeParentOrganisation)
-	}
-
-	if uuid != "" && concept.CountryOfRiskUUID != "" {
-		writeCountryOfRisk := &neoism.CypherQuery{
-			Statement: `MERGE (org:Thing {uuid: {uuid}})
-							MERGE (locUPP:Identifier:UPPIdentifier {value: {locUUID}})
-							MERGE (location:Thing {uuid: {locUUID}})
-							MERGE (locUPP)-[:IDENTIFIES]->(location)
-							MERGE (org)-[:COUNTRY_OF_RISK]->(location)`,
-			Parameters: neoism.Props{
-				"locUUID": concept.CountryOfRiskUUID,
-				"uuid":    concept.UUID,
-			},
+		if membershipRole.InceptionDateEpoch > 0 {
+			props["inceptionDateEpoch"] = membershipRole.InceptionDateEpoch
 		}
-		queryBatch = append(queryBatch, writeCountryOfRisk)
-	}
-	if uuid != "" && concept.CountryOfIncorporationUUID != "" {
-		writeCountryOfIncorporation := &neoism.CypherQuery{
-			Statement: `MERGE (org:Thing {uuid: {uuid}})
-							MERGE (locUPP:Identifier:UPPIdentifier {value: {locUUID}})
-							MERGE (location:Thing {uuid: {locUUID}})
-							MERGE (locUPP)-[:IDENTIFIES]->(location)
-							MERGE (org)-[:COUNTRY_OF_INCORPORATION]->(location)`,
-			Parameters: neoism.Props{
-				"locUUID": concept.CountryOfIncorporationUUID,
-				"uuid":    concept.UUID,
-			},
+		if membershipRole.TerminationDate != "" {
+			props["terminationDate"] = membershipRole.TerminationDate
 		}
-		queryBatch = append(queryBatch, writeCountryOfIncorporation)
-	}
-	if uuid != "" && concept.CountryOfOperationsUUID != "" {
-		writeCountryOfOperations := &neoism.CypherQuery{
-			Statement: `MERGE (org:Thing {uuid: {uuid}})
-							MERGE (locUPP:Identifier:UPPIdentifier {value: {locUUID}})
-							MERGE (location:Thing {uuid: {locUUID}})
-							MERGE (locUPP)-[:IDENTIFIES]->(location)
-							MERGE (org)-[:COUNTRY_OF_OPERATIONS]->(location)`,
-			Parameters: neoism.Props{
-				"locUUID": concept.CountryOfOperationsUUID,
-				"uuid":    concept.UUID,
-			},
-		}
-		queryBatch = append(queryBatch, writeCountryOfOperations)
-	}
-
-	if uuid != "" && len(concept.MembershipRoles) > 0 {
-		for _, membershipRole := range concept.MembershipRoles {
-			params := neoism.Props{
-				"inceptionDate":        nil,
-				"inceptionDateEpoch":   nil,
-				"terminationDate":      nil,
-				"terminationDateEpoch": nil,
-				"roleUUID":             membershipRole.RoleUUID,
-				"nodeUUID":             concept.UUID,
-			}
-			if membershipRole.InceptionDate != "" {
-				params["inceptionDate"] = membershipRole.InceptionDate
-			}
-			if membershipRole.InceptionDateEpoch > 0 {
-				params["inceptionDateEpoch"] = membershipRole.InceptionDateEpoch
-			}
-			if membershipRole.TerminationDate != "" {
-				params["terminationDate"] = membershipRole.TerminationDate
-			}
-			if membershipRole.TerminationDateEpoch > 0 {
-				params["terminationDateEpoch"] = membershipRole.TerminationDateEpoch
-			}
-			writeParent := &neoism.CypherQuery{
-				Statement: `MERGE (node:Thing{uuid: {nodeUUID}})
-							MERGE (role:Thing{uuid: {roleUUID}})
-								ON CREATE SET
-									role.uuid = {roleUUID}
-							MERGE (node)-[rel:HAS_ROLE]->(role)
-								ON CREATE SET
-									rel.inceptionDate = {inceptionDate},
-									rel.inceptionDateEpoch = {inceptionDateEpoch},
-									rel.terminationDate = {terminationDate},
-									rel.terminationDateEpoch = {terminationDateEpoch}
-							`,
-				Parameters: params,
-			}
-			queryBatch = append(queryBatch, writeParent)
+		if membershipRole.TerminationDateEpoch > 0 {
+			props["terminationDateEpoch"] = membershipRole.TerminationDateEpoch
 		}
+		addRelationship("HAS_ROLE", membershipRole.RoleUUID, props)
 	}
-
-	queryBatch = append(queryBatch, createConceptQuery)
-
-	// If no UUID then it is the canonical node and will not have identifier nodes
-	if uuid != "" && concept.Type != "Membership" {
-		queryBatch = append(queryBatch, addIdentifierNodes(uuid, concept.Authority, concept.AuthorityValue)...)
+	for _, id := range concept.RelatedUUIDs {
+		addRelationship("IS_RELATED_TO", id, nil)
+	}
+	for _, id := range concept.BroaderUUIDs {
+		addRelationship("HAS_BROADER", id, nil)
+	}
+	for _, id := range concept.SupersededByUUIDs {
+		addRelationship("SUPERSEDED_BY", id, nil)
+	}
+	for _, id := range concept.ImpliedByUUIDs {
+		addRelationship("IMPLIED_BY", id, nil)
+	}
+	for _, id := range concept.HasFocusUUIDs {
+		addRelationship("HAS_FOCUS", id, nil)
 	}
 
-	return queryBatch
-
+	if concept.IssuedBy != "" {
+		node.identifiers = append(node.identifiers, nodeIdentifier{Label: "FIGIIdentifier", Value: concept.FigiCode})
+	}
+	//Add Alternative Identifier
+	if label, ok := authorityToIdentifierLabelMap[concept.Authority]; ok && concept.Type != "Membership" {
+		node.identifiers = append(node.identifiers,
+			nodeIdentifier{Label: label, Value: concept.AuthorityValue},
+			nodeIdentifier{Label: authorityToIdentifierLabelMap["UPP"], Value: uuid},
+		)
+	}
+	return node
 }
 
-//Add relationships to concepts
-func addRelationship(conceptID string, relationshipIDs []string, relationshipType string, queryBatch []*neoism.CypherQuery) []*neoism.CypherQuery {
-	for _, id := range relationshipIDs {
-		addRelationshipQuery := &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`
-						MATCH (o:Concept {uuid: {uuid}})
-						MERGE (p:Thing {uuid: {id}})
-		            	MERGE (o)-[:%s]->(p)
-						MERGE (x:Identifier:UPPIdentifier{value:{id}})
-                        MERGE (x)-[:IDENTIFIES]->(p)`, relationshipType),
-			Parameters: map[string]interface{}{
-				"uuid":         conceptID,
-				"id":           id,
-				"relationship": relationshipType,
-			},
-		}
-		queryBatch = append(queryBatch, addRelationshipQuery)
+//Remove the equivalence of sources that have been removed from the concordance to its canonical node
+func removeEquivalenceQuery(prefUUID string, sources []Concept) *neoism.CypherQuery {
+	var uuids []string
+	for _, source := range sources {
+		uuids = append(uuids, source.UUID)
+	}
+	return &neoism.CypherQuery{
+		Statement: `MATCH (c:Thing {prefUUID:$prefUUID})<-[eq:EQUIVALENT_TO]-(t:Thing)
+					WHERE t.uuid IN $uuids
+					DELETE eq`,
+		Parameters: map[string]interface{}{
+			"prefUUID": prefUUID,
+			"uuids":    uuids,
+		},
 	}
-	return queryBatch
 }
 
 //Create canonical node for any concepts that were removed from a concordance and thus would become lone
-func (s *ConceptService) writeCanonicalNodeForUnconcordedConcepts(concept Concept) *neoism.CypherQuery {
+func canonicalNodeForUnconcordedConceptQuery(concept Concept) *neoism.CypherQuery {
 	allProps := setProps(concept, concept.UUID, false)
 	logger.WithField("UUID", concept.UUID).Debug("Creating prefUUID node for unconcorded concept")
 	createCanonicalNodeQuery := &neoism.CypherQuery{
 		Statement: fmt.Sprintf(`
-					MATCH (t:Thing{uuid:{prefUUID}})
-					MERGE (n:Thing {prefUUID: {prefUUID}})
+					MATCH (t:Thing{uuid:$prefUUID})
+					MERGE (n:Thing {prefUUID: $prefUUID})
 					MERGE (n)<-[:EQUIVALENT_TO]-(t)
-					set n={allprops}
+					set n=$allprops
 					set n :%s`, getAllLabels(concept.Type)),
 		Parameters: map[string]interface{}{
 			"prefUUID": concept.UUID,
@@ -1227,18 +1349,6 @@ func getAllLabels(conceptType string) string {
 	return labels
 }
 
-//return existing labels
-func getLabelsToRemove() string {
-	var labelsToRemove string
-	for i, conceptType := range conceptLabels {
-		labelsToRemove += conceptType
-		if i+1 < len(conceptLabels) {
-			labelsToRemove += ":"
-		}
-	}
-	return labelsToRemove
-}
-
 //extract uuids of the source concepts
 func getSourceData(sourceConcepts []Concept) map[string]string {
 	conceptData := make(map[string]string)
@@ -1275,6 +1385,7 @@ func setProps(concept Concept, id string, isSource bool) map[string]interface{}
 	//canonical specific props
 	nodeProps["prefUUID"] = id
 	nodeProps["aggregateHash"] = concept.Hash
+	nodeProps["aggregateHashVersion"] = currentHashVersion
 
 	if len(concept.Aliases) > 0 {
 		nodeProps["aliases"] = concept.Aliases
@@ -1364,37 +1475,6 @@ func setProps(concept Concept, id string, isSource bool) map[string]interface{}
 	return nodeProps
 }
 
-//Add identifiers to node
-func addIdentifierNodes(UUID string, authority string, authorityValue string) []*neoism.CypherQuery {
-	var queryBatch []*neoism.CypherQuery
-	//Add Alternative Identifier
-
-	if label, ok := authorityToIdentifierLabelMap[authority]; ok {
-		alternativeIdentifierQuery := createNewIdentifierQuery(UUID, label, authorityValue)
-		queryBatch = append(queryBatch, alternativeIdentifierQuery)
-
-		uppIdentifierQuery := createNewIdentifierQuery(UUID, authorityToIdentifierLabelMap["UPP"], UUID)
-		queryBatch = append(queryBatch, uppIdentifierQuery)
-	}
-
-	return queryBatch
-}
-
-//Create identifier
-func createNewIdentifierQuery(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery {
-	statementTemplate := fmt.Sprintf(`MERGE (t:Thing {uuid:{uuid}})
-					MERGE (i:Identifier:%s {value:{value}})
-					MERGE (t)<-[:IDENTIFIES]-(i)`, identifierLabel)
-	query := &neoism.CypherQuery{
-		Statement: statementTemplate,
-		Parameters: map[string]interface{}{
-			"uuid":  uuid,
-			"value": identifierValue,
-		},
-	}
-	return query
-}
-
 //DecodeJSON - decode json
 func (s *ConceptService) DecodeJSON(dec *json.Decoder) (interface{}, string, error) {
 	sub := AggregatedConcept{}
@@ -1404,10 +1484,7 @@ func (s *ConceptService) DecodeJSON(dec *json.Decoder) (interface{}, string, err
 
 //Check - checker
 func (s *ConceptService) Check() error {
-	if err := neoutils.CheckWritable(s.conn); err != nil {
-		return err
-	}
-	return neoutils.Check(s.conn)
+	return s.store.check()
 }
 
 type requestError struct {
@@ -1424,6 +1501,41 @@ func (re requestError) InvalidRequestDetails() string {
 	return re.details
 }
 
+type preconditionError struct {
+	details string
+}
+
+func newPreconditionError(prefUUID string, transID string) preconditionError {
+	err := preconditionError{fmt.Sprintf("Concept with prefUuid: %s does not match the precondition of the request", prefUUID)}
+	logger.WithTransactionID(transID).WithUUID(prefUUID).Info(err.details)
+	return err
+}
+
+//Error - Error
+func (pe preconditionError) Error() string {
+	return pe.details
+}
+
+//PreconditionFailedDetails - Specific error for a conditional request whose precondition does not hold (412)
+func (pe preconditionError) PreconditionFailedDetails() string {
+	return pe.details
+}
+
+type dependantsConflictError struct {
+	prefUUID   string
+	dependants []string
+}
+
+//Error - Error
+func (de dependantsConflictError) Error() string {
+	return fmt.Sprintf("Cannot delete concept with prefUuid: %s as it still has %d dependant concepts", de.prefUUID, len(de.dependants))
+}
+
+//Dependants - Specific error for refusing to remove a concept which is still referenced (409)
+func (de dependantsConflictError) Dependants() []string {
+	return de.dependants
+}
+
 func processMembershipRoles(v interface{}) interface{} {
 	switch c := v.(type) {
 	case AggregatedConcept:
@@ -1526,6 +1638,7 @@ func cleanConcept(c AggregatedConcept) AggregatedConcept {
 
 func cleanHash(c AggregatedConcept) AggregatedConcept {
 	c.AggregatedHash = ""
+	c.AggregatedHashVersion = 0
 	return c
 }
 
@@ -0,0 +1,105 @@
+// +build !integration
+
+package concepts
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+//Store the concept service under test writes to, unless the tests are run against Neo4j
+var memoryStore = NewMemoryConceptStore()
+
+func init() {
+	logger.InitLogger("test-concepts-rw-neo4j", "panic")
+	conceptsDriver = newTestConceptService(responseOnlyEventPublisher{})
+}
+
+//Concept service under test writing to the memory store, which publishes its events to the given publisher
+func newTestConceptService(publisher EventPublisher) ConceptService {
+	return NewConceptServiceWithStore(memoryStore, publisher)
+}
+
+func cleanDB(t *testing.T) {
+	memoryStore.Lock()
+	defer memoryStore.Unlock()
+
+	memoryStore.canonicals = map[string]*storedNode{}
+	memoryStore.things = map[string]*storedNode{}
+}
+
+func getIdentifierValue(t *testing.T, uuidPropertyName string, uuid string, label string) string {
+	memoryStore.RLock()
+	defer memoryStore.RUnlock()
+
+	nodes := memoryStore.things
+	if uuidPropertyName == "prefUUID" {
+		nodes = memoryStore.canonicals
+	}
+	if node, ok := nodes[uuid]; ok && stringInArr("Concept", node.Labels) {
+		for _, identifier := range node.Identifiers {
+			if identifier.Label == label {
+				return identifier.Value
+			}
+		}
+	}
+	return ""
+}
+
+func TestRejectedTransferPublishesNoEvents(t *testing.T) {
+	store := NewMemoryConceptStore()
+	publisher := &MemoryEventPublisher{}
+	service := NewConceptServiceWithStore(store, publisher)
+
+	_, err := service.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	outbox, err := store.readEvents(context.Background(), eventCursor{}, maxEventsLimit, "test_tid")
+	assert.NoError(t, err)
+	publisher.Reset()
+
+	//Sources are checked in no particular order, so new ones are added, which record events if they are checked before
+	//the source that is rejected, and the write is tried a few times
+	rejected := getAggregatedConcept(t, "pref-uuid-as-source.json")
+	for i := 0; i < 8; i++ {
+		source := rejected.SourceRepresentations[len(rejected.SourceRepresentations)-1]
+		source.UUID = fmt.Sprintf("00000000-0000-0000-0000-00000000000%d", i)
+		source.AuthorityValue = source.UUID
+		rejected.SourceRepresentations = append(rejected.SourceRepresentations, source)
+	}
+	for i := 0; i < 5; i++ {
+		changes, err := service.Write(context.Background(), rejected, "test_tid")
+		assert.EqualError(t, err, "Cannot currently process this record as it will break an existing concordance with prefUuid: bbc4f575-edb3-4f51-92f0-5ce6c708d1ea")
+		assert.Equal(t, ConceptChanges{}, changes, "A rejected write should report no changes, as none were made")
+	}
+	assert.Empty(t, publisher.Events(), "A rejected write should publish no events")
+	rejectedOutbox, err := store.readEvents(context.Background(), eventCursor{}, maxEventsLimit, "test_tid")
+	assert.NoError(t, err)
+	assert.Equal(t, outbox, rejectedOutbox, "A rejected write should add no events to the outbox")
+}
+
+func TestLegacyHashIsOfTheRequestBeforeItIsProcessed(t *testing.T) {
+	defer cleanDB(t)
+	publisher := &MemoryEventPublisher{}
+	service := newTestConceptService(publisher)
+
+	concept := getAggregatedConcept(t, "membership.json")
+	_, err := service.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	publisher.Reset()
+
+	//the hash stored of membership.json by writers before the hash was versioned
+	memoryStore.Lock()
+	canonical := memoryStore.canonicals[concept.PrefUUID]
+	canonical.Properties["aggregateHash"] = "7019061401434978811"
+	delete(canonical.Properties, "aggregateHashVersion")
+	memoryStore.Unlock()
+
+	changes, err := service.Write(context.Background(), getAggregatedConcept(t, "membership.json"), "test_tid")
+	assert.NoError(t, err)
+	assert.Equal(t, ConceptChanges{}, changes, "A concept with a legacy hash should not be rewritten until it has changed")
+	assert.Empty(t, publisher.Events())
+}
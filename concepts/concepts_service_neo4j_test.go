@@ -0,0 +1,873 @@
+// +build integration
+
+package concepts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+//Reusable Neo4J connection
+var db neoutils.NeoConnection
+
+func init() {
+	// We are initialising a lot of constraints on an empty database therefore we need the database to be fit before
+	// we run tests so initialising the service will create the constraints first
+	logger.InitLogger("test-concepts-rw-neo4j", "panic")
+
+	db = newTestConnection()
+	if db == nil {
+		panic("Cannot connect to Neo4J")
+	}
+	//the tests read events as soon as they have been written, one at a time
+	outboxSettleDelay = 0
+	conceptsDriver = NewConceptService(db)
+	if _, err := conceptsDriver.MigrateUp(context.Background()); err != nil {
+		panic(err)
+	}
+
+	duration := 5 * time.Second
+	time.Sleep(duration)
+}
+
+//Concept service under test writing to the test database, which publishes its events to the given publisher
+func newTestConceptService(publisher EventPublisher) ConceptService {
+	return NewConceptServiceWithPublisher(db, publisher)
+}
+
+
+func TestWriteMemberships_FixOldData(t *testing.T) {
+	defer cleanDB(t)
+
+	queries := createNodeQueries(getConcept(t, "old-membership.json"), "", membershipUUID)
+	err := db.CypherBatch(queries)
+	assert.NoError(t, err, "Failed to write source")
+
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "membership.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write membership")
+
+	result, _, err := conceptsDriver.Read(context.Background(), membershipUUID, "test_tid")
+	assert.NoError(t, err, "Failed to read membership")
+	ab, err := json.Marshal(cleanHash(result.(AggregatedConcept)))
+
+	originalMembership := AggregatedConcept{}
+	json.Unmarshal(ab, &originalMembership)
+
+	originalMembership = cleanConcept(originalMembership)
+
+	assert.Equal(t, len(originalMembership.MembershipRoles), 2)
+	assert.True(t, reflect.DeepEqual([]MembershipRole{membershipRole, anotherMembershipRole}, originalMembership.MembershipRoles))
+	assert.Equal(t, organisationUUID, originalMembership.OrganisationUUID)
+	assert.Equal(t, personUUID, originalMembership.PersonUUID)
+}
+
+func TestPreconditionGuardFailsBatchWhenConceptChanged(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "single-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	guard := preconditionGuardQueries(basicConceptUUID, Precondition{IfMatch: []string{"not-the-hash"}})
+	assert.Error(t, db.CypherBatch(guard), "Guard should fail the batch when the stored hash differs")
+
+	stored, _, _ := conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	guard = preconditionGuardQueries(basicConceptUUID, Precondition{IfMatch: []string{stored.(AggregatedConcept).AggregatedHash}})
+	assert.NoError(t, db.CypherBatch(guard), "Guard should not fail the batch when the stored hash matches")
+}
+
+func TestEquivalenceGuardsFailBatchWhenConcordanceChanged(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	stored, _, _ := conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.Error(t, db.CypherBatch([]*neoism.CypherQuery{concordanceGuardQuery(basicConceptUUID, 2, "not-the-hash")}), "Guard should fail the batch when the aggregate hash differs")
+	assert.Error(t, db.CypherBatch([]*neoism.CypherQuery{concordanceGuardQuery(basicConceptUUID, 1, stored.(AggregatedConcept).AggregatedHash)}), "Guard should fail the batch when the number of sources differs")
+	assert.NoError(t, db.CypherBatch([]*neoism.CypherQuery{concordanceGuardQuery(basicConceptUUID, 2, stored.(AggregatedConcept).AggregatedHash)}), "Guard should not fail the batch when the number of sources matches")
+
+	read := []equivalenceResult{{SourceUUID: sourceID1, PrefUUID: basicConceptUUID, Equivalence: 2}}
+	assert.NoError(t, db.CypherBatch([]*neoism.CypherQuery{equivalenceGuardQuery(sourceID1, read)}), "Guard should not fail the batch when the equivalence matches")
+
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "transfer-source-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	assert.Error(t, db.CypherBatch([]*neoism.CypherQuery{equivalenceGuardQuery(sourceID1, read)}), "Guard should fail the batch when the source has been transferred")
+	assert.Error(t, db.CypherBatch([]*neoism.CypherQuery{equivalenceGuardQuery(sourceID1, nil)}), "Guard should fail the batch when a source that didn't exist has been written")
+}
+
+func TestConcurrentWritesWithOverlappingSources(t *testing.T) {
+	defer cleanDB(t)
+
+	for i := 0; i < 5; i++ {
+		var wg sync.WaitGroup
+		for _, file := range []string{"dual-concordance.json", "transfer-source-concordance.json"} {
+			wg.Add(1)
+			go func(file string) {
+				defer wg.Done()
+				conceptsDriver.Write(context.Background(), getAggregatedConcept(t, file), "test_tid")
+			}(file)
+		}
+		wg.Wait()
+
+		var results []struct {
+			Count int `json:"count"`
+		}
+		query := &neoism.CypherQuery{
+			Statement:  `MATCH (:Thing {uuid:$uuid})-[eq:EQUIVALENT_TO]->() RETURN count(eq) AS count`,
+			Parameters: map[string]interface{}{"uuid": sourceID1},
+			Result:     &results,
+		}
+		assert.NoError(t, db.CypherBatch([]*neoism.CypherQuery{query}))
+		assert.Equal(t, 1, results[0].Count, "A source shared by concurrent writes should only be concorded once")
+		cleanDB(t)
+	}
+}
+
+func TestWriteComparesLegacyHashWithoutRewriting(t *testing.T) {
+	defer cleanDB(t)
+
+	concept := getAggregatedConcept(t, "dual-concordance.json")
+	_, err := conceptsDriver.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	// make the stored concept look as though it was written before the hash was versioned
+	legacyHash, err := hashConcept(cleanSourceProperties(concept), legacyHashVersion)
+	assert.NoError(t, err)
+	err = db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (c:Thing {prefUUID:$uuid}) SET c.aggregateHash = $hash REMOVE c.aggregateHashVersion`,
+		Parameters: map[string]interface{}{"uuid": basicConceptUUID, "hash": legacyHash},
+	}})
+	assert.NoError(t, err)
+
+	output, err := conceptsDriver.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	assert.Empty(t, output.(ConceptChanges).ChangedRecords, "An unchanged concept with a legacy hash should not be rewritten")
+
+	concept.Aliases = append(concept.Aliases, "A new alias")
+	output, err = conceptsDriver.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	assert.NotEmpty(t, output.(ConceptChanges).ChangedRecords, "A changed concept with a legacy hash should be written")
+
+	stored, _, err := conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.Equal(t, currentHashVersion, stored.(AggregatedConcept).AggregatedHashVersion, "The hash should be stored with the current version once rewritten")
+}
+
+func TestWriteOnlyChangesDifferences(t *testing.T) {
+	defer cleanDB(t)
+
+	concept := getAggregatedConcept(t, "concept-with-multiple-related-to.json")
+	_, err := conceptsDriver.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	relationshipIDs := func() map[string]int64 {
+		var results []struct {
+			Relationship string `json:"relationship"`
+			ID           int64  `json:"id"`
+		}
+		err := db.CypherBatch([]*neoism.CypherQuery{{
+			Statement: `MATCH (t:Thing {uuid:$uuid})-[r]-(o)
+				RETURN type(r) + ':' + coalesce(o.uuid, o.prefUUID, o.value) AS relationship, id(r) AS id`,
+			Parameters: map[string]interface{}{"uuid": basicConceptUUID},
+			Result:     &results,
+		}})
+		assert.NoError(t, err)
+		ids := map[string]int64{}
+		for _, result := range results {
+			ids[result.Relationship] = result.ID
+		}
+		return ids
+	}
+	before := relationshipIDs()
+
+	removedUUID := concept.SourceRepresentations[0].RelatedUUIDs[1]
+	concept.PrefLabel = "A new pref label"
+	concept.SourceRepresentations[0].PrefLabel = "A new pref label"
+	concept.SourceRepresentations[0].RelatedUUIDs = concept.SourceRepresentations[0].RelatedUUIDs[:1]
+	_, err = conceptsDriver.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	readConceptAndCompare(t, concept, "TestWriteOnlyChangesDifferences")
+
+	after := relationshipIDs()
+	assert.NotContains(t, after, "IS_RELATED_TO:"+removedUUID, "The removed relationship should have been deleted")
+	delete(before, "IS_RELATED_TO:"+removedUUID)
+	assert.Equal(t, before, after, "Relationships that haven't changed should not have been written again")
+}
+
+func BenchmarkWriteNewConcept(b *testing.B) {
+	plan := conceptWritePlan(benchmarkConcept(200), nil, nil)
+	generators := map[string]func(*writePlan) []*neoism.CypherQuery{
+		"batched":   (*writePlan).queries,
+		"unbatched": unbatchedQueries,
+	}
+	clean := &neoism.CypherQuery{
+		Statement: `MATCH (n)
+			WHERE n.uuid STARTS WITH 'bench-' OR n.prefUUID STARTS WITH 'bench-' OR n.value STARTS WITH 'bench-'
+			DETACH DELETE n`,
+	}
+	for name, generate := range generators {
+		b.Run(name, func(b *testing.B) {
+			queries := generate(plan)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := db.CypherBatch([]*neoism.CypherQuery{clean}); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if err := db.CypherBatch(queries); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(queries)), "statements/op")
+		})
+	}
+	if err := db.CypherBatch([]*neoism.CypherQuery{clean}); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestInvalidTypesThrowError(t *testing.T) {
+	invalidPrefConceptType := `MERGE (t:Thing{prefUUID:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea"}) SET t={prefUUID:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea", prefLabel:"The Best Label"} SET t:Concept:Brand:Unknown MERGE (s:Thing{uuid:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea"}) SET s={uuid:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea"} SET t:Concept:Brand MERGE (t)<-[:EQUIVALENT_TO]-(s)`
+	invalidSourceConceptType := `MERGE (t:Thing{prefUUID:"4c41f314-4548-4fb6-ac48-4618fcbfa84c"}) SET t={prefUUID:"4c41f314-4548-4fb6-ac48-4618fcbfa84c", prefLabel:"The Best Label"} SET t:Concept:Brand MERGE (s:Thing{uuid:"4c41f314-4548-4fb6-ac48-4618fcbfa84c"}) SET s={uuid:"4c41f314-4548-4fb6-ac48-4618fcbfa84c"} SET t:Concept:Brand:Unknown MERGE (t)<-[:EQUIVALENT_TO]-(s)`
+
+	type testStruct struct {
+		testName         string
+		prefUUID         string
+		statementToWrite string
+		returnedError    error
+	}
+
+	invalidPrefConceptTypeTest := testStruct{
+		testName:         "invalidPrefConceptTypeTest",
+		prefUUID:         basicConceptUUID,
+		statementToWrite: invalidPrefConceptType,
+		returnedError:    nil,
+	}
+	invalidSourceConceptTypeTest := testStruct{
+		testName:         "invalidSourceConceptTypeTest",
+		prefUUID:         anotherBasicConceptUUID,
+		statementToWrite: invalidSourceConceptType,
+		returnedError:    nil,
+	}
+
+	scenarios := []testStruct{invalidPrefConceptTypeTest, invalidSourceConceptTypeTest}
+
+	for _, scenario := range scenarios {
+		db.CypherBatch([]*neoism.CypherQuery{{Statement: scenario.statementToWrite}})
+		aggConcept, found, err := conceptsDriver.Read(context.Background(), scenario.prefUUID, "")
+		assert.Equal(t, AggregatedConcept{}, aggConcept, "Scenario "+scenario.testName+" failed; aggregate concept should be empty")
+		assert.Equal(t, false, found, "Scenario "+scenario.testName+" failed; aggregate concept should not be returned from read")
+		assert.Error(t, err, "Scenario "+scenario.testName+" failed; read of concept should return error")
+		assert.Contains(t, err.Error(), "provided types are not a consistent hierarchy", "Scenario "+scenario.testName+" failed; should throw error from mapper.MostSpecificType function")
+	}
+
+	defer cleanDB(t)
+}
+
+func TestTransferConcordance(t *testing.T) {
+	statement := `MERGE (a:Thing{prefUUID:"1"}) MERGE (b:Thing{uuid:"1"}) MERGE (c:Thing{uuid:"2"}) MERGE (d:Thing{uuid:"3"}) MERGE (w:Thing{prefUUID:"4"}) MERGE (y:Thing{uuid:"5"}) MERGE (j:Thing{prefUUID:"6"}) MERGE (k:Thing{uuid:"6"}) MERGE (c)-[:EQUIVALENT_TO]->(a)<-[:EQUIVALENT_TO]-(b) MERGE (w)<-[:EQUIVALENT_TO]-(d) MERGE (j)<-[:EQUIVALENT_TO]-(k)`
+	db.CypherBatch([]*neoism.CypherQuery{{Statement: statement}})
+	var emptyQuery []string
+	var updatedConcept ConceptChanges
+
+	type testStruct struct {
+		testName         string
+		updatedSourceIds map[string]string
+		returnResult     bool
+		returnedError    error
+	}
+
+	nodeHasNoConconcordance := testStruct{
+		testName: "nodeHasNoConconcordance",
+		updatedSourceIds: map[string]string{
+			"5": "Brand"},
+		returnedError: nil,
+	}
+	nodeHasExistingConcordanceWhichWouldCauseDataIssues := testStruct{
+		testName: "nodeHasExistingConcordanceWhichNeedsToBeReWritten",
+		updatedSourceIds: map[string]string{
+			"1": "Brand"},
+		returnedError: errors.New("Cannot currently process this record as it will break an existing concordance with prefUuid: 1"),
+	}
+	nodeHasExistingConcordanceWhichNeedsToBeReWritten := testStruct{
+		testName: "nodeHasExistingConcordanceWhichNeedsToBeReWritten",
+		updatedSourceIds: map[string]string{
+			"2": "Brand"},
+		returnedError: nil,
+	}
+	nodeHasInvalidConcordance := testStruct{
+		testName: "nodeHasInvalidConcordance",
+		updatedSourceIds: map[string]string{
+			"3": "Brand"},
+		returnedError: errors.New("This source id: 3 the only concordance to a non-matching node with prefUuid: 4"),
+	}
+	nodeIsPrefUUIDForExistingConcordance := testStruct{
+		testName: "nodeIsPrefUuidForExistingConcordance",
+		updatedSourceIds: map[string]string{
+			"1": "Brand"},
+		returnedError: errors.New("Cannot currently process this record as it will break an existing concordance with prefUuid: 1"),
+	}
+	nodeHasConcordanceToItselfPrefNodeNeedsToBeDeleted := testStruct{
+		testName: "nodeHasConcordanceToItselfPrefNodeNeedsToBeDeleted",
+		updatedSourceIds: map[string]string{
+			"6": "Brand"},
+		returnResult:  true,
+		returnedError: nil,
+	}
+
+	scenarios := []testStruct{
+		nodeHasNoConconcordance,
+		nodeHasExistingConcordanceWhichWouldCauseDataIssues,
+		nodeHasExistingConcordanceWhichNeedsToBeReWritten,
+		nodeHasInvalidConcordance,
+		nodeIsPrefUUIDForExistingConcordance,
+		nodeHasConcordanceToItselfPrefNodeNeedsToBeDeleted,
+	}
+
+	for _, scenario := range scenarios {
+		returnedQueryList, _, err := conceptsDriver.handleTransferConcordance(context.Background(), scenario.updatedSourceIds, &updatedConcept, "1234", AggregatedConcept{}, "")
+		assert.Equal(t, scenario.returnedError, err, "Scenario "+scenario.testName+" returned unexpected error")
+		if scenario.returnResult == true {
+			assert.NotEqual(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
+			break
+		}
+		assert.Equal(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
+	}
+
+	defer deleteSourceNodes(t, "1", "2", "3", "5", "6")
+	defer deleteConcordedNodes(t, "1", "4", "6")
+}
+
+func TestTransferCanonicalMultipleConcordance(t *testing.T) {
+	statement := `
+	MERGE (editorialCanonical:Thing{prefUUID:"1"}) 
+	MERGE (editorial:Thing{uuid:"1"}) 
+	SET editorial.authority="Smartlogic"
+	
+	MERGE (mlCanonical:Thing{prefUUID:"2"}) 
+	MERGE (ml:Thing{uuid:"2"}) 
+	SET ml.authority="ManagedLocation"
+
+	MERGE (geonames:Thing{uuid:"3"})
+	SET geonames.authority="Geonames"
+
+	MERGE (factset:Thing{uuid:"4"})
+	SET factset.authority="FACTSET"
+
+	MERGE (tme:Thing{uuid:"5"})
+	SET tme.authority="TME"
+	
+	MERGE (editorial)-[:EQUIVALENT_TO]->(editorialCanonical)<-[:EQUIVALENT_TO]-(factset)
+	MERGE (ml)-[:EQUIVALENT_TO]->(mlCanonical)<-[:EQUIVALENT_TO]-(tme)`
+	db.CypherBatch([]*neoism.CypherQuery{{Statement: statement}})
+	var emptyQuery []string
+	var updatedConcept ConceptChanges
+
+	type testStruct struct {
+		testName          string
+		updatedSourceIds  map[string]string
+		returnResult      bool
+		returnedError     error
+		targetConcordance AggregatedConcept
+	}
+	mergeManagedLocationCanonicalWithTwoSources := testStruct{
+		testName: "mergeManagedLocationCanonicalWithTwoSources",
+		updatedSourceIds: map[string]string{
+			"2": "Brand"},
+		returnedError: nil,
+		returnResult:  true,
+		targetConcordance: AggregatedConcept{
+			PrefUUID: "1",
+			SourceRepresentations: []Concept{
+				Concept{UUID: "1", Authority: "Smartlogic"},
+				Concept{UUID: "4", Authority: "FACTSET"},
+				Concept{UUID: "2", Authority: "ManagedLocation"},
+			},
+		},
+	}
+	mergeManagedLocationCanonicalWithTwoSourcesAndGeonames := testStruct{
+		testName: "mergeManagedLocationCanonicalWithTwoSourcesAndGeonames",
+		updatedSourceIds: map[string]string{
+			"3": "Brand",
+			"2": "Brand"},
+		returnedError: nil,
+		returnResult:  true,
+		targetConcordance: AggregatedConcept{
+			PrefUUID: "1",
+			SourceRepresentations: []Concept{
+				Concept{UUID: "1", Authority: "Smartlogic"},
+				Concept{UUID: "4", Authority: "FACTSET"},
+				Concept{UUID: "2", Authority: "ManagedLocation"},
+				Concept{UUID: "5", Authority: "TME"},
+			},
+		},
+	}
+	mergeJustASourceConcordance := testStruct{
+		testName: "mergeJustASourceConcordance",
+		updatedSourceIds: map[string]string{
+			"4": "Brand"},
+		returnedError: nil,
+	}
+
+	scenarios := []testStruct{
+		mergeManagedLocationCanonicalWithTwoSources,
+		mergeManagedLocationCanonicalWithTwoSourcesAndGeonames,
+		mergeJustASourceConcordance,
+	}
+
+	for _, scenario := range scenarios {
+		returnedQueryList, _, err := conceptsDriver.handleTransferConcordance(context.Background(), scenario.updatedSourceIds, &updatedConcept, "1234", scenario.targetConcordance, "")
+		assert.Equal(t, scenario.returnedError, err, "Scenario "+scenario.testName+" returned unexpected error")
+		if scenario.returnResult == true {
+			assert.NotEqual(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
+			continue
+		}
+		assert.Equal(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
+	}
+
+	defer deleteSourceNodes(t, "1", "2", "3", "5")
+	defer deleteConcordedNodes(t, "1", "2")
+}
+
+//The read as it was before each relationship was read with its own pattern comprehension, which the current read is
+//checked against
+const legacyReadConceptReturnClause = `
+		OPTIONAL MATCH (source)-[:HAS_BROADER]->(broader:Thing)
+		OPTIONAL MATCH (source)-[:HAS_MEMBER]->(person:Thing)
+		OPTIONAL MATCH (source)-[:HAS_ORGANISATION]->(org:Thing)
+		OPTIONAL MATCH (source)-[:HAS_PARENT]->(parent:Thing)
+		OPTIONAL MATCH (source)-[:IS_RELATED_TO]->(related:Thing)
+		OPTIONAL MATCH (source)-[:SUPERSEDED_BY]->(supersededBy:Thing)
+		OPTIONAL MATCH (source)-[:IMPLIED_BY]->(impliedBy:Thing)
+		OPTIONAL MATCH (source)-[:HAS_FOCUS]->(hasFocus:Thing)
+		OPTIONAL MATCH (source)-[:ISSUED_BY]->(issuer:Thing)
+		OPTIONAL MATCH (source)-[roleRel:HAS_ROLE]->(role:Thing)
+		OPTIONAL MATCH (source)-[:SUB_ORGANISATION_OF]->(parentOrg:Thing)
+		OPTIONAL MATCH (source)-[:COUNTRY_OF_OPERATIONS]->(coo:Thing)
+		OPTIONAL MATCH (source)-[:COUNTRY_OF_RISK]->(cor:Thing)
+		OPTIONAL MATCH (source)-[:COUNTRY_OF_INCORPORATION]->(coi:Thing)
+		WITH
+			collect(DISTINCT broader.uuid) as broaderUUIDs,
+			canonical,
+			issuer,
+			org,
+			parent,
+			person,
+			collect(DISTINCT related.uuid) as relatedUUIDs,
+			collect(DISTINCT supersededBy.uuid) as supersededByUUIDs,
+			collect(DISTINCT impliedBy.uuid) as impliedByUUIDs,
+			collect(DISTINCT hasFocus.uuid) as hasFocusUUIDs,
+			role,
+			roleRel,
+			parentOrg,
+			coo,
+			cor,
+			coi,
+			source
+			ORDER BY
+				source.uuid,
+				role.uuid
+		WITH
+			canonical,
+			issuer,
+			org,
+			person,
+			{
+				authority: source.authority,
+				authorityValue: source.authorityValue,
+				broaderUUIDs: broaderUUIDs,
+				supersededByUUIDs: supersededByUUIDs,
+				figiCode: source.figiCode,
+				issuedBy: issuer.uuid,
+				lastModifiedEpoch: source.lastModifiedEpoch,
+				membershipRoles: collect({
+					membershipRoleUUID: role.uuid,
+					inceptionDate: roleRel.inceptionDate,
+					terminationDate: roleRel.terminationDate,
+					inceptionDateEpoch: roleRel.inceptionDateEpoch,
+					terminationDateEpoch: roleRel.terminationDateEpoch
+				}),
+				organisationUUID: org.uuid,
+				parentUUIDs: collect(parent.uuid),
+				personUUID: person.uuid,
+				parentOrganisation: parentOrg.uuid,
+				prefLabel: source.prefLabel,
+				relatedUUIDs: relatedUUIDs,
+				impliedByUUIDs: impliedByUUIDs,
+				hasFocusUUIDs: hasFocusUUIDs,
+				types: labels(source),
+				uuid: source.uuid,
+				isDeprecated: source.isDeprecated,
+				countryOfIncorporationUUID: coi.uuid,
+				countryOfOperationsUUID: coo.uuid,
+				countryOfRiskUUID: cor.uuid
+			} as sources,
+			collect({
+				inceptionDate: roleRel.inceptionDate,
+				inceptionDateEpoch: roleRel.inceptionDateEpoch,
+				membershipRoleUUID: role.uuid,
+				terminationDate: roleRel.terminationDate,
+				terminationDateEpoch: roleRel.terminationDateEpoch
+			}) as membershipRoles
+		RETURN
+			canonical.aggregateHash as aggregateHash,
+			canonical.aggregateHashVersion as aggregateHashVersion,
+			canonical.aliases as aliases,
+			canonical.descriptionXML as descriptionXML,
+			canonical.emailAddress as emailAddress,
+			canonical.facebookPage as facebookPage,
+			canonical.figiCode as figiCode,
+			canonical.imageUrl as imageUrl,
+			canonical.inceptionDate as inceptionDate,
+			canonical.inceptionDateEpoch as inceptionDateEpoch,
+			canonical.prefLabel as prefLabel,
+			canonical.prefUUID as prefUUID,
+			canonical.scopeNote as scopeNote,
+			canonical.shortLabel as shortLabel,
+			canonical.strapline as strapline,
+			canonical.terminationDate as terminationDate,
+			canonical.terminationDateEpoch as terminationDateEpoch,
+			canonical.twitterHandle as twitterHandle,
+			collect(sources) as sourceRepresentations,
+			issuer.uuid as issuedBy,
+			labels(canonical) as types,
+			membershipRoles,
+			org.uuid as organisationUUID,
+			person.uuid as personUUID,
+			canonical.properName as properName,
+			canonical.shortName as shortName,
+			canonical.tradeNames as tradeNames,
+			canonical.formerNames as formerNames,
+			canonical.countryCode as countryCode,
+			canonical.countryOfIncorporation as countryOfIncorporation,
+			canonical.countryOfOperations as countryOfOperations,
+			canonical.countryOfRisk as countryOfRisk,
+			canonical.postalCode as postalCode,
+			canonical.yearFounded as yearFounded,
+			canonical.leiCode as leiCode,
+			canonical.isDeprecated as isDeprecated,
+			canonical.salutation as salutation,
+			canonical.birthYear as birthYear,
+			canonical.iso31661 as iso31661
+		ORDER BY prefUUID`
+
+func TestReadMatchesLegacyReadForAllFixtures(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	assert.NoError(t, err)
+
+	for _, file := range files {
+		t.Run(file.Name(), func(t *testing.T) {
+			defer cleanDB(t)
+
+			concept := getAggregatedConcept(t, file.Name())
+			if _, err := conceptsDriver.Write(context.Background(), concept, "test_tid"); err != nil {
+				t.Skipf("Fixture can't be written on its own: %v", err)
+			}
+
+			actual, found, err := conceptsDriver.Read(context.Background(), concept.PrefUUID, "test_tid")
+			assert.NoError(t, err)
+			assert.True(t, found)
+
+			var results []neoAggregatedConcept
+			err = db.CypherBatch([]*neoism.CypherQuery{{
+				Statement: `
+					MATCH (canonical:Thing {prefUUID:$uuid})<-[:EQUIVALENT_TO]-(source:Thing)` + legacyReadConceptReturnClause,
+				Parameters: map[string]interface{}{"uuid": concept.PrefUUID},
+				Result:     &results,
+			}})
+			assert.NoError(t, err)
+			if !assert.NotEmpty(t, results) {
+				return
+			}
+			expected, err := buildAggregatedConcept(results[0], "test_tid")
+			assert.NoError(t, err)
+
+			assert.Equal(t, sortUnorderedLists(expected), sortUnorderedLists(actual.(AggregatedConcept)))
+		})
+	}
+}
+
+//Sort the lists that are read in no particular order and aren't already sorted when read
+func sortUnorderedLists(c AggregatedConcept) AggregatedConcept {
+	for i := range c.SourceRepresentations {
+		sort.Strings(c.SourceRepresentations[i].ParentUUIDs)
+	}
+	sort.SliceStable(c.MembershipRoles, func(i, j int) bool {
+		return c.MembershipRoles[i].RoleUUID < c.MembershipRoles[j].RoleUUID
+	})
+	return c
+}
+
+//Connection to the test database, over Bolt or HTTP depending on the scheme of its URL
+func newTestConnection() neoutils.NeoConnection {
+	if IsBoltURL(newURL()) {
+		conn, err := NewBoltConnection(newURL(), BoltConfig{
+			Username: os.Getenv("NEO4J_TEST_USERNAME"),
+			Password: os.Getenv("NEO4J_TEST_PASSWORD"),
+		})
+		if err != nil {
+			return nil
+		}
+		return conn
+	}
+
+	conf := neoutils.DefaultConnectionConfig()
+	conf.Transactional = false
+	db, _ := neoutils.Connect(newURL(), conf)
+	return db
+}
+
+func newURL() string {
+	url := os.Getenv("NEO4J_TEST_URL")
+	if url == "" {
+		url = "http://localhost:7474/db/data"
+	}
+	return url
+}
+
+func TestDataMigrationsAreAppliedOnceByOneReplica(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "single-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	written, _, err := conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+
+	//a canonical node written before concepts were hashed, an orphaned identifier, and the data migrations yet to be applied
+	err = db.CypherBatch([]*neoism.CypherQuery{
+		{
+			Statement:  `MATCH (c:Thing {prefUUID: $uuid}) REMOVE c.aggregateHash, c.aggregateHashVersion`,
+			Parameters: map[string]interface{}{"uuid": basicConceptUUID},
+		},
+		{
+			Statement:  `CREATE (:Identifier:TMEIdentifier {value: $value})`,
+			Parameters: map[string]interface{}{"value": "orphaned-identifier"},
+		},
+		{Statement: `MATCH (m:SchemaMigration) WHERE m.version > 1 DELETE m`},
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	applied := make([][]MigrationStatus, 2)
+	for i := range applied {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			replica := newTestConceptService(nil)
+			applied[i], err = replica.MigrateUp(context.Background())
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, append(applied[0], applied[1]...), len(neo4jMigrations)-1, "Each migration should be applied by a single replica")
+
+	status, err := conceptsDriver.MigrationStatus(context.Background())
+	assert.NoError(t, err)
+	for _, migration := range status {
+		assert.True(t, migration.Applied(), "Migration %d should be applied", migration.Version)
+	}
+
+	read, _, err := conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.Equal(t, written.(AggregatedConcept).AggregatedHash, read.(AggregatedConcept).AggregatedHash, "The hash should be backfilled")
+
+	var identifiers []struct {
+		Count int `json:"count"`
+	}
+	err = db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (i:Identifier {value: $value}) RETURN count(i) as count`,
+		Parameters: map[string]interface{}{"value": "orphaned-identifier"},
+		Result:     &identifiers,
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, identifiers[0].Count, "The orphaned identifier should be dropped")
+}
+
+func cleanDB(t *testing.T) {
+	cleanSourceNodes(t,
+		parentUUID,
+		anotherBasicConceptUUID,
+		basicConceptUUID,
+		sourceID1,
+		sourceID2,
+		sourceID3,
+		unknownThingUUID,
+		anotherUnknownThingUUID,
+		yetAnotherBasicConceptUUID,
+		membershipRole.RoleUUID,
+		personUUID,
+		organisationUUID,
+		membershipUUID,
+		anotherMembershipRole.RoleUUID,
+		anotherOrganisationUUID,
+		anotherPersonUUID,
+		simpleSmartlogicTopicUUID,
+		boardRoleUUID,
+		financialInstrumentSameIssuerUUID,
+		financialInstrumentUUID,
+		financialOrgUUID,
+		anotherFinancialOrgUUID,
+		parentOrgUUID,
+		supersededByUUID,
+		testOrgUUID,
+		locationUUID,
+		anotherLocationUUID,
+		brandUUID,
+		anotherBrandUUID,
+		yetAnotherBrandUUID,
+		topicUUID,
+		anotherTopicUUID,
+		conceptHasFocusUUID,
+		anotherConceptHasFocusUUID,
+	)
+	deleteSourceNodes(t,
+		parentUUID,
+		anotherBasicConceptUUID,
+		basicConceptUUID,
+		sourceID1,
+		sourceID2,
+		sourceID3,
+		unknownThingUUID,
+		anotherUnknownThingUUID,
+		yetAnotherBasicConceptUUID,
+		membershipRole.RoleUUID,
+		personUUID,
+		organisationUUID,
+		membershipUUID,
+		anotherMembershipRole.RoleUUID,
+		anotherOrganisationUUID,
+		anotherPersonUUID,
+		simpleSmartlogicTopicUUID,
+		boardRoleUUID,
+		financialInstrumentSameIssuerUUID,
+		financialInstrumentUUID,
+		financialOrgUUID,
+		anotherFinancialOrgUUID,
+		parentOrgUUID,
+		supersededByUUID,
+		testOrgUUID,
+		locationUUID,
+		anotherLocationUUID,
+		brandUUID,
+		anotherBrandUUID,
+		yetAnotherBrandUUID,
+		topicUUID,
+		anotherTopicUUID,
+		conceptHasFocusUUID,
+		anotherConceptHasFocusUUID,
+	)
+	deleteConcordedNodes(t,
+		parentUUID,
+		basicConceptUUID,
+		anotherBasicConceptUUID,
+		sourceID1,
+		sourceID2,
+		sourceID3,
+		unknownThingUUID,
+		anotherUnknownThingUUID,
+		yetAnotherBasicConceptUUID,
+		membershipRole.RoleUUID,
+		personUUID,
+		organisationUUID,
+		membershipUUID,
+		anotherMembershipRole.RoleUUID,
+		anotherOrganisationUUID,
+		anotherPersonUUID,
+		simpleSmartlogicTopicUUID,
+		boardRoleUUID,
+		financialInstrumentSameIssuerUUID,
+		financialInstrumentUUID,
+		financialOrgUUID,
+		anotherFinancialOrgUUID,
+		parentOrgUUID,
+		supersededByUUID,
+		testOrgUUID,
+		locationUUID,
+		anotherLocationUUID,
+		brandUUID,
+		anotherBrandUUID,
+		yetAnotherBrandUUID,
+		topicUUID,
+		anotherTopicUUID,
+		conceptHasFocusUUID,
+		anotherConceptHasFocusUUID,
+	)
+}
+
+func deleteSourceNodes(t *testing.T, uuids ...string) {
+	qs := make([]*neoism.CypherQuery, len(uuids))
+	for i, uuid := range uuids {
+		qs[i] = &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`
+			MATCH (a:Thing {uuid: "%s"})
+			OPTIONAL MATCH (a)-[rel:IDENTIFIES]-(i)
+			DETACH DELETE rel, i, a`, uuid)}
+	}
+	err := db.CypherBatch(qs)
+	assert.NoError(t, err, "Error executing clean up cypher")
+}
+
+func cleanSourceNodes(t *testing.T, uuids ...string) {
+	qs := make([]*neoism.CypherQuery, len(uuids))
+	for i, uuid := range uuids {
+		qs[i] = &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`
+			MATCH (a:Thing {uuid: "%s"})
+			OPTIONAL MATCH (a)-[rel:IDENTIFIES]-(i)
+			OPTIONAL MATCH (a)-[hp:HAS_PARENT]-(p)
+			DELETE rel, hp, i`, uuid)}
+	}
+	err := db.CypherBatch(qs)
+	assert.NoError(t, err, "Error executing clean up cypher")
+}
+
+func deleteConcordedNodes(t *testing.T, uuids ...string) {
+	qs := make([]*neoism.CypherQuery, len(uuids))
+	for i, uuid := range uuids {
+		qs[i] = &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`
+			MATCH (a:Thing {prefUUID: "%s"})
+			OPTIONAL MATCH (a)-[rel]-(i)
+			DELETE rel, i, a`, uuid)}
+	}
+	err := db.CypherBatch(qs)
+	assert.NoError(t, err, "Error executing clean up cypher")
+}
+
+func getIdentifierValue(t *testing.T, uuidPropertyName string, uuid string, label string) string {
+	var results []struct {
+		Value string `json:"i.value"`
+	}
+
+	query := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`
+			match (c:Concept {%s :$uuid})-[r:IDENTIFIES]-(i:%s) return i.value
+		`, uuidPropertyName, label),
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+	err := db.CypherBatch([]*neoism.CypherQuery{query})
+	assert.NoError(t, err, fmt.Sprintf("Error while retrieving %s", label))
+
+	if len(results) > 0 {
+		return results[0].Value
+	}
+	return ""
+}
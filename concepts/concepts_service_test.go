@@ -1,26 +1,25 @@
-// +build integration
-
 package concepts
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"reflect"
-	"strconv"
 	"testing"
 	"time"
 
 	"sort"
 	"strings"
 
-	logger "github.com/Financial-Times/go-logger"
-	"github.com/Financial-Times/neo-utils-go/neoutils"
-	"github.com/jmcvetta/neoism"
-	"github.com/mitchellh/hashstructure"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -83,9 +82,6 @@ var (
 	}
 )
 
-//Reusable Neo4J connection
-var db neoutils.NeoConnection
-
 //Concept Service under test
 var conceptsDriver ConceptService
 
@@ -272,24 +268,6 @@ func getLocationWithISO31661AndConcordance() AggregatedConcept {
 	}
 }
 
-func init() {
-	// We are initialising a lot of constraints on an empty database therefore we need the database to be fit before
-	// we run tests so initialising the service will create the constraints first
-	logger.InitLogger("test-concepts-rw-neo4j", "panic")
-
-	conf := neoutils.DefaultConnectionConfig()
-	conf.Transactional = false
-	db, _ = neoutils.Connect(newURL(), conf)
-	if db == nil {
-		panic("Cannot connect to Neo4J")
-	}
-	conceptsDriver = NewConceptService(db)
-	conceptsDriver.Initialise()
-
-	duration := 5 * time.Second
-	time.Sleep(duration)
-}
-
 func TestWriteService(t *testing.T) {
 	defer cleanDB(t)
 
@@ -316,7 +294,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "8966100227992392390",
+						AggregateHash: "2241617903073976469",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -335,7 +313,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "MembershipRole",
 						ConceptUUID:   membershipRoleUUID,
-						AggregateHash: "4736851904774254398",
+						AggregateHash: "17359132515848968394",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -354,7 +332,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "BoardRole",
 						ConceptUUID:   boardRoleUUID,
-						AggregateHash: "6010858390775404708",
+						AggregateHash: "5048637367592855636",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -373,7 +351,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Membership",
 						ConceptUUID:   membershipUUID,
-						AggregateHash: "7019061401434978811",
+						AggregateHash: "1604281063908698816",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -392,7 +370,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "FinancialInstrument",
 						ConceptUUID:   financialInstrumentUUID,
-						AggregateHash: "9575737322231665672",
+						AggregateHash: "8545802780420735214",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -414,7 +392,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "9536378708739677504",
+						AggregateHash: "3675474785341764688",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -433,7 +411,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "45321760500994471",
+						AggregateHash: "7327730276982559796",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -455,7 +433,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "9201682028567714380",
+						AggregateHash: "11003375869980132004",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -477,7 +455,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "15940139693753715464",
+						AggregateHash: "9393353354717919233",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -496,7 +474,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "3210137865023501797",
+						AggregateHash: "16736823607411818758",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -518,7 +496,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "2222026374973918362",
+						AggregateHash: "3271252467295458367",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -540,7 +518,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Brand",
 						ConceptUUID:   brandUUID,
-						AggregateHash: "7468848243852800761",
+						AggregateHash: "15170535038376130433",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -559,7 +537,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Brand",
 						ConceptUUID:   brandUUID,
-						AggregateHash: "6300846664463312894",
+						AggregateHash: "3070538182420378552",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -581,7 +559,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Brand",
 						ConceptUUID:   brandUUID,
-						AggregateHash: "8834278337511436639",
+						AggregateHash: "15922447503283845610",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -603,7 +581,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Brand",
 						ConceptUUID:   brandUUID,
-						AggregateHash: "5821941621968732281",
+						AggregateHash: "5241391474555792765",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -611,7 +589,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Brand",
 						ConceptUUID:   anotherBrandUUID,
-						AggregateHash: "5821941621968732281",
+						AggregateHash: "5241391474555792765",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -619,7 +597,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Brand",
 						ConceptUUID:   anotherBrandUUID,
-						AggregateHash: "5821941621968732281",
+						AggregateHash: "5241391474555792765",
 						EventDetails: ConcordanceEvent{
 							Type:  AddedEvent,
 							OldID: anotherBrandUUID,
@@ -644,7 +622,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Organisation",
 						ConceptUUID:   conceptHasFocusUUID,
-						AggregateHash: "17420994604106187127",
+						AggregateHash: "8881833584958927999",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -666,7 +644,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Brand",
 						ConceptUUID:   yetAnotherBrandUUID,
-						AggregateHash: "5205400880431027175",
+						AggregateHash: "14818777473438355354",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -685,7 +663,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Organisation",
 						ConceptUUID:   conceptHasFocusUUID,
-						AggregateHash: "11886067040947751716",
+						AggregateHash: "9791834952936916005",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -707,7 +685,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Organisation",
 						ConceptUUID:   conceptHasFocusUUID,
-						AggregateHash: "2563595700813813751",
+						AggregateHash: "3964604094551329398",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -729,7 +707,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Organisation",
 						ConceptUUID:   conceptHasFocusUUID,
-						AggregateHash: "10187588748361539345",
+						AggregateHash: "5576160541460197470",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -737,7 +715,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Organisation",
 						ConceptUUID:   anotherConceptHasFocusUUID,
-						AggregateHash: "10187588748361539345",
+						AggregateHash: "5576160541460197470",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -745,7 +723,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Organisation",
 						ConceptUUID:   anotherConceptHasFocusUUID,
-						AggregateHash: "10187588748361539345",
+						AggregateHash: "5576160541460197470",
 						EventDetails: ConcordanceEvent{
 							Type:  AddedEvent,
 							OldID: anotherConceptHasFocusUUID,
@@ -767,7 +745,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "42627084695574075",
+						AggregateHash: "2930708807031968255",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -786,7 +764,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   anotherBasicConceptUUID,
-						AggregateHash: "9947169282150613764",
+						AggregateHash: "5839858820627183164",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -794,7 +772,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   anotherBasicConceptUUID,
-						AggregateHash: "9947169282150613764",
+						AggregateHash: "5839858820627183164",
 						EventDetails: ConcordanceEvent{
 							Type:  AddedEvent,
 							OldID: anotherBasicConceptUUID,
@@ -804,7 +782,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "9947169282150613764",
+						AggregateHash: "5839858820627183164",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -824,7 +802,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Section",
 						ConceptUUID:   basicConceptUUID,
-						AggregateHash: "14570994023231765613",
+						AggregateHash: "8593007631929010030",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -854,7 +832,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "PublicCompany",
 						ConceptUUID:   testOrgUUID,
-						AggregateHash: "8568957765322831390",
+						AggregateHash: "17375421937159441383",
 						TransactionID: "",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
@@ -885,7 +863,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Location",
 						ConceptUUID:   locationUUID,
-						AggregateHash: "14238924580878973598",
+						AggregateHash: "2364682858758329227",
 						EventDetails: ConcordanceEvent{
 							Type:  AddedEvent,
 							OldID: locationUUID,
@@ -895,7 +873,7 @@ func TestWriteService(t *testing.T) {
 					{
 						ConceptType:   "Location",
 						ConceptUUID:   anotherLocationUUID,
-						AggregateHash: "14238924580878973598",
+						AggregateHash: "2364682858758329227",
 						EventDetails: ConceptEvent{
 							Type: UpdatedEvent,
 						},
@@ -914,11 +892,11 @@ func TestWriteService(t *testing.T) {
 			defer cleanDB(t)
 			// Create the related, broader than and impliedBy on concepts
 			for _, relatedConcept := range test.otherRelatedConcepts {
-				_, err := conceptsDriver.Write(relatedConcept, "")
+				_, err := conceptsDriver.Write(context.Background(), relatedConcept, "")
 				assert.NoError(t, err, "Failed to write related/broader/impliedBy concept")
 			}
 
-			updatedConcepts, err := conceptsDriver.Write(test.aggregatedConcept, "")
+			updatedConcepts, err := conceptsDriver.Write(context.Background(), test.aggregatedConcept, "")
 			if test.errStr == "" {
 				assert.NoError(t, err, "Failed to write concept")
 				readConceptAndCompare(t, test.aggregatedConcept, test.testName)
@@ -975,12 +953,12 @@ func TestWriteMemberships_Organisation(t *testing.T) {
 	defer cleanDB(t)
 
 	org := getAggregatedConcept(t, "organisation.json")
-	_, err := conceptsDriver.Write(org, "test_tid")
+	_, err := conceptsDriver.Write(context.Background(), org, "test_tid")
 	assert.NoError(t, err, "Failed to write concept")
 	readConceptAndCompare(t, org, "TestWriteMemberships_Organisation")
 
 	upOrg := getAggregatedConcept(t, "updated-organisation.json")
-	_, err = conceptsDriver.Write(upOrg, "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), upOrg, "test_tid")
 	assert.NoError(t, err, "Failed to write concept")
 	readConceptAndCompare(t, upOrg, "TestWriteMemberships_Organisation.Updated")
 }
@@ -988,10 +966,10 @@ func TestWriteMemberships_Organisation(t *testing.T) {
 func TestWriteMemberships_CleansUpExisting(t *testing.T) {
 	defer cleanDB(t)
 
-	_, err := conceptsDriver.Write(getAggregatedConcept(t, "membership.json"), "test_tid")
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "membership.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write membership")
 
-	result, _, err := conceptsDriver.Read(membershipUUID, "test_tid")
+	result, _, err := conceptsDriver.Read(context.Background(), membershipUUID, "test_tid")
 	assert.NoError(t, err, "Failed to read membership")
 	ab, err := json.Marshal(cleanHash(result.(AggregatedConcept)))
 
@@ -1007,10 +985,10 @@ func TestWriteMemberships_CleansUpExisting(t *testing.T) {
 	assert.Equal(t, "Mr", originalMembership.Salutation)
 	assert.Equal(t, 2018, originalMembership.BirthYear)
 
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "updated-membership.json"), "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "updated-membership.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write membership")
 
-	updatedResult, _, err := conceptsDriver.Read(membershipUUID, "test_tid")
+	updatedResult, _, err := conceptsDriver.Read(context.Background(), membershipUUID, "test_tid")
 	assert.NoError(t, err, "Failed to read membership")
 	cd, err := json.Marshal(cleanHash(updatedResult.(AggregatedConcept)))
 
@@ -1023,46 +1001,21 @@ func TestWriteMemberships_CleansUpExisting(t *testing.T) {
 	assert.Equal(t, anotherPersonUUID, updatedMemebership.PersonUUID)
 }
 
-func TestWriteMemberships_FixOldData(t *testing.T) {
-	defer cleanDB(t)
-
-	queries := createNodeQueries(getConcept(t, "old-membership.json"), "", membershipUUID)
-	err := db.CypherBatch(queries)
-	assert.NoError(t, err, "Failed to write source")
-
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "membership.json"), "test_tid")
-	assert.NoError(t, err, "Failed to write membership")
-
-	result, _, err := conceptsDriver.Read(membershipUUID, "test_tid")
-	assert.NoError(t, err, "Failed to read membership")
-	ab, err := json.Marshal(cleanHash(result.(AggregatedConcept)))
-
-	originalMembership := AggregatedConcept{}
-	json.Unmarshal(ab, &originalMembership)
-
-	originalMembership = cleanConcept(originalMembership)
-
-	assert.Equal(t, len(originalMembership.MembershipRoles), 2)
-	assert.True(t, reflect.DeepEqual([]MembershipRole{membershipRole, anotherMembershipRole}, originalMembership.MembershipRoles))
-	assert.Equal(t, organisationUUID, originalMembership.OrganisationUUID)
-	assert.Equal(t, personUUID, originalMembership.PersonUUID)
-}
-
 func TestFinancialInstrumentExistingIssuedByRemoved(t *testing.T) {
 	defer cleanDB(t)
 
-	_, err := conceptsDriver.Write(getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write financial instrument")
 
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write financial instrument")
 
 	readConceptAndCompare(t, getAggregatedConcept(t, "financial-instrument.json"), "TestFinancialInstrumentExistingIssuedByRemoved")
 
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "updated-financial-instrument.json"), "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "updated-financial-instrument.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write financial instrument")
 
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write financial instrument")
 
 	readConceptAndCompare(t, getAggregatedConcept(t, "financial-instrument.json"), "TestFinancialInstrumentExistingIssuedByRemoved")
@@ -1071,12 +1024,12 @@ func TestFinancialInstrumentExistingIssuedByRemoved(t *testing.T) {
 func TestFinancialInstrumentIssuerOrgRelationRemoved(t *testing.T) {
 	defer cleanDB(t)
 
-	_, err := conceptsDriver.Write(getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "financial-instrument.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write financial instrument")
 
 	readConceptAndCompare(t, getAggregatedConcept(t, "financial-instrument.json"), "TestFinancialInstrumentExistingIssuedByRemoved")
 
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "financial-instrument-with-same-issuer.json"), "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "financial-instrument-with-same-issuer.json"), "test_tid")
 	assert.NoError(t, err, "Failed to write financial instrument")
 
 	readConceptAndCompare(t, getAggregatedConcept(t, "financial-instrument-with-same-issuer.json"), "TestFinancialInstrumentExistingIssuedByRemoved")
@@ -1129,7 +1082,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID1,
-					AggregateHash: "7015225359276080595",
+					AggregateHash: "11348245269118167910",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1138,7 +1091,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID1,
-					AggregateHash: "7015225359276080595",
+					AggregateHash: "11348245269118167910",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  AddedEvent,
@@ -1149,7 +1102,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "7015225359276080595",
+					AggregateHash: "11348245269118167910",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1175,7 +1128,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID1,
-					AggregateHash: "10854562798375767778",
+					AggregateHash: "4360181735073455407",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  RemovedEvent,
@@ -1186,7 +1139,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "10854562798375767778",
+					AggregateHash: "4360181735073455407",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1220,7 +1173,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "1354372065821474513",
+					AggregateHash: "2827625118244058441",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  AddedEvent,
@@ -1231,7 +1184,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID2,
-					AggregateHash: "1354372065821474513",
+					AggregateHash: "2827625118244058441",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1240,7 +1193,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID2,
-					AggregateHash: "1354372065821474513",
+					AggregateHash: "2827625118244058441",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  AddedEvent,
@@ -1251,7 +1204,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   anotherBasicConceptUUID,
-					AggregateHash: "1354372065821474513",
+					AggregateHash: "2827625118244058441",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1279,7 +1232,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID1,
-					AggregateHash: "13457130113082811866",
+					AggregateHash: "6501156029936511886",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  RemovedEvent,
@@ -1290,7 +1243,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID1,
-					AggregateHash: "13457130113082811866",
+					AggregateHash: "6501156029936511886",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  AddedEvent,
@@ -1301,7 +1254,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   anotherBasicConceptUUID,
-					AggregateHash: "13457130113082811866",
+					AggregateHash: "6501156029936511886",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1328,7 +1281,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID2,
-					AggregateHash: "15457779693968162930",
+					AggregateHash: "17382704282565194352",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1337,7 +1290,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID2,
-					AggregateHash: "15457779693968162930",
+					AggregateHash: "17382704282565194352",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  AddedEvent,
@@ -1348,7 +1301,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "15457779693968162930",
+					AggregateHash: "17382704282565194352",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1376,7 +1329,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   sourceID2,
-					AggregateHash: "7015225359276080595",
+					AggregateHash: "11348245269118167910",
 					TransactionID: "test_tid",
 					EventDetails: ConcordanceEvent{
 						Type:  RemovedEvent,
@@ -1387,7 +1340,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "7015225359276080595",
+					AggregateHash: "11348245269118167910",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1414,7 +1367,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "7923819004535155836",
+					AggregateHash: "3383481585406498138",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1444,7 +1397,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "17087913865233741773",
+					AggregateHash: "9107403566299229671",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1472,7 +1425,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "4311252056577124493",
+					AggregateHash: "6425083167723965547",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1501,7 +1454,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 				{
 					ConceptType:   "Brand",
 					ConceptUUID:   basicConceptUUID,
-					AggregateHash: "10854562798375767778",
+					AggregateHash: "4360181735073455407",
 					TransactionID: "test_tid",
 					EventDetails: ConceptEvent{
 						Type: UpdatedEvent,
@@ -1537,11 +1490,11 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 	for _, scenario := range scenarios {
 		cleanDB(t)
 		//Write data into db, to set up test scenario
-		_, err := conceptsDriver.Write(scenario.setUpConcept, tid)
+		_, err := conceptsDriver.Write(context.Background(), scenario.setUpConcept, tid)
 		assert.NoError(t, err, "Scenario "+scenario.testName+" failed; returned unexpected error")
 		verifyAggregateHashIsCorrect(t, scenario.setUpConcept, scenario.testName)
 		//Overwrite data with update
-		output, err := conceptsDriver.Write(scenario.testConcept, tid)
+		output, err := conceptsDriver.Write(context.Background(), scenario.testConcept, tid)
 		actualChanges := output.(ConceptChanges)
 		sort.Slice(actualChanges.ChangedRecords, func(i, j int) bool {
 			l, _ := json.Marshal(actualChanges.ChangedRecords[i])
@@ -1571,7 +1524,7 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 		assert.Equal(t, scenario.updatedConcepts, actualChanges, "Test "+scenario.testName+" failed: Updated uuid list differs from expected")
 
 		for _, id := range scenario.uuidsToCheck {
-			conceptIf, found, err := conceptsDriver.Read(id, tid)
+			conceptIf, found, err := conceptsDriver.Read(context.Background(), id, tid)
 			concept := cleanHash(conceptIf.(AggregatedConcept))
 			if found {
 				assert.NotNil(t, concept, "Scenario "+scenario.testName+" failed; id: "+id+" should return a valid concept")
@@ -1593,16 +1546,16 @@ func TestWriteService_HandlingConcordance(t *testing.T) {
 func TestMultipleConcordancesAreHandled(t *testing.T) {
 	defer cleanDB(t)
 
-	_, err := conceptsDriver.Write(getAggregatedConcept(t, "full-lone-aggregated-concept.json"), "test_tid")
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "full-lone-aggregated-concept.json"), "test_tid")
 	assert.NoError(t, err, "Test TestMultipleConcordancesAreHandled failed; returned unexpected error")
 
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "lone-tme-section.json"), "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "lone-tme-section.json"), "test_tid")
 	assert.NoError(t, err, "Test TestMultipleConcordancesAreHandled failed; returned unexpected error")
 
-	_, err = conceptsDriver.Write(getAggregatedConcept(t, "transfer-multiple-source-concordance.json"), "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "transfer-multiple-source-concordance.json"), "test_tid")
 	assert.NoError(t, err, "Test TestMultipleConcordancesAreHandled failed; returned unexpected error")
 
-	conceptIf, found, err := conceptsDriver.Read(simpleSmartlogicTopicUUID, "test_tid")
+	conceptIf, found, err := conceptsDriver.Read(context.Background(), simpleSmartlogicTopicUUID, "test_tid")
 	concept := cleanHash(conceptIf.(AggregatedConcept))
 	assert.NoError(t, err, "Should be able to read concept with no problems")
 	assert.True(t, found, "Concept should exist")
@@ -1610,42 +1563,545 @@ func TestMultipleConcordancesAreHandled(t *testing.T) {
 	readConceptAndCompare(t, getAggregatedConcept(t, "transfer-multiple-source-concordance.json"), "TestMultipleConcordancesAreHandled")
 }
 
-func TestInvalidTypesThrowError(t *testing.T) {
-	invalidPrefConceptType := `MERGE (t:Thing{prefUUID:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea"}) SET t={prefUUID:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea", prefLabel:"The Best Label"} SET t:Concept:Brand:Unknown MERGE (s:Thing{uuid:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea"}) SET s={uuid:"bbc4f575-edb3-4f51-92f0-5ce6c708d1ea"} SET t:Concept:Brand MERGE (t)<-[:EQUIVALENT_TO]-(s)`
-	invalidSourceConceptType := `MERGE (t:Thing{prefUUID:"4c41f314-4548-4fb6-ac48-4618fcbfa84c"}) SET t={prefUUID:"4c41f314-4548-4fb6-ac48-4618fcbfa84c", prefLabel:"The Best Label"} SET t:Concept:Brand MERGE (s:Thing{uuid:"4c41f314-4548-4fb6-ac48-4618fcbfa84c"}) SET s={uuid:"4c41f314-4548-4fb6-ac48-4618fcbfa84c"} SET t:Concept:Brand:Unknown MERGE (t)<-[:EQUIVALENT_TO]-(s)`
+func TestDryRunWriteDoesNotCommit(t *testing.T) {
+	defer cleanDB(t)
 
-	type testStruct struct {
-		testName         string
-		prefUUID         string
-		statementToWrite string
-		returnedError    error
+	singleConcordance := getAggregatedConcept(t, "single-concordance.json")
+	_, err := conceptsDriver.Write(context.Background(), singleConcordance, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	dryRunChanges, err := conceptsDriver.WriteWithOptions(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid", WriteOptions{DryRun: true})
+	assert.NoError(t, err, "Dry run should not fail")
+	readConceptAndCompare(t, singleConcordance, "TestDryRunWriteDoesNotCommit")
+
+	changes, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	assert.Equal(t, changes, dryRunChanges, "Dry run should report the same changes as a real write")
+	readConceptAndCompare(t, getAggregatedConcept(t, "dual-concordance.json"), "TestDryRunWriteDoesNotCommit")
+}
+
+func TestDryRunWriteReportsBrokenConcordance(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	_, err = conceptsDriver.WriteWithOptions(context.Background(), getAggregatedConcept(t, "pref-uuid-as-source.json"), "test_tid", WriteOptions{DryRun: true})
+	assert.Error(t, err, "Dry run should report the concordance would be broken")
+	readConceptAndCompare(t, getAggregatedConcept(t, "dual-concordance.json"), "TestDryRunWriteReportsBrokenConcordance")
+}
+
+func TestConditionalWrite(t *testing.T) {
+	defer cleanDB(t)
+
+	singleConcordance := getAggregatedConcept(t, "single-concordance.json")
+	_, err := conceptsDriver.WriteWithOptions(context.Background(), singleConcordance, "test_tid", WriteOptions{Precondition: &Precondition{IfMatch: []string{"*"}}})
+	assert.IsType(t, preconditionError{}, err, "If-Match should fail when the concept does not exist")
+
+	_, err = conceptsDriver.WriteWithOptions(context.Background(), singleConcordance, "test_tid", WriteOptions{Precondition: &Precondition{IfNoneMatch: []string{"*"}}})
+	assert.NoError(t, err, "If-None-Match should succeed when the concept does not exist")
+
+	_, err = conceptsDriver.WriteWithOptions(context.Background(), singleConcordance, "test_tid", WriteOptions{Precondition: &Precondition{IfNoneMatch: []string{"*"}}})
+	assert.IsType(t, preconditionError{}, err, "If-None-Match should fail when the concept exists")
+
+	stored, _, err := conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	storedHash := stored.(AggregatedConcept).AggregatedHash
+
+	_, err = conceptsDriver.WriteWithOptions(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid", WriteOptions{Precondition: &Precondition{IfMatch: []string{"not-the-hash"}}})
+	assert.IsType(t, preconditionError{}, err, "If-Match should fail when the stored hash differs")
+	readConceptAndCompare(t, singleConcordance, "TestConditionalWrite")
+
+	_, err = conceptsDriver.WriteWithOptions(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid", WriteOptions{Precondition: &Precondition{IfMatch: []string{storedHash}}})
+	assert.NoError(t, err, "If-Match should succeed when the stored hash matches")
+	readConceptAndCompare(t, getAggregatedConcept(t, "dual-concordance.json"), "TestConditionalWrite")
+}
+
+func TestWriteIgnoresOrderOfLists(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "full-concorded-aggregated-concept.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	reordered := getAggregatedConcept(t, "full-concorded-aggregated-concept.json")
+	reverse := func(values []string) []string {
+		reversed := make([]string, 0, len(values))
+		for i := len(values) - 1; i >= 0; i-- {
+			reversed = append(reversed, values[i])
+		}
+		return reversed
+	}
+	reordered.Aliases = reverse(reordered.Aliases)
+	for i, j := 0, len(reordered.SourceRepresentations)-1; i < j; i, j = i+1, j-1 {
+		reordered.SourceRepresentations[i], reordered.SourceRepresentations[j] = reordered.SourceRepresentations[j], reordered.SourceRepresentations[i]
+	}
+	for i := range reordered.SourceRepresentations {
+		reordered.SourceRepresentations[i].RelatedUUIDs = reverse(reordered.SourceRepresentations[i].RelatedUUIDs)
+	}
+
+	output, err := conceptsDriver.Write(context.Background(), reordered, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	assert.Empty(t, output.(ConceptChanges).ChangedRecords, "The same concept in a different order should not be written again")
+}
+
+func TestWriteGivesUpWhenTheContextIsDone(t *testing.T) {
+	defer cleanDB(t)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := conceptsDriver.Write(cancelled, getAggregatedConcept(t, "lone-tme-section.json"), "test_tid")
+	assert.Equal(t, context.Canceled, err)
+
+	// a write waiting for another write to the same concept gives up once its deadline has passed
+	unlock, err := conceptsDriver.locks.lock(context.Background(), []string{yetAnotherBasicConceptUUID})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = conceptsDriver.Write(ctx, getAggregatedConcept(t, "lone-tme-section.json"), "test_tid")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	unlock()
+
+	_, found, err := conceptsDriver.Read(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.False(t, found, "A write given up on should not be written")
+}
+
+func TestWritesInvalidateReadCache(t *testing.T) {
+	defer cleanDB(t)
+
+	service := newTestConceptService(responseOnlyEventPublisher{})
+	service.EnableReadCache(10, 0)
+
+	_, err := service.Write(context.Background(), getAggregatedConcept(t, "lone-tme-section.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	_, found, err := service.Read(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.True(t, found, "Concept should exist")
+
+	concept := getAggregatedConcept(t, "transfer-multiple-source-concordance.json")
+	_, err = service.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	_, found, err = service.Read(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.False(t, found, "The lone concept removed by the concordance should no longer be read from the cache")
+
+	conceptIf, found, err := service.Read(context.Background(), simpleSmartlogicTopicUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.True(t, found, "Concept should exist")
+	concept.PrefLabel = "A new pref label"
+	_, err = service.Write(context.Background(), concept, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	conceptIf, _, err = service.Read(context.Background(), simpleSmartlogicTopicUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.Equal(t, "A new pref label", conceptIf.(AggregatedConcept).PrefLabel, "The written concept should no longer be read from the cache")
+
+	_, _, err = service.Delete(context.Background(), simpleSmartlogicTopicUUID, "test_tid")
+	assert.NoError(t, err)
+	_, found, err = service.Read(context.Background(), simpleSmartlogicTopicUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.False(t, found, "The deleted concordance should no longer be read from the cache")
+}
+
+func TestWritesAreMeasured(t *testing.T) {
+	defer cleanDB(t)
+
+	service := newTestConceptService(responseOnlyEventPublisher{})
+	service.metrics = newConceptMetrics(prometheus.NewRegistry())
+
+	_, err := service.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	_, err = service.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	_, err = service.Write(context.Background(), getAggregatedConcept(t, "transfer-source-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	for _, madeUpType := range []string{"MadeUp", "AlsoMadeUp"} {
+		invalid := getAggregatedConcept(t, "dual-concordance.json")
+		invalid.Type = madeUpType
+		_, err = service.Write(context.Background(), invalid, "test_tid")
+		assert.Error(t, err, "A concept of an unknown type should not be written")
 	}
+	_, _, err = service.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	_, _, err = service.Read(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err)
 
-	invalidPrefConceptTypeTest := testStruct{
-		testName:         "invalidPrefConceptTypeTest",
-		prefUUID:         basicConceptUUID,
-		statementToWrite: invalidPrefConceptType,
-		returnedError:    nil,
+	tests := []struct {
+		name     string
+		counter  prometheus.Collector
+		expected float64
+	}{
+		{"Unchanged writes", service.metrics.unchanged.WithLabelValues("Brand"), 1},
+		{"Updated events", service.metrics.events.WithLabelValues(UpdatedEvent), 3},
+		{"Concordance added events", service.metrics.events.WithLabelValues(AddedEvent), 2},
+		{"Concordance removed events", service.metrics.events.WithLabelValues(RemovedEvent), 1},
+		{"Stale data alerts", service.metrics.alerts.WithLabelValues(alertStaleData), 1},
+		{"Invalid concordance alerts", service.metrics.alerts.WithLabelValues(alertInvalidConcordance), 0},
 	}
-	invalidSourceConceptTypeTest := testStruct{
-		testName:         "invalidSourceConceptTypeTest",
-		prefUUID:         anotherBasicConceptUUID,
-		statementToWrite: invalidSourceConceptType,
-		returnedError:    nil,
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, testutil.ToFloat64(test.counter))
+		})
 	}
 
-	scenarios := []testStruct{invalidPrefConceptTypeTest, invalidSourceConceptTypeTest}
+	assert.Equal(t, 2, testutil.CollectAndCount(service.metrics.writeDuration), "Writes should be measured by concept type, or as unknown if it isn't valid")
+	assert.Equal(t, 2, testutil.CollectAndCount(service.metrics.readDuration), "Reads should be measured by the type of the concept read, if any")
+}
 
-	for _, scenario := range scenarios {
-		db.CypherBatch([]*neoism.CypherQuery{{Statement: scenario.statementToWrite}})
-		aggConcept, found, err := conceptsDriver.Read(scenario.prefUUID, "")
-		assert.Equal(t, AggregatedConcept{}, aggConcept, "Scenario "+scenario.testName+" failed; aggregate concept should be empty")
-		assert.Equal(t, false, found, "Scenario "+scenario.testName+" failed; aggregate concept should not be returned from read")
-		assert.Error(t, err, "Scenario "+scenario.testName+" failed; read of concept should return error")
-		assert.Contains(t, err.Error(), "provided types are not a consistent hierarchy", "Scenario "+scenario.testName+" failed; should throw error from mapper.MostSpecificType function")
+func TestPatchConcept(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	r := mux.NewRouter()
+	handler := ConceptsHandler{ConceptsService: &conceptsDriver}
+	handler.RegisterHandlers(r)
+	req, _ := http.NewRequest("PATCH", "/brands/"+basicConceptUUID, strings.NewReader(`{"strapline":"Keeping it patched","aliases":["patchedLabel"]}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	expected := getAggregatedConcept(t, "dual-concordance.json")
+	expected.Strapline = "Keeping it patched"
+	expected.Aliases = []string{"patchedLabel"}
+	readConceptAndCompare(t, expected, "TestPatchConcept")
+}
+
+func TestBulkWrite(t *testing.T) {
+	defer cleanDB(t)
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.Encode(getAggregatedConcept(t, "topic.json"))
+	enc.Encode(getAggregatedConcept(t, "dual-concordance.json"))
+	body.WriteString("{\"prefUUID\":\n")
+
+	r := mux.NewRouter()
+	handler := ConceptsHandler{ConceptsService: &conceptsDriver}
+	handler.RegisterHandlers(r)
+	req, _ := http.NewRequest("POST", "/__bulk", &body)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var results []BulkWriteResult
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		var result BulkWriteResult
+		assert.NoError(t, dec.Decode(&result))
+		results = append(results, result)
+	}
+	assert.Len(t, results, 3)
+	assert.Equal(t, http.StatusOK, results[0].Status)
+	assert.Equal(t, http.StatusOK, results[1].Status)
+	assert.Equal(t, http.StatusBadRequest, results[2].Status)
+	assert.Equal(t, "invalidRequest", results[2].Error.Type)
+
+	readConceptAndCompare(t, getAggregatedConcept(t, "topic.json"), "TestBulkWrite")
+	readConceptAndCompare(t, getAggregatedConcept(t, "dual-concordance.json"), "TestBulkWrite")
+}
+
+func TestEventsOutbox(t *testing.T) {
+	defer cleanDB(t)
+
+	// events left by other tests are acknowledged so that only the events of this test are in the outbox
+	_, err := conceptsDriver.AcknowledgeEvents(context.Background(), eventCursor{createdAt: math.MaxInt64}.String(), "test_tid")
+	assert.NoError(t, err, "Failed to acknowledge events")
+
+	_, err = conceptsDriver.WriteWithOptions(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid", WriteOptions{DryRun: true})
+	assert.NoError(t, err, "Failed dry run")
+	events, err := conceptsDriver.Events(context.Background(), "", 10, "test_tid")
+	assert.NoError(t, err, "Failed to read events")
+	assert.Empty(t, events, "A dry run should not add events to the outbox")
+
+	output, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	changes := output.(ConceptChanges)
+
+	events, err = conceptsDriver.Events(context.Background(), "", 10, "test_tid")
+	assert.NoError(t, err, "Failed to read events")
+	assert.Equal(t, len(changes.ChangedRecords), len(events), "Every event of the write should be in the outbox")
+	for i, event := range events {
+		assert.Equal(t, changes.ChangedRecords[i].ConceptUUID, event.Event.ConceptUUID)
+		assert.Equal(t, changes.ChangedRecords[i].AggregateHash, event.Event.AggregateHash)
+		assert.Equal(t, "test_tid", event.Event.TransactionID)
+		if i > 0 {
+			previous, err := parseEventCursor(events[i-1].Cursor)
+			assert.NoError(t, err)
+			current, err := parseEventCursor(event.Cursor)
+			assert.NoError(t, err)
+			assert.True(t, current.after(previous), "Events should be in the order they were written")
+		}
+	}
+
+	last := events[len(events)-1].Cursor
+	after, err := conceptsDriver.Events(context.Background(), last, 10, "test_tid")
+	assert.NoError(t, err, "Failed to read events")
+	assert.Empty(t, after, "There should be no events after the last one")
+
+	// an unchanged concept is not written, so has no events
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	//acknowledged events are deleted a few at a time
+	defer func(batchSize int) {
+		outboxDeleteBatchSize = batchSize
+	}(outboxDeleteBatchSize)
+	outboxDeleteBatchSize = 2
+	acknowledged, err := conceptsDriver.AcknowledgeEvents(context.Background(), last, "test_tid")
+	assert.NoError(t, err, "Failed to acknowledge events")
+	assert.Equal(t, len(events), acknowledged)
+
+	events, err = conceptsDriver.Events(context.Background(), "", 10, "test_tid")
+	assert.NoError(t, err, "Failed to read events")
+	assert.Empty(t, events, "Acknowledged events should be removed from the outbox")
+
+	_, err = conceptsDriver.Events(context.Background(), "not a cursor", 10, "test_tid")
+	assert.IsType(t, requestError{}, err)
+	_, err = conceptsDriver.Events(context.Background(), "42", 10, "test_tid")
+	assert.IsType(t, requestError{}, err, "Cursors of the sequence events used to be numbered from are no longer valid")
+}
+
+func TestEventsAreExpired(t *testing.T) {
+	defer cleanDB(t)
+	_, err := conceptsDriver.AcknowledgeEvents(context.Background(), eventCursor{createdAt: math.MaxInt64}.String(), "test_tid")
+	assert.NoError(t, err, "Failed to acknowledge events")
+
+	output, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	written := len(output.(ConceptChanges).ChangedRecords)
+
+	expired, err := conceptsDriver.store.expireEvents(context.Background(), time.Hour, "test_tid")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired, "Events should be kept until the retention period has passed")
+
+	time.Sleep(10 * time.Millisecond)
+	expired, err = conceptsDriver.store.expireEvents(context.Background(), time.Millisecond, "test_tid")
+	assert.NoError(t, err)
+	assert.Equal(t, written, expired, "Events should be expired whether or not they have been acknowledged")
+	events, err := conceptsDriver.Events(context.Background(), "", 10, "test_tid")
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestWritePublishesEvents(t *testing.T) {
+	defer cleanDB(t)
+
+	publisher := &MemoryEventPublisher{}
+	driver := newTestConceptService(publisher)
+
+	_, err := driver.WriteWithOptions(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid", WriteOptions{DryRun: true})
+	assert.NoError(t, err, "Failed dry run")
+	assert.Empty(t, publisher.Events(), "A dry run should not publish events")
+
+	output, err := driver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	assert.Equal(t, output.(ConceptChanges).ChangedRecords, publisher.Events(), "The events of the write should be published")
+
+	publisher.Reset()
+	_, err = driver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	assert.Empty(t, publisher.Events(), "An unchanged concept has no events to publish")
+
+	output, _, err = driver.Delete(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err, "Failed to delete concept")
+	assert.Equal(t, output.(ConceptChanges).ChangedRecords, publisher.Events(), "The events of the delete should be published")
+}
+
+func TestExport(t *testing.T) {
+	defer cleanDB(t)
+
+	topic := getAggregatedConcept(t, "topic.json")
+	anotherTopic := getAggregatedConcept(t, "another-topic.json")
+	for _, concept := range []AggregatedConcept{topic, anotherTopic, getAggregatedConcept(t, "dual-concordance.json")} {
+		_, err := conceptsDriver.Write(context.Background(), concept, "test_tid")
+		assert.NoError(t, err, "Failed to write concept")
+	}
+
+	expected := []AggregatedConcept{anotherTopic, topic}
+	if topic.PrefUUID < anotherTopic.PrefUUID {
+		expected = []AggregatedConcept{topic, anotherTopic}
+	}
+
+	// only concepts written by this test are compared, as others may already be in the db
+	var exported []AggregatedConcept
+	after := ""
+	for {
+		concepts, next, err := conceptsDriver.Export(context.Background(), "Topic", after, 1, "test_tid")
+		assert.NoError(t, err, "Failed to export concepts")
+		assert.True(t, len(concepts) <= 1, "Pages should not be bigger than the limit")
+		for _, concept := range concepts {
+			if concept.PrefUUID == topic.PrefUUID || concept.PrefUUID == anotherTopic.PrefUUID {
+				exported = append(exported, concept)
+			}
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+
+	assert.Equal(t, len(expected), len(exported), "All topics should have been exported")
+	for i := range exported {
+		actual := cleanHash(cleanConcept(exported[i]))
+		read, _, _ := conceptsDriver.Read(context.Background(), expected[i].PrefUUID, "test_tid")
+		assert.Equal(t, cleanHash(cleanConcept(read.(AggregatedConcept))), actual, "Exported concept should match the concept read")
+	}
+
+	_, _, err := conceptsDriver.Export(context.Background(), "UPPIdentifier", "", 10, "test_tid")
+	assert.IsType(t, requestError{}, err, "Only concept types can be exported")
+}
+
+func TestResolveIdentifier(t *testing.T) {
+	defer cleanDB(t)
+
+	for _, concept := range []AggregatedConcept{
+		getAggregatedConcept(t, "dual-concordance.json"),
+		getAggregatedConcept(t, "financial-instrument.json"),
+		getOrganisationWithAllCountries(),
+		getLocationWithISO31661(),
+	} {
+		_, err := conceptsDriver.Write(context.Background(), concept, "test_tid")
+		assert.NoError(t, err, "Failed to write concept")
+	}
+
+	tests := []struct {
+		authority      string
+		authorityValue string
+		expected       []IdentifierResolution
+	}{
+		{"TME", "987as3dza654-TME", []IdentifierResolution{{UUID: sourceID1, PrefUUID: basicConceptUUID, Type: "Brand"}}},
+		{"UPP", sourceID1, []IdentifierResolution{{UUID: sourceID1, PrefUUID: basicConceptUUID, Type: "Brand"}}},
+		{"FACTSET", "746464", []IdentifierResolution{{UUID: financialInstrumentUUID, PrefUUID: financialInstrumentUUID, Type: "FinancialInstrument"}}},
+		{"figiCode", "12345", []IdentifierResolution{{UUID: financialInstrumentUUID, PrefUUID: financialInstrumentUUID, Type: "FinancialInstrument"}}},
+		{"leiCode", "213800KZEW5W6BZMNT62", []IdentifierResolution{{UUID: testOrgUUID, PrefUUID: testOrgUUID, Type: "PublicCompany"}}},
+		{"iso31661", "BG", []IdentifierResolution{{UUID: locationUUID, PrefUUID: locationUUID, Type: "Location"}}},
+		{"TME", "unknown-value", []IdentifierResolution{}},
+	}
+
+	for _, test := range tests {
+		resolutions, found, err := conceptsDriver.ResolveIdentifier(context.Background(), test.authority, test.authorityValue, "test_tid")
+		assert.NoError(t, err, test.authority)
+		assert.Equal(t, len(test.expected) > 0, found, test.authority)
+		assert.Equal(t, test.expected, resolutions, test.authority)
+	}
+
+	_, _, err := conceptsDriver.ResolveIdentifier(context.Background(), "Wikidata", "Q42", "test_tid")
+	assert.IsType(t, requestError{}, err, "Unknown authorities should be rejected")
+}
+
+func TestDeleteConcept(t *testing.T) {
+	defer cleanDB(t)
+
+	dualConcordance := getAggregatedConcept(t, "dual-concordance.json")
+	_, err := conceptsDriver.Write(context.Background(), dualConcordance, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	written, _, err := conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+
+	//The concordance's prefUUID is also one of its sources, which goes with the canonical node as a lone concept's would
+	changes, found, err := conceptsDriver.Delete(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err, "Failed to delete concept")
+	assert.True(t, found, "Concept should have been found")
+
+	actualChanges := changes.(ConceptChanges)
+	sort.Strings(actualChanges.UpdatedIds)
+	assert.Equal(t, []string{sourceID1, basicConceptUUID}, actualChanges.UpdatedIds)
+	assert.Equal(t, []Event{{
+		ConceptType:   "Brand",
+		ConceptUUID:   sourceID1,
+		AggregateHash: written.(AggregatedConcept).AggregatedHash,
+		TransactionID: "test_tid",
+		EventDetails:  ConcordanceEvent{Type: RemovedEvent, OldID: basicConceptUUID, NewID: sourceID1},
+	}}, actualChanges.ChangedRecords, "Only the other sources should be removed from the concordance")
+
+	_, found, err = conceptsDriver.Read(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.False(t, found, "The concept should not be read once it has been deleted")
+
+	for _, source := range dualConcordance.SourceRepresentations {
+		if source.UUID == basicConceptUUID {
+			continue
+		}
+		lone, found, err := conceptsDriver.Read(context.Background(), source.UUID, "test_tid")
+		assert.NoError(t, err)
+		assert.True(t, found, "Unconcorded source %s should have its own canonical node", source.UUID)
+		assert.Equal(t, source.UUID, lone.(AggregatedConcept).PrefUUID)
+		assert.Equal(t, source.PrefLabel, lone.(AggregatedConcept).PrefLabel, "The canonical node should be the source's own")
+		assert.Len(t, lone.(AggregatedConcept).SourceRepresentations, 1)
+		assert.Equal(t, source.AuthorityValue, getIdentifierValue(t, "uuid", source.UUID, authorityToIdentifierLabelMap[source.Authority]), "The source should keep its identifiers")
+	}
+
+	_, found, err = conceptsDriver.Delete(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.False(t, found, "Deleting the concept again should report not found")
+}
+
+func TestDeleteLoneConcept(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "yet-another-full-lone-aggregated-concept.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	changes, found, err := conceptsDriver.Delete(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err, "Failed to delete concept")
+	assert.True(t, found, "Concept should have been found")
+	assert.Equal(t, []string{yetAnotherBasicConceptUUID}, changes.(ConceptChanges).UpdatedIds)
+	assert.Empty(t, changes.(ConceptChanges).ChangedRecords, "A lone concept has no concordance to remove")
+
+	_, found, err = conceptsDriver.Read(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.False(t, found, "Canonical node should have been deleted")
+
+	_, found, err = conceptsDriver.Delete(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.False(t, found, "Deleting a missing concept should report not found")
+}
+
+func TestDeleteConceptWithDependants(t *testing.T) {
+	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "yet-another-full-lone-aggregated-concept.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	_, err = conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "concept-with-related-to.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	_, found, err := conceptsDriver.Delete(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.True(t, found)
+	assert.Error(t, err, "Delete of a concept with dependants should fail")
+	depErr, ok := err.(dependantsError)
+	assert.True(t, ok, "Error should list the dependants")
+	if ok {
+		assert.Equal(t, []string{basicConceptUUID}, depErr.Dependants())
 	}
 
+	_, found, err = conceptsDriver.Read(context.Background(), yetAnotherBasicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.True(t, found, "Concept with dependants should not have been deleted")
+}
+
+func TestDeleteConceptWithDependantsOfAnotherSource(t *testing.T) {
 	defer cleanDB(t)
+
+	_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, "dual-concordance.json"), "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+	dependant := getAggregatedConcept(t, "concept-with-related-to.json")
+	dependant.PrefUUID = yetAnotherBasicConceptUUID
+	dependant.SourceRepresentations[0].UUID = yetAnotherBasicConceptUUID
+	dependant.SourceRepresentations[0].AuthorityValue = yetAnotherBasicConceptUUID
+	dependant.SourceRepresentations[0].RelatedUUIDs = []string{sourceID1}
+	_, err = conceptsDriver.Write(context.Background(), dependant, "test_tid")
+	assert.NoError(t, err, "Failed to write concept")
+
+	_, found, err := conceptsDriver.Delete(context.Background(), basicConceptUUID, "test_tid")
+	assert.True(t, found)
+	depErr, ok := err.(dependantsError)
+	assert.True(t, ok, "Delete of a concordance with a source that has dependants should fail, listing them")
+	if ok {
+		assert.Equal(t, []string{yetAnotherBasicConceptUUID}, depErr.Dependants())
+	}
 }
 
 func TestFilteringOfUniqueIds(t *testing.T) {
@@ -1750,171 +2206,6 @@ func TestFilteringOfUniqueIds(t *testing.T) {
 	}
 }
 
-func TestTransferConcordance(t *testing.T) {
-	statement := `MERGE (a:Thing{prefUUID:"1"}) MERGE (b:Thing{uuid:"1"}) MERGE (c:Thing{uuid:"2"}) MERGE (d:Thing{uuid:"3"}) MERGE (w:Thing{prefUUID:"4"}) MERGE (y:Thing{uuid:"5"}) MERGE (j:Thing{prefUUID:"6"}) MERGE (k:Thing{uuid:"6"}) MERGE (c)-[:EQUIVALENT_TO]->(a)<-[:EQUIVALENT_TO]-(b) MERGE (w)<-[:EQUIVALENT_TO]-(d) MERGE (j)<-[:EQUIVALENT_TO]-(k)`
-	db.CypherBatch([]*neoism.CypherQuery{{Statement: statement}})
-	var emptyQuery []*neoism.CypherQuery
-	var updatedConcept ConceptChanges
-
-	type testStruct struct {
-		testName         string
-		updatedSourceIds map[string]string
-		returnResult     bool
-		returnedError    error
-	}
-
-	nodeHasNoConconcordance := testStruct{
-		testName: "nodeHasNoConconcordance",
-		updatedSourceIds: map[string]string{
-			"5": "Brand"},
-		returnedError: nil,
-	}
-	nodeHasExistingConcordanceWhichWouldCauseDataIssues := testStruct{
-		testName: "nodeHasExistingConcordanceWhichNeedsToBeReWritten",
-		updatedSourceIds: map[string]string{
-			"1": "Brand"},
-		returnedError: errors.New("Cannot currently process this record as it will break an existing concordance with prefUuid: 1"),
-	}
-	nodeHasExistingConcordanceWhichNeedsToBeReWritten := testStruct{
-		testName: "nodeHasExistingConcordanceWhichNeedsToBeReWritten",
-		updatedSourceIds: map[string]string{
-			"2": "Brand"},
-		returnedError: nil,
-	}
-	nodeHasInvalidConcordance := testStruct{
-		testName: "nodeHasInvalidConcordance",
-		updatedSourceIds: map[string]string{
-			"3": "Brand"},
-		returnedError: errors.New("This source id: 3 the only concordance to a non-matching node with prefUuid: 4"),
-	}
-	nodeIsPrefUUIDForExistingConcordance := testStruct{
-		testName: "nodeIsPrefUuidForExistingConcordance",
-		updatedSourceIds: map[string]string{
-			"1": "Brand"},
-		returnedError: errors.New("Cannot currently process this record as it will break an existing concordance with prefUuid: 1"),
-	}
-	nodeHasConcordanceToItselfPrefNodeNeedsToBeDeleted := testStruct{
-		testName: "nodeHasConcordanceToItselfPrefNodeNeedsToBeDeleted",
-		updatedSourceIds: map[string]string{
-			"6": "Brand"},
-		returnResult:  true,
-		returnedError: nil,
-	}
-
-	scenarios := []testStruct{
-		nodeHasNoConconcordance,
-		nodeHasExistingConcordanceWhichWouldCauseDataIssues,
-		nodeHasExistingConcordanceWhichNeedsToBeReWritten,
-		nodeHasInvalidConcordance,
-		nodeIsPrefUUIDForExistingConcordance,
-		nodeHasConcordanceToItselfPrefNodeNeedsToBeDeleted,
-	}
-
-	for _, scenario := range scenarios {
-		returnedQueryList, err := conceptsDriver.handleTransferConcordance(scenario.updatedSourceIds, &updatedConcept, "1234", AggregatedConcept{}, "")
-		assert.Equal(t, scenario.returnedError, err, "Scenario "+scenario.testName+" returned unexpected error")
-		if scenario.returnResult == true {
-			assert.NotEqual(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
-			break
-		}
-		assert.Equal(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
-	}
-
-	defer deleteSourceNodes(t, "1", "2", "3", "5", "6")
-	defer deleteConcordedNodes(t, "1", "4", "6")
-}
-
-func TestTransferCanonicalMultipleConcordance(t *testing.T) {
-	statement := `
-	MERGE (editorialCanonical:Thing{prefUUID:"1"}) 
-	MERGE (editorial:Thing{uuid:"1"}) 
-	SET editorial.authority="Smartlogic"
-	
-	MERGE (mlCanonical:Thing{prefUUID:"2"}) 
-	MERGE (ml:Thing{uuid:"2"}) 
-	SET ml.authority="ManagedLocation"
-
-	MERGE (geonames:Thing{uuid:"3"})
-	SET geonames.authority="Geonames"
-
-	MERGE (factset:Thing{uuid:"4"})
-	SET factset.authority="FACTSET"
-
-	MERGE (tme:Thing{uuid:"5"})
-	SET tme.authority="TME"
-	
-	MERGE (editorial)-[:EQUIVALENT_TO]->(editorialCanonical)<-[:EQUIVALENT_TO]-(factset)
-	MERGE (ml)-[:EQUIVALENT_TO]->(mlCanonical)<-[:EQUIVALENT_TO]-(tme)`
-	db.CypherBatch([]*neoism.CypherQuery{{Statement: statement}})
-	var emptyQuery []*neoism.CypherQuery
-	var updatedConcept ConceptChanges
-
-	type testStruct struct {
-		testName          string
-		updatedSourceIds  map[string]string
-		returnResult      bool
-		returnedError     error
-		targetConcordance AggregatedConcept
-	}
-	mergeManagedLocationCanonicalWithTwoSources := testStruct{
-		testName: "mergeManagedLocationCanonicalWithTwoSources",
-		updatedSourceIds: map[string]string{
-			"2": "Brand"},
-		returnedError: nil,
-		returnResult:  true,
-		targetConcordance: AggregatedConcept{
-			PrefUUID: "1",
-			SourceRepresentations: []Concept{
-				Concept{UUID: "1", Authority: "Smartlogic"},
-				Concept{UUID: "4", Authority: "FACTSET"},
-				Concept{UUID: "2", Authority: "ManagedLocation"},
-			},
-		},
-	}
-	mergeManagedLocationCanonicalWithTwoSourcesAndGeonames := testStruct{
-		testName: "mergeManagedLocationCanonicalWithTwoSourcesAndGeonames",
-		updatedSourceIds: map[string]string{
-			"3": "Brand",
-			"2": "Brand"},
-		returnedError: nil,
-		returnResult:  true,
-		targetConcordance: AggregatedConcept{
-			PrefUUID: "1",
-			SourceRepresentations: []Concept{
-				Concept{UUID: "1", Authority: "Smartlogic"},
-				Concept{UUID: "4", Authority: "FACTSET"},
-				Concept{UUID: "2", Authority: "ManagedLocation"},
-				Concept{UUID: "5", Authority: "TME"},
-			},
-		},
-	}
-	mergeJustASourceConcordance := testStruct{
-		testName: "mergeJustASourceConcordance",
-		updatedSourceIds: map[string]string{
-			"4": "Brand"},
-		returnedError: nil,
-	}
-
-	scenarios := []testStruct{
-		mergeManagedLocationCanonicalWithTwoSources,
-		mergeManagedLocationCanonicalWithTwoSourcesAndGeonames,
-		mergeJustASourceConcordance,
-	}
-
-	for _, scenario := range scenarios {
-		returnedQueryList, err := conceptsDriver.handleTransferConcordance(scenario.updatedSourceIds, &updatedConcept, "1234", scenario.targetConcordance, "")
-		assert.Equal(t, scenario.returnedError, err, "Scenario "+scenario.testName+" returned unexpected error")
-		if scenario.returnResult == true {
-			assert.NotEqual(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
-			continue
-		}
-		assert.Equal(t, emptyQuery, returnedQueryList, "Scenario "+scenario.testName+" results do not match")
-	}
-
-	defer deleteSourceNodes(t, "1", "2", "3", "5")
-	defer deleteConcordedNodes(t, "1", "2")
-}
-
 func TestObjectFieldValidationCorrectlyWorks(t *testing.T) {
 	defer cleanDB(t)
 
@@ -2044,18 +2335,18 @@ func TestWriteLocation(t *testing.T) {
 	defer cleanDB(t)
 
 	location := getLocation()
-	_, err := conceptsDriver.Write(location, "test_tid")
+	_, err := conceptsDriver.Write(context.Background(), location, "test_tid")
 	assert.NoError(t, err, "Failed to write concept")
 	readConceptAndCompare(t, location, "TestWriteLocation")
 
 	locationISO31661 := getLocationWithISO31661()
-	_, err = conceptsDriver.Write(locationISO31661, "test_tid")
+	_, err = conceptsDriver.Write(context.Background(), locationISO31661, "test_tid")
 	assert.NoError(t, err, "Failed to write concept")
 	readConceptAndCompare(t, locationISO31661, "TestWriteLocationISO31661")
 }
 
 func readConceptAndCompare(t *testing.T, payload AggregatedConcept, testName string) {
-	actualIf, found, err := conceptsDriver.Read(payload.PrefUUID, "")
+	actualIf, found, err := conceptsDriver.Read(context.Background(), payload.PrefUUID, "")
 	actual := actualIf.(AggregatedConcept)
 
 	actual = cleanHash(cleanConcept(actual))
@@ -2067,206 +2358,11 @@ func readConceptAndCompare(t *testing.T, payload AggregatedConcept, testName str
 	assert.True(t, found, fmt.Sprintf("Test %s failed: Concept has not been found", testName))
 }
 
-func newURL() string {
-	url := os.Getenv("NEO4J_TEST_URL")
-	if url == "" {
-		url = "http://localhost:7474/db/data"
-	}
-	return url
-}
-
-func cleanDB(t *testing.T) {
-	cleanSourceNodes(t,
-		parentUUID,
-		anotherBasicConceptUUID,
-		basicConceptUUID,
-		sourceID1,
-		sourceID2,
-		sourceID3,
-		unknownThingUUID,
-		anotherUnknownThingUUID,
-		yetAnotherBasicConceptUUID,
-		membershipRole.RoleUUID,
-		personUUID,
-		organisationUUID,
-		membershipUUID,
-		anotherMembershipRole.RoleUUID,
-		anotherOrganisationUUID,
-		anotherPersonUUID,
-		simpleSmartlogicTopicUUID,
-		boardRoleUUID,
-		financialInstrumentSameIssuerUUID,
-		financialInstrumentUUID,
-		financialOrgUUID,
-		anotherFinancialOrgUUID,
-		parentOrgUUID,
-		supersededByUUID,
-		testOrgUUID,
-		locationUUID,
-		anotherLocationUUID,
-		brandUUID,
-		anotherBrandUUID,
-		yetAnotherBrandUUID,
-		topicUUID,
-		anotherTopicUUID,
-		conceptHasFocusUUID,
-		anotherConceptHasFocusUUID,
-	)
-	deleteSourceNodes(t,
-		parentUUID,
-		anotherBasicConceptUUID,
-		basicConceptUUID,
-		sourceID1,
-		sourceID2,
-		sourceID3,
-		unknownThingUUID,
-		anotherUnknownThingUUID,
-		yetAnotherBasicConceptUUID,
-		membershipRole.RoleUUID,
-		personUUID,
-		organisationUUID,
-		membershipUUID,
-		anotherMembershipRole.RoleUUID,
-		anotherOrganisationUUID,
-		anotherPersonUUID,
-		simpleSmartlogicTopicUUID,
-		boardRoleUUID,
-		financialInstrumentSameIssuerUUID,
-		financialInstrumentUUID,
-		financialOrgUUID,
-		anotherFinancialOrgUUID,
-		parentOrgUUID,
-		supersededByUUID,
-		testOrgUUID,
-		locationUUID,
-		anotherLocationUUID,
-		brandUUID,
-		anotherBrandUUID,
-		yetAnotherBrandUUID,
-		topicUUID,
-		anotherTopicUUID,
-		conceptHasFocusUUID,
-		anotherConceptHasFocusUUID,
-	)
-	deleteConcordedNodes(t,
-		parentUUID,
-		basicConceptUUID,
-		anotherBasicConceptUUID,
-		sourceID1,
-		sourceID2,
-		sourceID3,
-		unknownThingUUID,
-		anotherUnknownThingUUID,
-		yetAnotherBasicConceptUUID,
-		membershipRole.RoleUUID,
-		personUUID,
-		organisationUUID,
-		membershipUUID,
-		anotherMembershipRole.RoleUUID,
-		anotherOrganisationUUID,
-		anotherPersonUUID,
-		simpleSmartlogicTopicUUID,
-		boardRoleUUID,
-		financialInstrumentSameIssuerUUID,
-		financialInstrumentUUID,
-		financialOrgUUID,
-		anotherFinancialOrgUUID,
-		parentOrgUUID,
-		supersededByUUID,
-		testOrgUUID,
-		locationUUID,
-		anotherLocationUUID,
-		brandUUID,
-		anotherBrandUUID,
-		yetAnotherBrandUUID,
-		topicUUID,
-		anotherTopicUUID,
-		conceptHasFocusUUID,
-		anotherConceptHasFocusUUID,
-	)
-}
-
-func deleteSourceNodes(t *testing.T, uuids ...string) {
-	qs := make([]*neoism.CypherQuery, len(uuids))
-	for i, uuid := range uuids {
-		qs[i] = &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`
-			MATCH (a:Thing {uuid: "%s"})
-			OPTIONAL MATCH (a)-[rel:IDENTIFIES]-(i)
-			DETACH DELETE rel, i, a`, uuid)}
-	}
-	err := db.CypherBatch(qs)
-	assert.NoError(t, err, "Error executing clean up cypher")
-}
-
-func cleanSourceNodes(t *testing.T, uuids ...string) {
-	qs := make([]*neoism.CypherQuery, len(uuids))
-	for i, uuid := range uuids {
-		qs[i] = &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`
-			MATCH (a:Thing {uuid: "%s"})
-			OPTIONAL MATCH (a)-[rel:IDENTIFIES]-(i)
-			OPTIONAL MATCH (a)-[hp:HAS_PARENT]-(p)
-			DELETE rel, hp, i`, uuid)}
-	}
-	err := db.CypherBatch(qs)
-	assert.NoError(t, err, "Error executing clean up cypher")
-}
-
-func deleteConcordedNodes(t *testing.T, uuids ...string) {
-	qs := make([]*neoism.CypherQuery, len(uuids))
-	for i, uuid := range uuids {
-		qs[i] = &neoism.CypherQuery{
-			Statement: fmt.Sprintf(`
-			MATCH (a:Thing {prefUUID: "%s"})
-			OPTIONAL MATCH (a)-[rel]-(i)
-			DELETE rel, i, a`, uuid)}
-	}
-	err := db.CypherBatch(qs)
-	assert.NoError(t, err, "Error executing clean up cypher")
-}
-
-func getIdentifierValue(t *testing.T, uuidPropertyName string, uuid string, label string) string {
-	var results []struct {
-		Value string `json:"i.value"`
-	}
-
-	query := &neoism.CypherQuery{
-		Statement: fmt.Sprintf(`
-			match (c:Concept {%s :{uuid}})-[r:IDENTIFIES]-(i:%s) return i.value
-		`, uuidPropertyName, label),
-		Parameters: map[string]interface{}{
-			"uuid": uuid,
-		},
-		Result: &results,
-	}
-	err := db.CypherBatch([]*neoism.CypherQuery{query})
-	assert.NoError(t, err, fmt.Sprintf("Error while retrieving %s", label))
-
-	if len(results) > 0 {
-		return results[0].Value
-	}
-	return ""
-}
-
 func verifyAggregateHashIsCorrect(t *testing.T, concept AggregatedConcept, testName string) {
-	var results []struct {
-		Hash string `json:"a.aggregateHash"`
-	}
-
-	query := &neoism.CypherQuery{
-		Statement: `
-			MATCH (a:Thing {prefUUID: {uuid}})
-			RETURN a.aggregateHash`,
-		Parameters: map[string]interface{}{
-			"uuid": concept.PrefUUID,
-		},
-		Result: &results,
-	}
-	err := db.CypherBatch([]*neoism.CypherQuery{query})
+	stored, found, err := conceptsDriver.Read(context.Background(), concept.PrefUUID, "")
 	assert.NoError(t, err, fmt.Sprintf("Error while retrieving concept hash"))
+	assert.True(t, found, fmt.Sprintf("Test %s failed: Concept has not been found", testName))
 
-	conceptHash, _ := hashstructure.Hash(cleanSourceProperties(concept), nil)
-	hashAsString := strconv.FormatUint(conceptHash, 10)
-	assert.Equal(t, hashAsString, results[0].Hash, fmt.Sprintf("Test %s failed: Concept hash %s and stored record %s are not equal!", testName, hashAsString, results[0].Hash))
+	hashAsString, _ := hashConcept(cleanSourceProperties(concept), currentHashVersion)
+	assert.Equal(t, hashAsString, stored.(AggregatedConcept).AggregatedHash, fmt.Sprintf("Test %s failed: Concept hash %s and stored record %s are not equal!", testName, hashAsString, stored.(AggregatedConcept).AggregatedHash))
 }
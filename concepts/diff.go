@@ -0,0 +1,437 @@
+package concepts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/jmcvetta/neoism"
+)
+
+//Relationships written from a source node, which are removed from it once they are no longer in its source representation
+var sourceRelationshipTypes = []string{
+	"EQUIVALENT_TO",
+	"HAS_PARENT",
+	"IS_RELATED_TO",
+	"SUPERSEDED_BY",
+	"HAS_BROADER",
+	"IMPLIED_BY",
+	"HAS_FOCUS",
+	"HAS_ORGANISATION",
+	"HAS_MEMBER",
+	"HAS_ROLE",
+	"ISSUED_BY",
+	"SUB_ORGANISATION_OF",
+	"COUNTRY_OF_OPERATIONS",
+	"COUNTRY_OF_INCORPORATION",
+	"COUNTRY_OF_RISK",
+}
+
+//Relationships only ever made between concepts, so the source node they are written from has to be a concept already
+var conceptRelationshipTypes = []string{
+	"IS_RELATED_TO",
+	"HAS_BROADER",
+	"SUPERSEDED_BY",
+	"IMPLIED_BY",
+	"HAS_FOCUS",
+}
+
+//A node as it should be written. Canonical nodes are keyed by prefUUID and source nodes by uuid.
+type nodeState struct {
+	key           string
+	id            string
+	labels        []string
+	props         map[string]interface{}
+	relationships []nodeRelationship
+	identifiers   []nodeIdentifier
+}
+
+//A node as it is stored, with only the relationships and identifiers that are written with it
+type storedNode struct {
+	UUID          string                 `json:"uuid"`
+	PrefUUID      string                 `json:"prefUUID"`
+	Labels        []string               `json:"labels"`
+	Properties    map[string]interface{} `json:"properties"`
+	Relationships []nodeRelationship     `json:"relationships"`
+	Identifiers   []nodeIdentifier       `json:"identifiers"`
+}
+
+//A relationship from a node to the thing with the given uuid, or to the canonical node with the given prefUUID
+type nodeRelationship struct {
+	Type       string                 `json:"type"`
+	UUID       string                 `json:"uuid"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func (r nodeRelationship) id() string {
+	return r.Type + ":" + r.UUID
+}
+
+//An identifier node identifying a node, by its most specific label
+type nodeIdentifier struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+func (i nodeIdentifier) id() string {
+	return i.Label + ":" + i.Value
+}
+
+//Read the canonical node and the source nodes as they are stored, to be compared with what is about to be written
+func (s *neo4jConceptStore) readStoredNodes(ctx context.Context, prefUUID string, sourceUUIDs []string, transID string) (*storedNode, map[string]*storedNode, error) {
+	var canonicalResults []storedNode
+	var sourceResults []storedNode
+	queries := []*neoism.CypherQuery{
+		{
+			Statement: `
+				MATCH (t:Thing {prefUUID:$prefUUID})
+				RETURN t.prefUUID AS prefUUID, labels(t) AS labels, properties(t) AS properties`,
+			Parameters: map[string]interface{}{
+				"prefUUID": prefUUID,
+			},
+			Result: &canonicalResults,
+		},
+		{
+			Statement: `
+				MATCH (t:Thing)
+				WHERE t.uuid IN $uuids
+				RETURN t.uuid AS uuid, labels(t) AS labels, properties(t) AS properties,
+					[(t)-[r]->(o) WHERE type(r) IN $types | {type: type(r), uuid: coalesce(o.uuid, o.prefUUID), properties: properties(r)}] AS relationships,
+					[(t)<-[:IDENTIFIES]-(i) | {label: coalesce(head([l IN labels(i) WHERE l <> 'Identifier']), ''), value: i.value}] AS identifiers`,
+			Parameters: map[string]interface{}{
+				"uuids": sourceUUIDs,
+				"types": sourceRelationshipTypes,
+			},
+			Result: &sourceResults,
+		},
+	}
+	if err := s.conn.CypherBatchContext(ctx, queries); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(prefUUID).Error("Error reading stored nodes of concept")
+		return nil, nil, err
+	}
+
+	var canonical *storedNode
+	if len(canonicalResults) > 0 {
+		canonical = &canonicalResults[0]
+	}
+	sources := map[string]*storedNode{}
+	for i := range sourceResults {
+		sources[sourceResults[i].UUID] = &sourceResults[i]
+	}
+	return canonical, sources, nil
+}
+
+//The queries of a write. Each node is written by its own query, while the relationships and identifiers added to the
+//nodes are grouped by type, so that each type is added by a single UNWIND statement however many nodes and targets it has.
+type writePlan struct {
+	nodeQueries   []*neoism.CypherQuery
+	relationships map[string][]map[string]interface{}
+	identifiers   map[string][]map[string]interface{}
+}
+
+func newWritePlan() *writePlan {
+	return &writePlan{
+		relationships: map[string][]map[string]interface{}{},
+		identifiers:   map[string][]map[string]interface{}{},
+	}
+}
+
+//Add the node to the plan. A node that isn't stored is written in full, otherwise only the labels, properties,
+//relationships and identifiers that differ from those stored are changed.
+func (p *writePlan) addNode(node nodeState, stored *storedNode) *writePlan {
+	if stored == nil {
+		p.nodeQueries = append(p.nodeQueries, &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MERGE (n:Thing {%s: $id})
+											set n=$allprops
+											set n :%s`, node.key, strings.Join(node.labels, ":")),
+			Parameters: map[string]interface{}{
+				"id":       node.id,
+				"allprops": node.props,
+			},
+		})
+		for _, rel := range node.relationships {
+			p.addRelationship(node.id, rel)
+		}
+		for _, identifier := range node.identifiers {
+			p.addIdentifier(node.id, identifier)
+		}
+		return p
+	}
+
+	diff := diffNode(node, stored)
+	if len(diff.relationshipsToRemove) > 0 {
+		p.nodeQueries = append(p.nodeQueries, &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MATCH (t:Thing {%s:$id})-[rel]->(o)
+				WHERE type(rel) + ':' + coalesce(o.uuid, o.prefUUID) IN $relationships
+				DELETE rel`, node.key),
+			Parameters: map[string]interface{}{
+				"id":            node.id,
+				"relationships": diff.relationshipsToRemove,
+			},
+		})
+	}
+
+	if len(diff.identifiersToRemove) > 0 {
+		p.nodeQueries = append(p.nodeQueries, &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MATCH (t:Thing {%s:$id})<-[rel:IDENTIFIES]-(i)
+				WHERE coalesce(head([l IN labels(i) WHERE l <> 'Identifier']), '') + ':' + i.value IN $identifiers
+				DELETE rel, i`, node.key),
+			Parameters: map[string]interface{}{
+				"id":          node.id,
+				"identifiers": diff.identifiersToRemove,
+			},
+		})
+	}
+
+	if diff.changed() {
+		var removeItems []string
+		if len(diff.labelsToRemove) > 0 {
+			removeItems = append(removeItems, "t:"+strings.Join(diff.labelsToRemove, ":"))
+		}
+		for _, key := range diff.propsToRemove {
+			removeItems = append(removeItems, "t.`"+strings.Replace(key, "`", "``", -1)+"`")
+		}
+		statement := fmt.Sprintf("MERGE (t:Thing {%s:$id})", node.key)
+		if len(removeItems) > 0 {
+			statement += "\nREMOVE " + strings.Join(removeItems, ", ")
+		}
+		statement += "\nSET t += $props"
+		if len(diff.labelsToAdd) > 0 {
+			statement += ", t:" + strings.Join(diff.labelsToAdd, ":")
+		}
+		p.nodeQueries = append(p.nodeQueries, &neoism.CypherQuery{
+			Statement: statement,
+			Parameters: map[string]interface{}{
+				"id":    node.id,
+				"props": diff.propsToSet,
+			},
+		})
+	}
+
+	for _, rel := range diff.relationshipsToAdd {
+		p.addRelationship(node.id, rel)
+	}
+	for _, identifier := range diff.identifiersToAdd {
+		p.addIdentifier(node.id, identifier)
+	}
+	return p
+}
+
+//The changes that make a stored node into the node as it should be written
+type nodeDiff struct {
+	relationshipsToAdd    []nodeRelationship
+	relationshipsToRemove []string
+	identifiersToAdd      []nodeIdentifier
+	identifiersToRemove   []string
+	labelsToAdd           []string
+	labelsToRemove        []string
+	propsToSet            map[string]interface{}
+	propsToRemove         []string
+}
+
+//Compare the node with the node as it is stored. The modification time of the node is only set when something else
+//on it has changed.
+func diffNode(node nodeState, stored *storedNode) nodeDiff {
+	diff := nodeDiff{propsToSet: map[string]interface{}{}}
+	storedRelationships := map[string]nodeRelationship{}
+	for _, rel := range stored.Relationships {
+		storedRelationships[rel.id()] = rel
+	}
+	wanted := map[string]bool{}
+	for _, rel := range node.relationships {
+		wanted[rel.id()] = true
+		if storedRel, ok := storedRelationships[rel.id()]; !ok || !sameProperties(rel.Properties, storedRel.Properties) {
+			diff.relationshipsToAdd = append(diff.relationshipsToAdd, rel)
+		}
+	}
+	for _, rel := range stored.Relationships {
+		// a relationship whose properties have changed is removed and written again
+		if !wanted[rel.id()] || containsRelationship(diff.relationshipsToAdd, rel.id()) {
+			diff.relationshipsToRemove = append(diff.relationshipsToRemove, rel.id())
+		}
+	}
+
+	storedIdentifiers := map[string]bool{}
+	for _, identifier := range stored.Identifiers {
+		storedIdentifiers[identifier.id()] = true
+	}
+	wanted = map[string]bool{}
+	for _, identifier := range node.identifiers {
+		wanted[identifier.id()] = true
+		if !storedIdentifiers[identifier.id()] {
+			diff.identifiersToAdd = append(diff.identifiersToAdd, identifier)
+		}
+	}
+	for _, identifier := range stored.Identifiers {
+		if !wanted[identifier.id()] {
+			diff.identifiersToRemove = append(diff.identifiersToRemove, identifier.id())
+		}
+	}
+
+	for _, label := range node.labels {
+		if !stringInArr(label, stored.Labels) {
+			diff.labelsToAdd = append(diff.labelsToAdd, label)
+		}
+	}
+	for _, label := range stored.Labels {
+		if stringInArr(label, conceptLabels[:]) && !stringInArr(label, node.labels) {
+			diff.labelsToRemove = append(diff.labelsToRemove, label)
+		}
+	}
+
+	for key, value := range node.props {
+		if key != "lastModifiedEpoch" && !sameValue(value, stored.Properties[key]) {
+			diff.propsToSet[key] = value
+		}
+	}
+	for key := range stored.Properties {
+		if _, ok := node.props[key]; !ok {
+			diff.propsToRemove = append(diff.propsToRemove, key)
+		}
+	}
+	sort.Strings(diff.propsToRemove)
+
+	if diff.changed() {
+		if lastModified, ok := node.props["lastModifiedEpoch"]; ok {
+			diff.propsToSet["lastModifiedEpoch"] = lastModified
+		}
+	}
+	return diff
+}
+
+func (d nodeDiff) changed() bool {
+	return len(d.relationshipsToRemove) > 0 || len(d.relationshipsToAdd) > 0 || len(d.identifiersToRemove) > 0 ||
+		len(d.identifiersToAdd) > 0 || len(d.labelsToAdd) > 0 || len(d.labelsToRemove) > 0 || len(d.propsToSet) > 0 || len(d.propsToRemove) > 0
+}
+
+func (p *writePlan) addRelationship(uuid string, rel nodeRelationship) {
+	row := map[string]interface{}{
+		"uuid": uuid,
+		"id":   rel.UUID,
+	}
+	for key, value := range rel.Properties {
+		row[key] = value
+	}
+	p.relationships[rel.Type] = append(p.relationships[rel.Type], row)
+}
+
+func (p *writePlan) addIdentifier(uuid string, identifier nodeIdentifier) {
+	p.identifiers[identifier.Label] = append(p.identifiers[identifier.Label], map[string]interface{}{
+		"uuid":  uuid,
+		"value": identifier.Value,
+	})
+}
+
+//The queries of the plan. Nodes are written first, so that relationships are only added once the nodes have their labels
+//and the canonical node exists.
+func (p *writePlan) queries() []*neoism.CypherQuery {
+	queryBatch := append([]*neoism.CypherQuery{}, p.nodeQueries...)
+	for _, relationshipType := range sourceRelationshipTypes {
+		if rows := p.relationships[relationshipType]; len(rows) > 0 {
+			queryBatch = append(queryBatch, &neoism.CypherQuery{
+				Statement:  relationshipStatement(relationshipType),
+				Parameters: map[string]interface{}{"rows": rows},
+			})
+		}
+	}
+
+	var labels []string
+	for label := range p.identifiers {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		queryBatch = append(queryBatch, &neoism.CypherQuery{
+			Statement:  identifierStatement(label),
+			Parameters: map[string]interface{}{"rows": p.identifiers[label]},
+		})
+	}
+	return queryBatch
+}
+
+//Statement adding relationships of the given type, from the source node with each row's uuid to the thing with its id
+func relationshipStatement(relationshipType string) string {
+	switch {
+	case relationshipType == "EQUIVALENT_TO":
+		return `UNWIND $rows AS row
+				MATCH (t:Thing {uuid: row.uuid}), (c:Thing {prefUUID: row.id})
+				MERGE (t)-[:EQUIVALENT_TO]->(c)`
+	case relationshipType == "HAS_ROLE":
+		return `UNWIND $rows AS row
+				MERGE (node:Thing {uuid: row.uuid})
+				MERGE (role:Thing {uuid: row.id})
+					ON CREATE SET
+						role.uuid = row.id
+				MERGE (node)-[rel:HAS_ROLE]->(role)
+					ON CREATE SET
+						rel.inceptionDate = row.inceptionDate,
+						rel.inceptionDateEpoch = row.inceptionDateEpoch,
+						rel.terminationDate = row.terminationDate,
+						rel.terminationDateEpoch = row.terminationDateEpoch`
+	case relationshipType == "ISSUED_BY":
+		return `UNWIND $rows AS row
+				MERGE (fi:Thing {uuid: row.uuid})
+				MERGE (org:Thing {uuid: row.id})
+				MERGE (fi)-[:ISSUED_BY]->(org)`
+	case stringInArr(relationshipType, conceptRelationshipTypes):
+		return fmt.Sprintf(`UNWIND $rows AS row
+				MATCH (o:Concept {uuid: row.uuid})
+				MERGE (p:Thing {uuid: row.id})
+				MERGE (o)-[:%s]->(p)
+				MERGE (x:Identifier:UPPIdentifier {value: row.id})
+				MERGE (x)-[:IDENTIFIES]->(p)`, relationshipType)
+	default:
+		return fmt.Sprintf(`UNWIND $rows AS row
+				MERGE (o:Thing {uuid: row.uuid})
+				MERGE (upp:Identifier:UPPIdentifier {value: row.id})
+				MERGE (p:Thing {uuid: row.id})
+				MERGE (upp)-[:IDENTIFIES]->(p)
+				MERGE (o)-[:%s]->(p)`, relationshipType)
+	}
+}
+
+//Statement adding identifiers with the given label, with each row's value, to the node with its uuid
+func identifierStatement(identifierLabel string) string {
+	return fmt.Sprintf(`UNWIND $rows AS row
+				MERGE (t:Thing {uuid: row.uuid})
+				MERGE (i:Identifier:%s {value: row.value})
+				MERGE (t)<-[:IDENTIFIES]-(i)`, identifierLabel)
+}
+
+func containsRelationship(rels []nodeRelationship, id string) bool {
+	for _, rel := range rels {
+		if rel.id() == id {
+			return true
+		}
+	}
+	return false
+}
+
+//Whether two property values are the same once stored, whichever Go types they are held in
+func sameValue(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(storedValue(a), storedValue(b))
+}
+
+func sameProperties(a map[string]interface{}, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return sameValue(a, b)
+}
+
+//The value as it is read back from Neo4j, where numbers are floats and lists are untyped
+func storedValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var stored interface{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return value
+	}
+	return stored
+}
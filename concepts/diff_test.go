@@ -0,0 +1,182 @@
+package concepts
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSourceNode() nodeState {
+	node := conceptNodeState(Concept{
+		UUID:           "source-uuid",
+		Type:           "Organisation",
+		PrefLabel:      "The Organisation",
+		Authority:      "FACTSET",
+		AuthorityValue: "FACTSET-1",
+		RelatedUUIDs:   []string{"related-1", "related-2", "related-1"},
+		MembershipRoles: []MembershipRole{
+			{RoleUUID: "role-uuid", InceptionDate: "2000-01-01"},
+		},
+	}, "", "source-uuid")
+	node.relationships = append(node.relationships, nodeRelationship{Type: "EQUIVALENT_TO", UUID: "pref-uuid"})
+	return node
+}
+
+//The node as Neo4j would return it once written
+func storedNodeOf(node nodeState) *storedNode {
+	stored := &storedNode{
+		UUID:       node.id,
+		Labels:     append([]string{}, node.labels...),
+		Properties: storedValue(node.props).(map[string]interface{}),
+	}
+	for _, rel := range node.relationships {
+		props, _ := storedValue(rel.Properties).(map[string]interface{})
+		stored.Relationships = append(stored.Relationships, nodeRelationship{Type: rel.Type, UUID: rel.UUID, Properties: props})
+	}
+	stored.Identifiers = append(stored.Identifiers, node.identifiers...)
+	return stored
+}
+
+func TestConceptNodeState(t *testing.T) {
+	node := testSourceNode()
+	assert.Equal(t, []string{"Organisation", "Concept", "Thing"}, node.labels)
+	assert.Equal(t, []nodeIdentifier{{"FactsetIdentifier", "FACTSET-1"}, {"UPPIdentifier", "source-uuid"}}, node.identifiers)
+
+	var rels []string
+	for _, rel := range node.relationships {
+		rels = append(rels, rel.id())
+	}
+	assert.Equal(t, []string{"HAS_ROLE:role-uuid", "IS_RELATED_TO:related-1", "IS_RELATED_TO:related-2", "EQUIVALENT_TO:pref-uuid"}, rels, "Duplicate relationships should only be written once")
+}
+
+func TestNodeWriteQueriesForNewNode(t *testing.T) {
+	node := testSourceNode()
+	queries := newWritePlan().addNode(node, nil).queries()
+	assert.Len(t, queries, 6, "The node should be written with one statement for each type of relationship and identifier")
+	assert.Contains(t, queries[0].Statement, "set n=$allprops")
+	assert.Contains(t, queries[2].Statement, "MERGE (o)-[:IS_RELATED_TO]->(p)")
+	assert.Len(t, queries[2].Parameters["rows"], 2)
+}
+
+func TestNodeWriteQueriesForUnchangedNode(t *testing.T) {
+	node := testSourceNode()
+	stored := storedNodeOf(node)
+	stored.Properties["lastModifiedEpoch"] = float64(1)
+
+	assert.Empty(t, newWritePlan().addNode(node, stored).queries(), "Nothing should be written for a node that hasn't changed")
+}
+
+func TestNodeWriteQueriesOnlyWritesDifferences(t *testing.T) {
+	stored := storedNodeOf(testSourceNode())
+	stored.Properties["oldProperty"] = "old"
+	stored.Labels = append(stored.Labels, "PublicCompany")
+
+	node := testSourceNode()
+	node.props["prefLabel"] = "The Renamed Organisation"
+	node.labels = append(node.labels, "Company")
+	node.relationships[0].Properties["terminationDate"] = "2001-01-01"
+	node.relationships = append(node.relationships[:2], nodeRelationship{Type: "HAS_FOCUS", UUID: "focus-uuid"}, node.relationships[3])
+
+	queries := newWritePlan().addNode(node, stored).queries()
+	assert.Len(t, queries, 4)
+
+	assert.Contains(t, queries[0].Statement, "DELETE rel")
+	assert.ElementsMatch(t, []string{"HAS_ROLE:role-uuid", "IS_RELATED_TO:related-2"}, queries[0].Parameters["relationships"])
+
+	update := queries[1].Statement
+	assert.True(t, strings.HasPrefix(update, "MERGE (t:Thing {uuid:$id})"))
+	assert.Contains(t, update, "REMOVE t:PublicCompany, t.`oldProperty`")
+	assert.Contains(t, update, "SET t += $props, t:Company")
+	props := queries[1].Parameters["props"].(map[string]interface{})
+	assert.Len(t, props, 2, "Only the changed property and the modification time should be set")
+	assert.Equal(t, "The Renamed Organisation", props["prefLabel"])
+	assert.Contains(t, props, "lastModifiedEpoch")
+
+	assert.Contains(t, queries[2].Statement, "MERGE (o)-[:HAS_FOCUS]->(p)")
+	assert.Contains(t, queries[3].Statement, "MERGE (node)-[rel:HAS_ROLE]->(role)")
+	assert.Equal(t, []map[string]interface{}{
+		{"uuid": "source-uuid", "id": "role-uuid", "inceptionDate": "2000-01-01", "terminationDate": "2001-01-01"},
+	}, queries[3].Parameters["rows"])
+}
+
+func TestNodeWriteQueriesRemovesIdentifiers(t *testing.T) {
+	stored := storedNodeOf(testSourceNode())
+	node := testSourceNode()
+	node.identifiers = node.identifiers[1:]
+
+	queries := newWritePlan().addNode(node, stored).queries()
+	assert.Len(t, queries, 2)
+	assert.Contains(t, queries[0].Statement, "DELETE rel, i")
+	assert.Equal(t, []string{"FactsetIdentifier:FACTSET-1"}, queries[0].Parameters["identifiers"])
+	assert.NotContains(t, queries[1].Statement, "REMOVE")
+}
+
+//A concept like a large organisation, with two sources each related to the given number of concepts
+func benchmarkConcept(relationships int) AggregatedConcept {
+	concept := AggregatedConcept{PrefUUID: "bench-pref-uuid", PrefLabel: "Benchmark Organisation", Type: "Organisation"}
+	for i, authority := range []string{"FACTSET", "TME"} {
+		source := Concept{
+			UUID:           fmt.Sprintf("bench-source-%d", i),
+			PrefLabel:      "Benchmark Organisation",
+			Type:           "Organisation",
+			Authority:      authority,
+			AuthorityValue: fmt.Sprintf("bench-%s", authority),
+		}
+		for j := 0; j < relationships; j++ {
+			source.RelatedUUIDs = append(source.RelatedUUIDs, fmt.Sprintf("bench-related-%d", j))
+			source.HasFocusUUIDs = append(source.HasFocusUUIDs, fmt.Sprintf("bench-focus-%d", j))
+		}
+		concept.SourceRepresentations = append(concept.SourceRepresentations, source)
+	}
+	return concept
+}
+
+//The queries of the plan as they were generated before relationships and identifiers were batched, with a statement
+//for each one of them
+func unbatchedQueries(plan *writePlan) []*neoism.CypherQuery {
+	queryBatch := append([]*neoism.CypherQuery{}, plan.nodeQueries...)
+	for relationshipType, rows := range plan.relationships {
+		for _, row := range rows {
+			queryBatch = append(queryBatch, &neoism.CypherQuery{
+				Statement:  relationshipStatement(relationshipType),
+				Parameters: map[string]interface{}{"rows": []map[string]interface{}{row}},
+			})
+		}
+	}
+	for label, rows := range plan.identifiers {
+		for _, row := range rows {
+			queryBatch = append(queryBatch, &neoism.CypherQuery{
+				Statement:  identifierStatement(label),
+				Parameters: map[string]interface{}{"rows": []map[string]interface{}{row}},
+			})
+		}
+	}
+	return queryBatch
+}
+
+func TestWritePlanBatchesRelationshipsAndIdentifiers(t *testing.T) {
+	plan := conceptWritePlan(benchmarkConcept(100), nil, nil)
+	// the canonical and source nodes, EQUIVALENT_TO, IS_RELATED_TO, HAS_FOCUS and the three identifier labels
+	assert.Len(t, plan.queries(), 9)
+	assert.Len(t, unbatchedQueries(plan), 3+2+400+4)
+}
+
+func BenchmarkWritePlan(b *testing.B) {
+	concept := benchmarkConcept(200)
+	generators := map[string]func(*writePlan) []*neoism.CypherQuery{
+		"batched":   (*writePlan).queries,
+		"unbatched": unbatchedQueries,
+	}
+	for name, generate := range generators {
+		b.Run(name, func(b *testing.B) {
+			var statements int
+			for i := 0; i < b.N; i++ {
+				statements = len(generate(conceptWritePlan(concept, nil, nil)))
+			}
+			b.ReportMetric(float64(statements), "statements/op")
+		})
+	}
+}
@@ -9,3 +9,13 @@ type invalidRequestError interface {
 type noContentReturnedError interface {
 	NoContentReturnedDetails() string
 }
+
+// DependantsError if the request cannot be completed while other concepts depend on the target
+type dependantsError interface {
+	Dependants() []string
+}
+
+// PreconditionFailedError if the stored concept does not match the precondition of a conditional request
+type preconditionFailedError interface {
+	PreconditionFailedDetails() string
+}
@@ -1,18 +1,26 @@
 package concepts
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	logger "github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/transactionid-utils-go"
 	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var irregularConceptTypePaths = map[string]string{
@@ -23,15 +31,52 @@ var irregularConceptTypePaths = map[string]string{
 	"PublicCompany":    "organisations",
 }
 
+// Number of concepts read from Neo4j at a time while streaming an export
+const exportPageSize = 500
+
+// Number of events returned by the event feed when no limit is given, and the most that can be asked for
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+const (
+	bulkInvalidRequest = "invalidRequest"
+	bulkConflict       = "conflict"
+	bulkUnavailable    = "unavailable"
+)
+
 type ConceptsHandler struct {
 	ConceptsService ConceptServicer
+	//How long a read or write of a concept can take before it is given up on and a 504 is returned, or 0 for no limit
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
+//Every route is turned away with a 503 until the service is ready, if it might not be
 func (h *ConceptsHandler) RegisterHandlers(router *mux.Router) {
-	router.Handle("/{concept_type}/{uuid}", handlers.MethodHandler{
-		"GET": http.HandlerFunc(h.GetConcept),
-		"PUT": http.HandlerFunc(h.PutConcept),
-	})
+	// registered first, as the acknowledgement path would otherwise match the concept path
+	router.Handle("/__events", h.whenReady(handlers.MethodHandler{
+		"GET": http.HandlerFunc(h.GetEvents),
+	}))
+	router.Handle("/__events/ack", h.whenReady(handlers.MethodHandler{
+		"POST": http.HandlerFunc(h.AcknowledgeEvents),
+	}))
+	router.Handle("/{concept_type}/{uuid}", h.whenReady(handlers.MethodHandler{
+		"GET":    http.HandlerFunc(h.GetConcept),
+		"PUT":    http.HandlerFunc(h.PutConcept),
+		"PATCH":  http.HandlerFunc(h.PatchConcept),
+		"DELETE": http.HandlerFunc(h.DeleteConcept),
+	}))
+	router.Handle("/__export", h.whenReady(handlers.MethodHandler{
+		"GET": http.HandlerFunc(h.ExportConcepts),
+	}))
+	router.Handle("/__bulk", h.whenReady(handlers.MethodHandler{
+		"POST": http.HandlerFunc(h.BulkWriteConcepts),
+	}))
+	router.Handle("/__identifiers/{authority}/{authorityValue}", h.whenReady(handlers.MethodHandler{
+		"GET": http.HandlerFunc(h.ResolveIdentifier),
+	}))
 }
 
 func (h *ConceptsHandler) PutConcept(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +85,8 @@ func (h *ConceptsHandler) PutConcept(w http.ResponseWriter, r *http.Request) {
 	conceptType := vars["concept_type"]
 
 	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w, r, endRequestSpan := traceRequest(w, r, "PutConcept", transactionIDKey.String(transID), prefUUIDKey.String(uuid))
+	defer endRequestSpan()
 	w.Header().Add("Content-Type", "application/json")
 	w.Header().Set("X-Request-Id", transID)
 
@@ -58,13 +105,123 @@ func (h *ConceptsHandler) PutConcept(w http.ResponseWriter, r *http.Request) {
 	}
 
 	agConcept := inst.(AggregatedConcept)
+	trace.SpanFromContext(r.Context()).SetAttributes(conceptTypeKey.String(agConcept.Type))
+	if err := checkConceptTypeAgainstPath(agConcept.Type, conceptType); err != nil {
+		writeJSONError(w, "Concept type does not match path", http.StatusBadRequest)
+		return
+	}
+
+	options, err := writeOptionsFromRequest(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := requestContext(r, h.WriteTimeout)
+	defer cancel()
+	h.writeConcept(ctx, w, inst, transID, options)
+}
+
+func (h *ConceptsHandler) PatchConcept(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+	conceptType := vars["concept_type"]
+
+	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w, r, endRequestSpan := traceRequest(w, r, "PatchConcept", transactionIDKey.String(transID), prefUUIDKey.String(uuid))
+	defer endRequestSpan()
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Request-Id", transID)
+
+	// RFC 7396 only defines application/merge-patch+json, so a plain JSON body is not taken to be a merge patch
+	if contentType := r.Header.Get("Content-Type"); !isMergePatchContentType(contentType) {
+		writeJSONError(w, fmt.Sprintf("Unsupported patch content type: '%v'", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options, err := writeOptionsFromRequest(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := requestContext(r, h.WriteTimeout)
+	defer cancel()
+	obj, found, err := h.ConceptsService.Read(ctx, uuid, transID)
+	if err != nil {
+		writeReadError(w, err)
+		return
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("{\"message\":\"Concept with prefUUID %s not found in db.\"}", uuid)))
+		return
+	}
+
+	storedConcept := obj.(AggregatedConcept)
+	if err := checkConceptTypeAgainstPath(storedConcept.Type, conceptType); err != nil {
+		writeJSONError(w, "Concept type does not match path", http.StatusBadRequest)
+		return
+	}
+
+	// The patched concept is only written if it is still the one that was patched
+	if options.Precondition == nil {
+		options.Precondition = &Precondition{IfMatch: []string{storedConcept.AggregatedHash}}
+	}
+
+	storedJSON, err := json.Marshal(cleanHash(storedConcept))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	patchedJSON, err := applyMergePatch(storedJSON, patch)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inst, docUUID, err := h.ConceptsService.DecodeJSON(json.NewDecoder(bytes.NewReader(patchedJSON)))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if docUUID != uuid {
+		writeJSONError(w, fmt.Sprintf("Uuids from patched concept and request, respectively, do not match: '%v' '%v'", docUUID, uuid), http.StatusBadRequest)
+		return
+	}
+
+	agConcept := inst.(AggregatedConcept)
+	trace.SpanFromContext(r.Context()).SetAttributes(conceptTypeKey.String(agConcept.Type))
 	if err := checkConceptTypeAgainstPath(agConcept.Type, conceptType); err != nil {
 		writeJSONError(w, "Concept type does not match path", http.StatusBadRequest)
 		return
 	}
 
-	updatedIds, err := h.ConceptsService.Write(inst, transID)
+	h.writeConcept(ctx, w, inst, transID, options)
+}
+
+func (h *ConceptsHandler) writeConcept(ctx context.Context, w http.ResponseWriter, inst interface{}, transID string, options WriteOptions) {
+	var updatedIds interface{}
+	var err error
+	if options.DryRun || options.Precondition != nil {
+		updatedIds, err = h.ConceptsService.WriteWithOptions(ctx, inst, transID, options)
+	} else {
+		updatedIds, err = h.ConceptsService.Write(ctx, inst, transID)
+	}
 
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSONError(w, "Concept could not be written within the write timeout", http.StatusGatewayTimeout)
+		return
+	}
 	if err != nil {
 		switch e := err.(type) {
 		case noContentReturnedError:
@@ -76,6 +233,9 @@ func (h *ConceptsHandler) PutConcept(w http.ResponseWriter, r *http.Request) {
 		case invalidRequestError:
 			writeJSONError(w, e.InvalidRequestDetails(), http.StatusBadRequest)
 			return
+		case preconditionFailedError:
+			writeJSONError(w, e.PreconditionFailedDetails(), http.StatusPreconditionFailed)
+			return
 		default:
 			writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
 			return
@@ -89,7 +249,6 @@ func (h *ConceptsHandler) PutConcept(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(updateIDsBody)
-	return
 }
 
 func (h *ConceptsHandler) GetConcept(w http.ResponseWriter, r *http.Request) {
@@ -98,14 +257,18 @@ func (h *ConceptsHandler) GetConcept(w http.ResponseWriter, r *http.Request) {
 	conceptType := vars["concept_type"]
 
 	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w, r, endRequestSpan := traceRequest(w, r, "GetConcept", transactionIDKey.String(transID), prefUUIDKey.String(uuid))
+	defer endRequestSpan()
 
-	obj, found, err := h.ConceptsService.Read(uuid, transID)
+	ctx, cancel := requestContext(r, h.ReadTimeout)
+	defer cancel()
+	obj, found, err := h.ConceptsService.Read(ctx, uuid, transID)
 
 	w.Header().Add("Content-Type", "application/json")
 	w.Header().Set("X-Request-Id", transID)
 
 	if err != nil {
-		writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+		writeReadError(w, err)
 		return
 	}
 
@@ -116,11 +279,16 @@ func (h *ConceptsHandler) GetConcept(w http.ResponseWriter, r *http.Request) {
 	}
 
 	agConcept := obj.(AggregatedConcept)
+	trace.SpanFromContext(r.Context()).SetAttributes(conceptTypeKey.String(agConcept.Type))
 	if err := checkConceptTypeAgainstPath(agConcept.Type, conceptType); err != nil {
 		writeJSONError(w, "Concept type does not match path", http.StatusBadRequest)
 		return
 	}
 
+	if agConcept.AggregatedHash != "" {
+		w.Header().Set("ETag", fmt.Sprintf("\"%s\"", agConcept.AggregatedHash))
+	}
+
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(obj); err != nil {
 		writeJSONError(w, err.Error(), http.StatusInternalServerError)
@@ -128,6 +296,370 @@ func (h *ConceptsHandler) GetConcept(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *ConceptsHandler) DeleteConcept(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+	conceptType := vars["concept_type"]
+
+	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Request-Id", transID)
+
+	//The read of the concept to check its type is part of the delete, so both are given the write timeout
+	ctx, cancel := requestContext(r, h.WriteTimeout)
+	defer cancel()
+	obj, found, err := h.ConceptsService.Read(ctx, uuid, transID)
+	if err != nil {
+		writeUnavailableError(w, err, "Concept could not be deleted within the write timeout")
+		return
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("{\"message\":\"Concept with prefUUID %s not found in db.\"}", uuid)))
+		return
+	}
+
+	agConcept := obj.(AggregatedConcept)
+	if err := checkConceptTypeAgainstPath(agConcept.Type, conceptType); err != nil {
+		writeJSONError(w, "Concept type does not match path", http.StatusBadRequest)
+		return
+	}
+
+	updatedIds, found, err := h.ConceptsService.Delete(ctx, uuid, transID)
+	if err != nil {
+		switch e := err.(type) {
+		case dependantsError:
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":    err.Error(),
+				"dependants": e.Dependants(),
+			})
+			return
+		default:
+			writeUnavailableError(w, err, "Concept could not be deleted within the write timeout")
+			return
+		}
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("{\"message\":\"Concept with prefUUID %s not found in db.\"}", uuid)))
+		return
+	}
+
+	updateIDsBody, err := json.Marshal(updatedIds)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(updateIDsBody)
+}
+
+func (h *ConceptsHandler) ExportConcepts(w http.ResponseWriter, r *http.Request) {
+	conceptType := r.URL.Query().Get("type")
+	after := r.URL.Query().Get("after")
+
+	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w.Header().Set("X-Request-Id", transID)
+
+	if conceptType == "" {
+		w.Header().Add("Content-Type", "application/json")
+		writeJSONError(w, "A type must be provided to export concepts", http.StatusBadRequest)
+		return
+	}
+
+	concepts, next, err := h.exportPage(r, conceptType, after, transID)
+	if err != nil {
+		w.Header().Add("Content-Type", "application/json")
+		switch e := err.(type) {
+		case invalidRequestError:
+			writeJSONError(w, e.InvalidRequestDetails(), http.StatusBadRequest)
+			return
+		default:
+			writeUnavailableError(w, err, "Concepts could not be exported within the read timeout")
+			return
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		for _, concept := range concepts {
+			if err := enc.Encode(concept); err != nil {
+				logger.WithError(err).WithTransactionID(transID).Error("Export stream interrupted")
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if next == "" {
+			return
+		}
+
+		// The status has already been sent, so a failure can only be reported by ending the stream early.
+		// Clients can resume by passing the prefUUID of the last concept they received as after.
+		concepts, next, err = h.exportPage(r, conceptType, next, transID)
+		if err != nil {
+			logger.WithError(err).WithTransactionID(transID).Error("Export stream ended early")
+			return
+		}
+	}
+}
+
+//Each page of an export is given the read timeout, as the export as a whole can take as long as there are concepts to
+//stream, and is given up on if the client disconnects
+func (h *ConceptsHandler) exportPage(r *http.Request, conceptType string, after string, transID string) ([]AggregatedConcept, string, error) {
+	ctx, cancel := requestContext(r, h.ReadTimeout)
+	defer cancel()
+	return h.ConceptsService.Export(ctx, conceptType, after, exportPageSize, transID)
+}
+
+func (h *ConceptsHandler) BulkWriteConcepts(w http.ResponseWriter, r *http.Request) {
+	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w.Header().Add("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Request-Id", transID)
+
+	// Results are streamed back while the request is still being read, which HTTP/1.x servers only allow when asked to
+	if d, ok := w.(interface{ EnableFullDuplex() error }); ok {
+		d.EnableFullDuplex()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	reader := bufio.NewReader(r.Body)
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			logger.WithError(readErr).WithTransactionID(transID).Error("Bulk write request could not be read")
+			enc.Encode(BulkWriteResult{
+				Line:   lineNumber,
+				Status: http.StatusBadRequest,
+				Error:  &BulkWriteError{Type: bulkInvalidRequest, Message: readErr.Error()},
+			})
+			return
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			if err := enc.Encode(h.writeBulkLine(r.Context(), line, lineNumber, transID)); err != nil {
+				logger.WithError(err).WithTransactionID(transID).Error("Bulk write response interrupted")
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if readErr == io.EOF {
+			return
+		}
+	}
+}
+
+//Write a single line of a bulk request, which has the write timeout to itself
+func (h *ConceptsHandler) writeBulkLine(ctx context.Context, line []byte, lineNumber int, transID string) BulkWriteResult {
+	if h.WriteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.WriteTimeout)
+		defer cancel()
+	}
+	return writeBulkLine(ctx, h.ConceptsService, line, lineNumber, transID)
+}
+
+//Decode and write a single line of newline delimited JSON
+func writeBulkLine(ctx context.Context, service ConceptServicer, line []byte, lineNumber int, transID string) BulkWriteResult {
+	result := BulkWriteResult{Line: lineNumber}
+	inst, docUUID, err := service.DecodeJSON(json.NewDecoder(bytes.NewReader(line)))
+	if err != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = &BulkWriteError{Type: bulkInvalidRequest, Message: err.Error()}
+		return result
+	}
+	result.UUID = docUUID
+
+	updatedIds, err := service.Write(ctx, inst, transID)
+	if err != nil {
+		result.Status, result.Error = classifyWriteError(err)
+		return result
+	}
+
+	result.Status = http.StatusOK
+	result.Changes = updatedIds
+	return result
+}
+
+//The status a PUT would have returned for a failed write, and the error a bulk write reports for it. A write that timed
+//out is unavailable, as it can be tried again.
+func classifyWriteError(err error) (int, *BulkWriteError) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, &BulkWriteError{Type: bulkUnavailable, Message: err.Error()}
+	}
+	switch e := err.(type) {
+	case noContentReturnedError:
+		return http.StatusNoContent, nil
+	case rwapi.ConstraintOrTransactionError:
+		return http.StatusConflict, &BulkWriteError{Type: bulkConflict, Message: e.Error()}
+	case invalidRequestError:
+		return http.StatusBadRequest, &BulkWriteError{Type: bulkInvalidRequest, Message: e.InvalidRequestDetails()}
+	default:
+		return http.StatusServiceUnavailable, &BulkWriteError{Type: bulkUnavailable, Message: err.Error()}
+	}
+}
+
+func (h *ConceptsHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
+	after := r.URL.Query().Get("after")
+
+	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Request-Id", transID)
+
+	limit := defaultEventsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxEventsLimit {
+			writeJSONError(w, fmt.Sprintf("Invalid value for limit: '%v'", v), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	ctx, cancel := requestContext(r, h.ReadTimeout)
+	defer cancel()
+	events, err := h.ConceptsService.Events(ctx, after, limit, transID)
+	if err != nil {
+		switch e := err.(type) {
+		case invalidRequestError:
+			writeJSONError(w, e.InvalidRequestDetails(), http.StatusBadRequest)
+			return
+		default:
+			writeUnavailableError(w, err, "Events could not be read within the read timeout")
+			return
+		}
+	}
+
+	// the cursor to read the next events after, which stays where it is when there are no new events
+	cursor := after
+	if len(events) > 0 {
+		cursor = events[len(events)-1].Cursor
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(map[string]interface{}{"events": events, "cursor": cursor}); err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *ConceptsHandler) AcknowledgeEvents(w http.ResponseWriter, r *http.Request) {
+	cursor := r.URL.Query().Get("cursor")
+
+	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Request-Id", transID)
+
+	if cursor == "" {
+		writeJSONError(w, "A cursor must be provided to acknowledge events", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := requestContext(r, h.WriteTimeout)
+	defer cancel()
+	acknowledged, err := h.ConceptsService.AcknowledgeEvents(ctx, cursor, transID)
+	if err != nil {
+		switch e := err.(type) {
+		case invalidRequestError:
+			writeJSONError(w, e.InvalidRequestDetails(), http.StatusBadRequest)
+			return
+		default:
+			writeUnavailableError(w, err, "Events could not be acknowledged within the write timeout")
+			return
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(map[string]int{"acknowledged": acknowledged}); err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *ConceptsHandler) ResolveIdentifier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	authority := vars["authority"]
+	authorityValue := vars["authorityValue"]
+
+	transID := transactionidutils.GetTransactionIDFromRequest(r)
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Request-Id", transID)
+
+	ctx, cancel := requestContext(r, h.ReadTimeout)
+	defer cancel()
+	resolutions, found, err := h.ConceptsService.ResolveIdentifier(ctx, authority, authorityValue, transID)
+	if err != nil {
+		switch e := err.(type) {
+		case invalidRequestError:
+			writeJSONError(w, e.InvalidRequestDetails(), http.StatusBadRequest)
+			return
+		default:
+			writeUnavailableError(w, err, "Identifier could not be resolved within the read timeout")
+			return
+		}
+	}
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("{\"message\":\"No concept identified by %s %s found in db.\"}", authority, authorityValue)))
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resolutions); err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+//Context of the request, which is also done once the timeout has passed, unless it is 0
+func requestContext(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+func writeReadError(w http.ResponseWriter, err error) {
+	writeUnavailableError(w, err, "Concept could not be read within the read timeout")
+}
+
+//A 504 with the message if the request's timeout passed before it could be served, or a 503 with the error otherwise
+func writeUnavailableError(w http.ResponseWriter, err error, timeoutMessage string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSONError(w, timeoutMessage, http.StatusGatewayTimeout)
+		return
+	}
+	writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+}
+
+//Turn requests away while the service isn't ready to read or write concepts, rather than letting them fail part way
+func (h *ConceptsHandler) whenReady(handler http.Handler) http.Handler {
+	checker, ok := h.ConceptsService.(readinessChecker)
+	if !ok {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := checker.Ready(); err != nil {
+			writeJSONError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
 func writeJSONError(w http.ResponseWriter, errorMsg string, statusCode int) {
 	w.WriteHeader(statusCode)
 	fmt.Fprintln(w, fmt.Sprintf("{\"message\": \"%s\"}", errorMsg))
@@ -145,6 +677,43 @@ func checkConceptTypeAgainstPath(conceptType, path string) error {
 	return errors.New("path does not match content type")
 }
 
+func writeOptionsFromRequest(r *http.Request) (WriteOptions, error) {
+	options := WriteOptions{}
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return options, fmt.Errorf("Invalid value for dryRun: '%v'", v)
+		}
+		options.DryRun = dryRun
+	}
+
+	ifMatch := parseEntityTags(r.Header.Get("If-Match"))
+	ifNoneMatch := parseEntityTags(r.Header.Get("If-None-Match"))
+	if len(ifMatch) > 0 || len(ifNoneMatch) > 0 {
+		options.Precondition = &Precondition{IfMatch: ifMatch, IfNoneMatch: ifNoneMatch}
+	}
+	return options, nil
+}
+
+func isMergePatchContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return mediaType == "application/merge-patch+json"
+}
+
+//Entity tags are the quoted aggregate hashes returned as the ETag of a GET
+func parseEntityTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		tag = strings.TrimPrefix(tag, "W/")
+		tag = strings.Trim(tag, "\"")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
 var matchFirstCap = regexp.MustCompile("(.)([A-Z][a-z]+)")
 var matchAllCap = regexp.MustCompile("([a-z0-9])([A-Z])")
 
@@ -1,13 +1,17 @@
 package concepts
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -32,7 +36,7 @@ func TestPutHandler(t *testing.T) {
 				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
 				},
-				write: func(thing interface{}, transID string) (interface{}, error) {
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
 					return ConceptChanges{}, nil
 				},
 			},
@@ -47,7 +51,7 @@ func TestPutHandler(t *testing.T) {
 				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "FinancialInstrument"}, knownUUID, nil
 				},
-				write: func(thing interface{}, transID string) (interface{}, error) {
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
 					return ConceptChanges{}, nil
 				},
 			},
@@ -55,6 +59,85 @@ func TestPutHandler(t *testing.T) {
 			contentType: "",
 			body:        "{\"events\":null,\"updatedIDs\":null}",
 		},
+		{
+			name: "DryRunSuccess",
+			req:  newRequest("PUT", fmt.Sprintf("/dummies/%s?dryRun=true", knownUUID), t),
+			mockService: &mockConceptService{
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+				},
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					if !options.DryRun || options.Precondition != nil {
+						return nil, errors.New("unexpected write options")
+					}
+					return ConceptChanges{UpdatedIds: []string{knownUUID}}, nil
+				},
+			},
+			statusCode:  http.StatusOK,
+			contentType: "",
+			body:        "{\"events\":null,\"updatedIDs\":[\"12345\"]}",
+		},
+		{
+			name: "DryRunFailedDueToInvalidConcordance",
+			req:  newRequest("PUT", fmt.Sprintf("/dummies/%s?dryRun=true", knownUUID), t),
+			mockService: &mockConceptService{
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+				},
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					return nil, errors.New("TEST failing to DRY RUN")
+				},
+			},
+			statusCode:  http.StatusServiceUnavailable,
+			contentType: "",
+			body:        errorMessage("TEST failing to DRY RUN"),
+		},
+		{
+			name: "InvalidDryRunValue",
+			req:  newRequest("PUT", fmt.Sprintf("/dummies/%s?dryRun=maybe", knownUUID), t),
+			mockService: &mockConceptService{
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+				},
+			},
+			statusCode:  http.StatusBadRequest,
+			contentType: "",
+			body:        errorMessage("Invalid value for dryRun: 'maybe'"),
+		},
+		{
+			name: "IfMatchSuccess",
+			req:  newRequestWithHeader("PUT", fmt.Sprintf("/dummies/%s", knownUUID), "If-Match", "\"123\", W/\"456\"", t),
+			mockService: &mockConceptService{
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+				},
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					expected := &Precondition{IfMatch: []string{"123", "456"}}
+					if options.DryRun || !reflect.DeepEqual(expected, options.Precondition) {
+						return nil, errors.New("unexpected write options")
+					}
+					return ConceptChanges{}, nil
+				},
+			},
+			statusCode:  http.StatusOK,
+			contentType: "",
+			body:        "{\"events\":null,\"updatedIDs\":null}",
+		},
+		{
+			name: "IfNoneMatchPreconditionFailed",
+			req:  newRequestWithHeader("PUT", fmt.Sprintf("/dummies/%s", knownUUID), "If-None-Match", "*", t),
+			mockService: &mockConceptService{
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+				},
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					return nil, preconditionError{"TEST failing PRECONDITION"}
+				},
+			},
+			statusCode:  http.StatusPreconditionFailed,
+			contentType: "",
+			body:        errorMessage("TEST failing PRECONDITION"),
+		},
 		{
 			name: "ParseError",
 			req:  newRequest("PUT", fmt.Sprintf("/dummies/%s", knownUUID), t),
@@ -74,7 +157,7 @@ func TestPutHandler(t *testing.T) {
 				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
 				},
-				write: func(thing interface{}, transID string) (interface{}, error) {
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
 					return ConceptChanges{}, nil
 				},
 			},
@@ -89,7 +172,7 @@ func TestPutHandler(t *testing.T) {
 				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
 				},
-				write: func(thing interface{}, transID string) (interface{}, error) {
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
 					return nil, errors.New("TEST failing to WRITE")
 				},
 			},
@@ -104,7 +187,7 @@ func TestPutHandler(t *testing.T) {
 				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
 				},
-				write: func(thing interface{}, transID string) (interface{}, error) {
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
 					return nil, rwapi.ConstraintOrTransactionError{}
 				},
 			},
@@ -119,7 +202,7 @@ func TestPutHandler(t *testing.T) {
 				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "not-dummy"}, knownUUID, nil
 				},
-				write: func(thing interface{}, transID string) (interface{}, error) {
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
 					return ConceptChanges{}, nil
 				},
 			},
@@ -131,7 +214,7 @@ func TestPutHandler(t *testing.T) {
 
 	for _, test := range tests {
 		r := mux.NewRouter()
-		handler := ConceptsHandler{test.mockService}
+		handler := ConceptsHandler{ConceptsService: test.mockService}
 		handler.RegisterHandlers(r)
 		rec := httptest.NewRecorder()
 		r.ServeHTTP(rec, test.req)
@@ -154,7 +237,7 @@ func TestGetHandler(t *testing.T) {
 			name: "Success",
 			req:  newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t),
 			ds: &mockConceptService{
-				read: func(uuid string, transID string) (interface{}, bool, error) {
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, true, nil
 				},
 			},
@@ -166,7 +249,7 @@ func TestGetHandler(t *testing.T) {
 			name: "NotFound",
 			req:  newRequest("GET", fmt.Sprintf("/dummies/%s", "99999"), t),
 			ds: &mockConceptService{
-				read: func(uuid string, transID string) (interface{}, bool, error) {
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
 					return nil, false, nil
 				},
 			},
@@ -178,7 +261,7 @@ func TestGetHandler(t *testing.T) {
 			name: "ReadError",
 			req:  newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t),
 			ds: &mockConceptService{
-				read: func(uuid string, transID string) (interface{}, bool, error) {
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
 					return nil, false, errors.New("TEST failing to READ")
 				},
 			},
@@ -190,7 +273,7 @@ func TestGetHandler(t *testing.T) {
 			name: "BadConceptOrPath",
 			req:  newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t),
 			ds: &mockConceptService{
-				read: func(uuid string, transID string) (interface{}, bool, error) {
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
 					return AggregatedConcept{PrefUUID: knownUUID, Type: "not-dummy"}, true, nil
 				},
 			},
@@ -202,7 +285,715 @@ func TestGetHandler(t *testing.T) {
 
 	for _, test := range tests {
 		r := mux.NewRouter()
-		handler := ConceptsHandler{test.ds}
+		handler := ConceptsHandler{ConceptsService: test.ds}
+		handler.RegisterHandlers(r)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, test.req)
+		assert.Equal(test.statusCode, rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
+		assert.Equal(test.body, rec.Body.String(), fmt.Sprintf("%s: Wrong body", test.name))
+	}
+}
+
+func TestHandlersTimeOut(t *testing.T) {
+	waitForDeadline := &mockConceptService{
+		decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+			return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+		},
+		write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+			<-ctx.Done()
+			return ConceptChanges{}, ctx.Err()
+		},
+		read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+			<-ctx.Done()
+			return nil, false, ctx.Err()
+		},
+		export: func(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+			<-ctx.Done()
+			return nil, "", ctx.Err()
+		},
+		resolveIdentifier: func(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error) {
+			<-ctx.Done()
+			return nil, false, ctx.Err()
+		},
+		events: func(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		acknowledgeEvents: func(ctx context.Context, upTo string, transID string) (int, error) {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		},
+	}
+	deleteWaitsForDeadline := &mockConceptService{
+		read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+			return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, true, nil
+		},
+		delete: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+			<-ctx.Done()
+			return ConceptChanges{}, true, ctx.Err()
+		},
+	}
+	noDeadline := &mockConceptService{
+		decodeJSON: waitForDeadline.decodeJSON,
+		write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+			if _, ok := ctx.Deadline(); ok {
+				return nil, errors.New("unexpected deadline")
+			}
+			return ConceptChanges{}, nil
+		},
+		read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+			if _, ok := ctx.Deadline(); ok {
+				return nil, false, errors.New("unexpected deadline")
+			}
+			return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, true, nil
+		},
+	}
+
+	tests := []struct {
+		name         string
+		req          *http.Request
+		ds           ConceptServicer
+		readTimeout  time.Duration
+		writeTimeout time.Duration
+		statusCode   int
+		body         string
+	}{
+		{"Write timed out", newRequest("PUT", fmt.Sprintf("/dummies/%s", knownUUID), t), waitForDeadline, time.Minute, 10 * time.Millisecond, http.StatusGatewayTimeout, errorMessage("Concept could not be written within the write timeout")},
+		{"Read timed out", newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t), waitForDeadline, 10 * time.Millisecond, time.Minute, http.StatusGatewayTimeout, errorMessage("Concept could not be read within the read timeout")},
+		{"Delete timed out", newRequest("DELETE", fmt.Sprintf("/dummies/%s", knownUUID), t), deleteWaitsForDeadline, time.Minute, 10 * time.Millisecond, http.StatusGatewayTimeout, errorMessage("Concept could not be deleted within the write timeout")},
+		{"Export timed out", newRequest("GET", "/__export?type=Dummy", t), waitForDeadline, 10 * time.Millisecond, time.Minute, http.StatusGatewayTimeout, errorMessage("Concepts could not be exported within the read timeout")},
+		{"Identifier resolution timed out", newRequest("GET", "/__identifiers/TME/1234", t), waitForDeadline, 10 * time.Millisecond, time.Minute, http.StatusGatewayTimeout, errorMessage("Identifier could not be resolved within the read timeout")},
+		{"Events timed out", newRequest("GET", "/__events", t), waitForDeadline, 10 * time.Millisecond, time.Minute, http.StatusGatewayTimeout, errorMessage("Events could not be read within the read timeout")},
+		{"Acknowledgement timed out", newRequest("POST", "/__events/ack?cursor=1", t), waitForDeadline, time.Minute, 10 * time.Millisecond, http.StatusGatewayTimeout, errorMessage("Events could not be acknowledged within the write timeout")},
+		{"Write without a timeout", newRequest("PUT", fmt.Sprintf("/dummies/%s", knownUUID), t), noDeadline, 0, 0, http.StatusOK, "{\"events\":null,\"updatedIDs\":null}"},
+		{"Read without a timeout", newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t), noDeadline, 0, 0, http.StatusOK, "{\"prefUUID\":\"12345\",\"type\":\"Dummy\"}\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			handler := ConceptsHandler{ConceptsService: test.ds, ReadTimeout: test.readTimeout, WriteTimeout: test.writeTimeout}
+			handler.RegisterHandlers(r)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, test.req)
+			assert.Equal(t, test.statusCode, rec.Code)
+			assert.Equal(t, test.body, rec.Body.String())
+		})
+	}
+}
+
+func TestPatchHandler(t *testing.T) {
+	assert := assert.New(t)
+	readDummy := func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+		return AggregatedConcept{PrefUUID: knownUUID, PrefLabel: "Dummy", Type: "Dummy", Strapline: "Old strapline", AggregatedHash: "123"}, true, nil
+	}
+	decodeJSON := func(decoder *json.Decoder) (interface{}, string, error) {
+		ac := AggregatedConcept{}
+		err := decoder.Decode(&ac)
+		return ac, ac.PrefUUID, err
+	}
+	tests := []struct {
+		name        string
+		req         *http.Request
+		ds          ConceptServicer
+		statusCode  int
+		contentType string // Contents of the Content-Type header
+		body        string
+	}{
+		{
+			name: "Success",
+			req:  newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"strapline":"New strapline","aliases":["Alias"]}`, t),
+			ds: &mockConceptService{
+				read:       readDummy,
+				decodeJSON: decodeJSON,
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					expected := AggregatedConcept{PrefUUID: knownUUID, PrefLabel: "Dummy", Type: "Dummy", Strapline: "New strapline", Aliases: []string{"Alias"}}
+					if !reflect.DeepEqual(expected, thing) {
+						return nil, fmt.Errorf("unexpected patched concept %v", thing)
+					}
+					if !reflect.DeepEqual(&Precondition{IfMatch: []string{"123"}}, options.Precondition) {
+						return nil, errors.New("patch should only be applied to the concept that was read")
+					}
+					return ConceptChanges{UpdatedIds: []string{knownUUID}}, nil
+				},
+			},
+			statusCode:  http.StatusOK,
+			contentType: "",
+			body:        "{\"events\":null,\"updatedIDs\":[\"12345\"]}",
+		},
+		{
+			name: "RemoveField",
+			req:  newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"strapline":null}`, t),
+			ds: &mockConceptService{
+				read:       readDummy,
+				decodeJSON: decodeJSON,
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					if thing.(AggregatedConcept).Strapline != "" {
+						return nil, errors.New("strapline should have been removed")
+					}
+					return ConceptChanges{}, nil
+				},
+			},
+			statusCode:  http.StatusOK,
+			contentType: "",
+			body:        "{\"events\":null,\"updatedIDs\":null}",
+		},
+		{
+			name: "NotFound",
+			req:  newPatchRequest(fmt.Sprintf("/dummies/%s", "99999"), `{"strapline":"New strapline"}`, t),
+			ds: &mockConceptService{
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return nil, false, nil
+				},
+			},
+			statusCode:  http.StatusNotFound,
+			contentType: "",
+			body:        "{\"message\":\"Concept with prefUUID 99999 not found in db.\"}",
+		},
+		{
+			name: "InvalidPatch",
+			req:  newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"strapline":`, t),
+			ds: &mockConceptService{
+				read:       readDummy,
+				decodeJSON: decodeJSON,
+			},
+			statusCode:  http.StatusBadRequest,
+			contentType: "",
+			body:        errorMessage("Invalid merge patch: unexpected EOF"),
+		},
+		{
+			name: "PrefUUIDChanged",
+			req:  newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"prefUUID":"99999"}`, t),
+			ds: &mockConceptService{
+				read:       readDummy,
+				decodeJSON: decodeJSON,
+			},
+			statusCode:  http.StatusBadRequest,
+			contentType: "",
+			body:        errorMessage("Uuids from patched concept and request, respectively, do not match: '99999' '12345'"),
+		},
+		{
+			name: "TypeChanged",
+			req:  newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"type":"not-dummy"}`, t),
+			ds: &mockConceptService{
+				read:       readDummy,
+				decodeJSON: decodeJSON,
+			},
+			statusCode:  http.StatusBadRequest,
+			contentType: "",
+			body:        errorMessage("Concept type does not match path"),
+		},
+		{
+			name: "UnsupportedContentType",
+			req: func() *http.Request {
+				req := newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `[]`, t)
+				req.Header.Set("Content-Type", "application/json-patch+json")
+				return req
+			}(),
+			ds:          &mockConceptService{},
+			statusCode:  http.StatusUnsupportedMediaType,
+			contentType: "",
+			body:        errorMessage("Unsupported patch content type: 'application/json-patch+json'"),
+		},
+		{
+			name: "PlainJSON",
+			req: func() *http.Request {
+				req := newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"strapline":"New strapline"}`, t)
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			}(),
+			ds:          &mockConceptService{},
+			statusCode:  http.StatusUnsupportedMediaType,
+			contentType: "",
+			body:        errorMessage("Unsupported patch content type: 'application/json'"),
+		},
+		{
+			name:        "MissingContentType",
+			req:         newRequestWithBody("PATCH", fmt.Sprintf("/dummies/%s", knownUUID), `{"strapline":"New strapline"}`, t),
+			ds:          &mockConceptService{},
+			statusCode:  http.StatusUnsupportedMediaType,
+			contentType: "",
+			body:        errorMessage("Unsupported patch content type: ''"),
+		},
+		{
+			name: "ConcurrentlyModified",
+			req:  newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"strapline":"New strapline"}`, t),
+			ds: &mockConceptService{
+				read:       readDummy,
+				decodeJSON: decodeJSON,
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					return nil, preconditionError{"TEST failing PRECONDITION"}
+				},
+			},
+			statusCode:  http.StatusPreconditionFailed,
+			contentType: "",
+			body:        errorMessage("TEST failing PRECONDITION"),
+		},
+	}
+
+	for _, test := range tests {
+		r := mux.NewRouter()
+		handler := ConceptsHandler{ConceptsService: test.ds}
+		handler.RegisterHandlers(r)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, test.req)
+		assert.Equal(test.statusCode, rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
+		assert.Equal(test.body, rec.Body.String(), fmt.Sprintf("%s: Wrong body", test.name))
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	assert := assert.New(t)
+	readDummy := func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+		return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, true, nil
+	}
+	tests := []struct {
+		name        string
+		req         *http.Request
+		ds          ConceptServicer
+		statusCode  int
+		contentType string // Contents of the Content-Type header
+		body        string
+	}{
+		{
+			name: "Success",
+			req:  newRequest("DELETE", fmt.Sprintf("/dummies/%s", knownUUID), t),
+			ds: &mockConceptService{
+				read: readDummy,
+				delete: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return ConceptChanges{
+						ChangedRecords: []Event{
+							{
+								ConceptType: "Dummy",
+								ConceptUUID: "67890",
+								EventDetails: ConcordanceEvent{
+									Type:  RemovedEvent,
+									OldID: knownUUID,
+									NewID: "67890",
+								},
+							},
+						},
+						UpdatedIds: []string{knownUUID, "67890"},
+					}, true, nil
+				},
+			},
+			statusCode:  http.StatusOK,
+			contentType: "",
+			body:        "{\"events\":[{\"type\":\"Dummy\",\"uuid\":\"67890\",\"aggregateHash\":\"\",\"transactionID\":\"\",\"eventDetails\":{\"eventType\":\"CONCORDANCE_REMOVED\",\"oldID\":\"12345\",\"newID\":\"67890\"}}],\"updatedIDs\":[\"12345\",\"67890\"]}",
+		},
+		{
+			name: "NotFound",
+			req:  newRequest("DELETE", fmt.Sprintf("/dummies/%s", "99999"), t),
+			ds: &mockConceptService{
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return nil, false, nil
+				},
+			},
+			statusCode:  http.StatusNotFound,
+			contentType: "",
+			body:        "{\"message\":\"Concept with prefUUID 99999 not found in db.\"}",
+		},
+		{
+			name: "BadConceptOrPath",
+			req:  newRequest("DELETE", fmt.Sprintf("/dummies/%s", knownUUID), t),
+			ds: &mockConceptService{
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "not-dummy"}, true, nil
+				},
+			},
+			statusCode:  http.StatusBadRequest,
+			contentType: "",
+			body:        errorMessage("Concept type does not match path"),
+		},
+		{
+			name: "HasDependants",
+			req:  newRequest("DELETE", fmt.Sprintf("/dummies/%s", knownUUID), t),
+			ds: &mockConceptService{
+				read: readDummy,
+				delete: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return ConceptChanges{}, true, dependantsConflictError{prefUUID: knownUUID, dependants: []string{"67890"}}
+				},
+			},
+			statusCode:  http.StatusConflict,
+			contentType: "",
+			body:        "{\"dependants\":[\"67890\"],\"message\":\"Cannot delete concept with prefUuid: 12345 as it still has 1 dependant concepts\"}\n",
+		},
+		{
+			name: "DeleteError",
+			req:  newRequest("DELETE", fmt.Sprintf("/dummies/%s", knownUUID), t),
+			ds: &mockConceptService{
+				read: readDummy,
+				delete: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return nil, false, errors.New("TEST failing to DELETE")
+				},
+			},
+			statusCode:  http.StatusServiceUnavailable,
+			contentType: "",
+			body:        errorMessage("TEST failing to DELETE"),
+		},
+	}
+
+	for _, test := range tests {
+		r := mux.NewRouter()
+		handler := ConceptsHandler{ConceptsService: test.ds}
+		handler.RegisterHandlers(r)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, test.req)
+		assert.Equal(test.statusCode, rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
+		assert.Equal(test.body, rec.Body.String(), fmt.Sprintf("%s: Wrong body", test.name))
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	assert := assert.New(t)
+	pages := map[string][]AggregatedConcept{
+		"":      {{PrefUUID: "1", Type: "Dummy"}, {PrefUUID: "2", Type: "Dummy"}},
+		"2":     {{PrefUUID: "3", Type: "Dummy"}},
+		"error": nil,
+	}
+	exportPages := func(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+		if after == "error" {
+			return nil, "", errors.New("TEST failing to EXPORT")
+		}
+		next := ""
+		if after == "" {
+			next = "2"
+		}
+		return pages[after], next, nil
+	}
+	tests := []struct {
+		name        string
+		req         *http.Request
+		ds          ConceptServicer
+		statusCode  int
+		contentType string // Contents of the Content-Type header
+		body        string
+	}{
+		{
+			name:        "Success",
+			req:         newRequest("GET", "/__export?type=Dummy", t),
+			ds:          &mockConceptService{export: exportPages},
+			statusCode:  http.StatusOK,
+			contentType: "application/x-ndjson",
+			body:        "{\"prefUUID\":\"1\",\"type\":\"Dummy\"}\n{\"prefUUID\":\"2\",\"type\":\"Dummy\"}\n{\"prefUUID\":\"3\",\"type\":\"Dummy\"}\n",
+		},
+		{
+			name:        "ResumeAfter",
+			req:         newRequest("GET", "/__export?type=Dummy&after=2", t),
+			ds:          &mockConceptService{export: exportPages},
+			statusCode:  http.StatusOK,
+			contentType: "application/x-ndjson",
+			body:        "{\"prefUUID\":\"3\",\"type\":\"Dummy\"}\n",
+		},
+		{
+			name:        "MissingType",
+			req:         newRequest("GET", "/__export", t),
+			ds:          &mockConceptService{export: exportPages},
+			statusCode:  http.StatusBadRequest,
+			contentType: "application/json",
+			body:        errorMessage("A type must be provided to export concepts"),
+		},
+		{
+			name: "UnknownType",
+			req:  newRequest("GET", "/__export?type=Unknown", t),
+			ds: &mockConceptService{
+				export: func(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+					return nil, "", requestError{"TEST unknown TYPE"}
+				},
+			},
+			statusCode:  http.StatusBadRequest,
+			contentType: "application/json",
+			body:        errorMessage("TEST unknown TYPE"),
+		},
+		{
+			name:        "ExportError",
+			req:         newRequest("GET", "/__export?type=Dummy&after=error", t),
+			ds:          &mockConceptService{export: exportPages},
+			statusCode:  http.StatusServiceUnavailable,
+			contentType: "application/json",
+			body:        errorMessage("TEST failing to EXPORT"),
+		},
+	}
+
+	for _, test := range tests {
+		r := mux.NewRouter()
+		handler := ConceptsHandler{ConceptsService: test.ds}
+		handler.RegisterHandlers(r)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, test.req)
+		assert.Equal(test.statusCode, rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
+		assert.Equal(test.contentType, rec.Header().Get("Content-Type"), fmt.Sprintf("%s: Wrong content type", test.name))
+		assert.Equal(test.body, rec.Body.String(), fmt.Sprintf("%s: Wrong body", test.name))
+	}
+}
+
+func TestBulkWriteHandler(t *testing.T) {
+	assert := assert.New(t)
+	decodeJSON := func(decoder *json.Decoder) (interface{}, string, error) {
+		concept := AggregatedConcept{}
+		err := decoder.Decode(&concept)
+		return concept, concept.PrefUUID, err
+	}
+	tests := []struct {
+		name        string
+		req         *http.Request
+		mockService ConceptServicer
+		body        string
+	}{
+		{
+			name: "Success",
+			req:  newRequestWithBody("POST", "/__bulk", "{\"prefUUID\":\"1\"}\n\n{\"prefUUID\":\"2\"}", t),
+			mockService: &mockConceptService{
+				decodeJSON: decodeJSON,
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+					return ConceptChanges{UpdatedIds: []string{thing.(AggregatedConcept).PrefUUID}}, nil
+				},
+			},
+			body: "{\"line\":1,\"uuid\":\"1\",\"status\":200,\"changes\":{\"events\":null,\"updatedIDs\":[\"1\"]}}\n" +
+				"{\"line\":3,\"uuid\":\"2\",\"status\":200,\"changes\":{\"events\":null,\"updatedIDs\":[\"2\"]}}\n",
+		},
+		{
+			name: "InvalidLine",
+			req:  newRequestWithBody("POST", "/__bulk", "{\"prefUUID\":\n{\"prefUUID\":\"2\"}\n", t),
+			mockService: &mockConceptService{
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					concept, uuid, err := decodeJSON(decoder)
+					if err != nil {
+						return nil, "", errors.New("TEST failing to DECODE")
+					}
+					return concept, uuid, nil
+				},
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+					return ConceptChanges{}, nil
+				},
+			},
+			body: "{\"line\":1,\"status\":400,\"error\":{\"type\":\"invalidRequest\",\"message\":\"TEST failing to DECODE\"}}\n" +
+				"{\"line\":2,\"uuid\":\"2\",\"status\":200,\"changes\":{\"events\":null,\"updatedIDs\":null}}\n",
+		},
+		{
+			name: "ClassifiedWriteErrors",
+			req:  newRequestWithBody("POST", "/__bulk", "{\"prefUUID\":\"1\"}\n{\"prefUUID\":\"2\"}\n{\"prefUUID\":\"3\"}\n{\"prefUUID\":\"4\"}\n", t),
+			mockService: &mockConceptService{
+				decodeJSON: decodeJSON,
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+					switch thing.(AggregatedConcept).PrefUUID {
+					case "1":
+						return nil, requestError{"TEST invalid REQUEST"}
+					case "2":
+						return nil, rwapi.ConstraintOrTransactionError{Message: "TEST failing to WRITE"}
+					case "3":
+						return nil, errors.New("TEST failing to CONNECT")
+					default:
+						return nil, noContentErr{}
+					}
+				},
+			},
+			body: "{\"line\":1,\"uuid\":\"1\",\"status\":400,\"error\":{\"type\":\"invalidRequest\",\"message\":\"TEST invalid REQUEST\"}}\n" +
+				"{\"line\":2,\"uuid\":\"2\",\"status\":409,\"error\":{\"type\":\"conflict\",\"message\":\"TEST failing to WRITE\"}}\n" +
+				"{\"line\":3,\"uuid\":\"3\",\"status\":503,\"error\":{\"type\":\"unavailable\",\"message\":\"TEST failing to CONNECT\"}}\n" +
+				"{\"line\":4,\"uuid\":\"4\",\"status\":204}\n",
+		},
+	}
+
+	for _, test := range tests {
+		r := mux.NewRouter()
+		handler := ConceptsHandler{ConceptsService: test.mockService}
+		handler.RegisterHandlers(r)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, test.req)
+		assert.Equal(http.StatusOK, rec.Code, fmt.Sprintf("%s: Wrong response code", test.name))
+		assert.Equal("application/x-ndjson", rec.Header().Get("Content-Type"), fmt.Sprintf("%s: Wrong content type", test.name))
+		assert.Equal(test.body, rec.Body.String(), fmt.Sprintf("%s: Wrong body", test.name))
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	assert := assert.New(t)
+	event := OutboxEvent{
+		Cursor: "7",
+		Event: Event{
+			ConceptType:   "Dummy",
+			ConceptUUID:   knownUUID,
+			AggregateHash: "123",
+			TransactionID: "tid_test",
+			EventDetails:  ConceptEvent{Type: UpdatedEvent},
+		},
+	}
+	tests := []struct {
+		name        string
+		req         *http.Request
+		mockService ConceptServicer
+		statusCode  int
+		body        string
+	}{
+		{
+			name: "Success",
+			req:  newRequest("GET", "/__events?after=6&limit=1", t),
+			mockService: &mockConceptService{
+				events: func(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error) {
+					if after != "6" || limit != 1 {
+						return nil, errors.New("unexpected cursor or limit")
+					}
+					return []OutboxEvent{event}, nil
+				},
+			},
+			statusCode: http.StatusOK,
+			body:       "{\"cursor\":\"7\",\"events\":[{\"cursor\":\"7\",\"event\":{\"type\":\"Dummy\",\"uuid\":\"12345\",\"aggregateHash\":\"123\",\"transactionID\":\"tid_test\",\"eventDetails\":{\"eventType\":\"CONCEPT_UPDATED\"}}}]}\n",
+		},
+		{
+			name: "NoNewEvents",
+			req:  newRequest("GET", "/__events?after=7", t),
+			mockService: &mockConceptService{
+				events: func(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error) {
+					if limit != defaultEventsLimit {
+						return nil, errors.New("unexpected limit")
+					}
+					return []OutboxEvent{}, nil
+				},
+			},
+			statusCode: http.StatusOK,
+			body:       "{\"cursor\":\"7\",\"events\":[]}\n",
+		},
+		{
+			name:        "InvalidLimit",
+			req:         newRequest("GET", "/__events?limit=0", t),
+			mockService: &mockConceptService{},
+			statusCode:  http.StatusBadRequest,
+			body:        errorMessage("Invalid value for limit: '0'"),
+		},
+		{
+			name: "InvalidCursor",
+			req:  newRequest("GET", "/__events?after=abc", t),
+			mockService: &mockConceptService{
+				events: func(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error) {
+					return nil, requestError{"TEST invalid CURSOR"}
+				},
+			},
+			statusCode: http.StatusBadRequest,
+			body:       errorMessage("TEST invalid CURSOR"),
+		},
+		{
+			name: "EventsError",
+			req:  newRequest("GET", "/__events", t),
+			mockService: &mockConceptService{
+				events: func(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error) {
+					return nil, errors.New("TEST failing to READ")
+				},
+			},
+			statusCode: http.StatusServiceUnavailable,
+			body:       errorMessage("TEST failing to READ"),
+		},
+		{
+			name: "AcknowledgeSuccess",
+			req:  newRequest("POST", "/__events/ack?cursor=7", t),
+			mockService: &mockConceptService{
+				acknowledgeEvents: func(ctx context.Context, upTo string, transID string) (int, error) {
+					if upTo != "7" {
+						return 0, errors.New("unexpected cursor")
+					}
+					return 3, nil
+				},
+			},
+			statusCode: http.StatusOK,
+			body:       "{\"acknowledged\":3}\n",
+		},
+		{
+			name:        "AcknowledgeWithoutCursor",
+			req:         newRequest("POST", "/__events/ack", t),
+			mockService: &mockConceptService{},
+			statusCode:  http.StatusBadRequest,
+			body:        errorMessage("A cursor must be provided to acknowledge events"),
+		},
+		{
+			name: "AcknowledgeError",
+			req:  newRequest("POST", "/__events/ack?cursor=7", t),
+			mockService: &mockConceptService{
+				acknowledgeEvents: func(ctx context.Context, upTo string, transID string) (int, error) {
+					return 0, errors.New("TEST failing to DELETE")
+				},
+			},
+			statusCode: http.StatusServiceUnavailable,
+			body:       errorMessage("TEST failing to DELETE"),
+		},
+	}
+
+	for _, test := range tests {
+		r := mux.NewRouter()
+		handler := ConceptsHandler{ConceptsService: test.mockService}
+		handler.RegisterHandlers(r)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, test.req)
+		assert.Equal(test.statusCode, rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
+		assert.Equal(test.body, rec.Body.String(), fmt.Sprintf("%s: Wrong body", test.name))
+	}
+}
+
+func TestResolveIdentifierHandler(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		name        string
+		req         *http.Request
+		ds          ConceptServicer
+		statusCode  int
+		contentType string // Contents of the Content-Type header
+		body        string
+	}{
+		{
+			name: "Success",
+			req:  newRequest("GET", "/__identifiers/TME/abc-123", t),
+			ds: &mockConceptService{
+				resolveIdentifier: func(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error) {
+					if authority != "TME" || authorityValue != "abc-123" {
+						return nil, false, errors.New("unexpected identifier")
+					}
+					return []IdentifierResolution{{UUID: "67890", PrefUUID: knownUUID, Type: "Dummy"}}, true, nil
+				},
+			},
+			statusCode:  http.StatusOK,
+			contentType: "",
+			body:        "[{\"uuid\":\"67890\",\"prefUUID\":\"12345\",\"type\":\"Dummy\"}]\n",
+		},
+		{
+			name: "NotFound",
+			req:  newRequest("GET", "/__identifiers/leiCode/213800KZEW5W6BZMNT62", t),
+			ds: &mockConceptService{
+				resolveIdentifier: func(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error) {
+					return []IdentifierResolution{}, false, nil
+				},
+			},
+			statusCode:  http.StatusNotFound,
+			contentType: "",
+			body:        "{\"message\":\"No concept identified by leiCode 213800KZEW5W6BZMNT62 found in db.\"}",
+		},
+		{
+			name: "UnknownAuthority",
+			req:  newRequest("GET", "/__identifiers/Wikidata/Q42", t),
+			ds: &mockConceptService{
+				resolveIdentifier: func(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error) {
+					return nil, false, requestError{"TEST unknown AUTHORITY"}
+				},
+			},
+			statusCode:  http.StatusBadRequest,
+			contentType: "",
+			body:        errorMessage("TEST unknown AUTHORITY"),
+		},
+		{
+			name: "ResolveError",
+			req:  newRequest("GET", "/__identifiers/TME/abc-123", t),
+			ds: &mockConceptService{
+				resolveIdentifier: func(ctx context.Context, authority string, authorityValue string, transID string) (interface{}, bool, error) {
+					return nil, false, errors.New("TEST failing to RESOLVE")
+				},
+			},
+			statusCode:  http.StatusServiceUnavailable,
+			contentType: "",
+			body:        errorMessage("TEST failing to RESOLVE"),
+		},
+	}
+
+	for _, test := range tests {
+		r := mux.NewRouter()
+		handler := ConceptsHandler{ConceptsService: test.ds}
 		handler.RegisterHandlers(r)
 		rec := httptest.NewRecorder()
 		r.ServeHTTP(rec, test.req)
@@ -249,8 +1040,8 @@ func TestGtgHandler(t *testing.T) {
 
 	for _, test := range tests {
 		r := mux.NewRouter()
-		handler := ConceptsHandler{test.ds}
-		handler.RegisterAdminHandlers(r, "", "", "", true)
+		handler := ConceptsHandler{ConceptsService: test.ds}
+		handler.RegisterAdminHandlers(r, "", "", "")
 		rec := httptest.NewRecorder()
 		r.ServeHTTP(rec, test.req)
 		assert.Equal(test.statusCode, rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
@@ -258,8 +1049,75 @@ func TestGtgHandler(t *testing.T) {
 	}
 }
 
+func TestAdminEndpointsAreOnlyServedByTheAdminRouter(t *testing.T) {
+	tests := []struct {
+		path string
+	}{
+		{"/__gtg"},
+		{"/metrics"},
+		{"/debug/pprof/"},
+		{"/debug/pprof/cmdline"},
+	}
+
+	handler := ConceptsHandler{ConceptsService: &mockConceptService{
+		check: func() error {
+			return nil
+		},
+	}}
+	adminRouter := mux.NewRouter()
+	handler.RegisterAdminHandlers(adminRouter, "", "", "")
+	apiRouter := mux.NewRouter()
+	handler.RegisterHandlers(apiRouter)
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			adminRouter.ServeHTTP(rec, newRequest("GET", test.path, t))
+			assert.Equal(t, http.StatusOK, rec.Code, "The admin router should serve %s", test.path)
+
+			rec = httptest.NewRecorder()
+			apiRouter.ServeHTTP(rec, newRequest("GET", test.path, t))
+			assert.NotEqual(t, http.StatusOK, rec.Code, "The API router should not serve %s", test.path)
+		})
+	}
+}
+
+func TestGetHandlerSetsETag(t *testing.T) {
+	r := mux.NewRouter()
+	handler := ConceptsHandler{ConceptsService: &mockConceptService{
+		read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+			return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy", AggregatedHash: "123"}, true, nil
+		},
+	}}
+	handler.RegisterHandlers(r)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "\"123\"", rec.Header().Get("ETag"))
+}
+
+func newRequestWithHeader(method, url, header, value string, t *testing.T) *http.Request {
+	req := newRequest(method, url, t)
+	req.Header.Set(header, value)
+	return req
+}
+
+func newRequestWithBody(method, url, body string, t *testing.T) *http.Request {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func newPatchRequest(url, body string, t *testing.T) *http.Request {
+	req := newRequestWithBody("PATCH", url, body, t)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	return req
+}
+
 func newRequest(method, url string, t *testing.T) *http.Request {
-	req, err := http.NewRequest(method, url, nil)
+	req, err := http.NewRequest(method, url, http.NoBody)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -269,3 +1127,13 @@ func newRequest(method, url string, t *testing.T) *http.Request {
 func errorMessage(errMsg string) string {
 	return fmt.Sprintf("{\"message\": \"%s\"}\n", errMsg)
 }
+
+type noContentErr struct{}
+
+func (e noContentErr) Error() string {
+	return "no content"
+}
+
+func (e noContentErr) NoContentReturnedDetails() string {
+	return e.Error()
+}
@@ -0,0 +1,113 @@
+package concepts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/mitchellh/hashstructure"
+)
+
+//Versions of the aggregate hash, stored on the canonical node next to the hash so that a stored hash is always
+//compared with a hash of the same version. Concepts written before the version was stored have a legacy hash.
+const (
+	legacyHashVersion    = 1
+	canonicalHashVersion = 2
+	currentHashVersion   = canonicalHashVersion
+)
+
+//Hash the concept as it would be written with the given version of the aggregate hash
+func hashConcept(concept AggregatedConcept, version int) (string, error) {
+	switch version {
+	case 0, legacyHashVersion:
+		hash, err := hashstructure.Hash(concept, nil)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(hash, 10), nil
+	case canonicalHashVersion:
+		return canonicalHash(concept)
+	default:
+		return "", fmt.Errorf("Unknown aggregate hash version: %d", version)
+	}
+}
+
+//Hash of the concept's canonical JSON, in which the order of lists is ignored and empty values are the same as
+//missing ones, so that the same concept always has the same hash however it was put together
+func canonicalHash(concept AggregatedConcept) (string, error) {
+	concept.AggregatedHash = ""
+	data, err := json.Marshal(concept)
+	if err != nil {
+		return "", err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return "", err
+	}
+
+	canonical, err := json.Marshal(canonicalValue(value))
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write(canonical)
+	return strconv.FormatUint(h.Sum64(), 10), nil
+}
+
+//Drop empty values and sort lists by the canonical JSON of their elements. Objects need no sorting, as their keys
+//are always marshalled in order.
+func canonicalValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := map[string]interface{}{}
+		for key, field := range v {
+			if field = canonicalValue(field); field != nil {
+				object[key] = field
+			}
+		}
+		if len(object) == 0 {
+			return nil
+		}
+		return object
+	case []interface{}:
+		var elements []interface{}
+		var keys []string
+		for _, element := range v {
+			if element = canonicalValue(element); element != nil {
+				key, _ := json.Marshal(element)
+				elements = append(elements, element)
+				keys = append(keys, string(key))
+			}
+		}
+		if len(elements) == 0 {
+			return nil
+		}
+		sort.Sort(byKey{elements, keys})
+		return elements
+	case string:
+		if v == "" {
+			return nil
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+type byKey struct {
+	elements []interface{}
+	keys     []string
+}
+
+func (b byKey) Len() int           { return len(b.elements) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.elements[i], b.elements[j] = b.elements[j], b.elements[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
@@ -0,0 +1,90 @@
+package concepts
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/mitchellh/hashstructure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalHashIgnoresOrder(t *testing.T) {
+	concept := AggregatedConcept{
+		PrefUUID: "1",
+		Aliases:  []string{"a", "b", "c"},
+		SourceRepresentations: []Concept{
+			{UUID: "1", RelatedUUIDs: []string{"x", "y"}},
+			{UUID: "2", MembershipRoles: []MembershipRole{{RoleUUID: "r1"}, {RoleUUID: "r2"}}},
+		},
+	}
+	reordered := AggregatedConcept{
+		PrefUUID: "1",
+		Aliases:  []string{"c", "a", "b"},
+		SourceRepresentations: []Concept{
+			{UUID: "2", MembershipRoles: []MembershipRole{{RoleUUID: "r2"}, {RoleUUID: "r1"}}},
+			{UUID: "1", RelatedUUIDs: []string{"y", "x"}},
+		},
+	}
+
+	hash, err := hashConcept(concept, canonicalHashVersion)
+	assert.NoError(t, err)
+	reorderedHash, err := hashConcept(reordered, canonicalHashVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, reorderedHash, "The order of lists should not change the hash")
+}
+
+func TestCanonicalHashNormalisesEmptyValues(t *testing.T) {
+	concept := AggregatedConcept{PrefUUID: "1", Aliases: []string{"a"}}
+	withEmpties := AggregatedConcept{
+		PrefUUID:              "1",
+		Aliases:               []string{"a", ""},
+		TradeNames:            []string{},
+		SourceRepresentations: []Concept{{}},
+		MembershipRoles:       []MembershipRole{{}},
+		AggregatedHash:        "123",
+	}
+
+	hash, err := hashConcept(concept, canonicalHashVersion)
+	assert.NoError(t, err)
+	emptiesHash, err := hashConcept(withEmpties, canonicalHashVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, emptiesHash, "Empty values should not change the hash")
+}
+
+func TestCanonicalHashChangesWithContent(t *testing.T) {
+	hash, err := hashConcept(AggregatedConcept{PrefUUID: "1", Aliases: []string{"a", "b"}}, canonicalHashVersion)
+	assert.NoError(t, err)
+	changedHash, err := hashConcept(AggregatedConcept{PrefUUID: "1", Aliases: []string{"a", "c"}}, canonicalHashVersion)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, changedHash)
+
+	duplicatedHash, err := hashConcept(AggregatedConcept{PrefUUID: "1", Aliases: []string{"a", "b", "b"}}, canonicalHashVersion)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, duplicatedHash)
+}
+
+func TestLegacyHash(t *testing.T) {
+	concept := AggregatedConcept{PrefUUID: "1", Aliases: []string{"a", "b"}}
+	expected, err := hashstructure.Hash(concept, nil)
+	assert.NoError(t, err)
+
+	for _, version := range []int{0, legacyHashVersion} {
+		hash, err := hashConcept(concept, version)
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.FormatUint(expected, 10), hash, "Concepts without a stored version should be compared with the legacy hash")
+	}
+
+	// the version is not part of the content of the concept
+	concept.AggregatedHashVersion = canonicalHashVersion
+	hash, err := hashConcept(concept, legacyHashVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.FormatUint(expected, 10), hash)
+
+	_, err = hashConcept(concept, 99)
+	assert.Error(t, err)
+
+	// as hashed by writers before the hash was versioned
+	hash, err = hashConcept(cleanSourceProperties(getAggregatedConcept(t, "membership.json")), legacyHashVersion)
+	assert.NoError(t, err)
+	assert.Equal(t, "7019061401434978811", hash)
+}
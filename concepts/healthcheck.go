@@ -2,6 +2,7 @@ package concepts
 
 import (
 	"net/http"
+	"net/http/pprof"
 	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
@@ -10,11 +11,14 @@ import (
 	"github.com/Financial-Times/service-status-go/gtg"
 	st "github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rcrowley/go-metrics"
 	log "github.com/sirupsen/logrus"
 )
 
-func (h *ConceptsHandler) RegisterAdminHandlers(router *mux.Router, appSystemCode string, appName string, appDescription string, enableRequestLogging bool) http.Handler {
+// RegisterAdminHandlers - registers the healthcheck, good to go, build info, Prometheus metrics and pprof endpoints, which are
+// served on the admin port rather than alongside the API
+func (h *ConceptsHandler) RegisterAdminHandlers(router *mux.Router, appSystemCode string, appName string, appDescription string) {
 	logger.Info("Registering healthcheck handlers")
 
 	hc := fthealth.TimedHealthCheck{
@@ -30,14 +34,21 @@ func (h *ConceptsHandler) RegisterAdminHandlers(router *mux.Router, appSystemCod
 	router.HandleFunc("/__health", fthealth.Handler(hc))
 	router.HandleFunc(st.BuildInfoPath, st.BuildInfoHandler)
 	router.HandleFunc(st.GTGPath, st.NewGoodToGoHandler(h.GTG))
+	router.Handle("/metrics", promhttp.Handler())
 
-	var monitoringRouter http.Handler = router
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+}
+
+// MonitoringHandler - logs the requests to the handler, if enabled, and counts them in the default metrics registry
+func MonitoringHandler(handler http.Handler, enableRequestLogging bool) http.Handler {
 	if enableRequestLogging {
-		monitoringRouter = httphandlers.TransactionAwareRequestLoggingHandler(log.StandardLogger(), monitoringRouter)
+		handler = httphandlers.TransactionAwareRequestLoggingHandler(log.StandardLogger(), handler)
 	}
-	monitoringRouter = httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry, monitoringRouter)
-
-	return monitoringRouter
+	return httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry, handler)
 }
 
 func (h *ConceptsHandler) GTG() gtg.Status {
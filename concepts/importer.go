@@ -0,0 +1,325 @@
+package concepts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/transactionid-utils-go"
+)
+
+// Importer - writes the concepts in files of newline delimited JSON, one concept per line
+type Importer struct {
+	ConceptsService ConceptServicer
+	//Number of concepts written at the same time
+	Workers int
+	//File recording how many lines of each file have been imported, so that an interrupted import can be resumed
+	CheckpointFile string
+	//How often progress is logged and the checkpoint file saved
+	ProgressInterval time.Duration
+}
+
+// ImportStats - what an import did
+type ImportStats struct {
+	Written int
+	Failed  int
+	Skipped int
+}
+
+type importLine struct {
+	file   string
+	number int
+	data   []byte
+	//The prefUUID and source uuids of the concept on the line
+	uuids []string
+}
+
+type importResult struct {
+	file   string
+	number int
+	result BulkWriteResult
+}
+
+//Lines of each file that have been imported, keyed by file name
+type importCheckpoint map[string]int
+
+//Import writes every line of the files in turn. Lines are written by several workers at once, but a line isn't started
+//until every earlier line with any of the same uuids has been written, so that a concept concorded with one written
+//earlier in the files ends up as it would if the lines were written one at a time. Lines that can't be written as they
+//are, or that conflict with other concepts, are logged and skipped. The import stops when Neo4j can't be written to, and the checkpoint is only ever
+//advanced past lines that have been imported so that resuming the import retries the line that failed.
+func (i *Importer) Import(files []string) (ImportStats, error) {
+	stats := ImportStats{}
+	checkpoint, err := i.loadCheckpoint()
+	if err != nil {
+		return stats, err
+	}
+
+	workers := i.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	progressInterval := i.ProgressInterval
+	if progressInterval <= 0 {
+		progressInterval = 10 * time.Second
+	}
+
+	lines := make(chan importLine, workers)
+	results := make(chan importResult, workers)
+	stop := make(chan struct{})
+	inFlight := newImportGate()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				result := i.importLine(line)
+				inFlight.release(line.uuids)
+				results <- importResult{line.file, line.number, result}
+			}
+		}()
+	}
+
+	resumeFrom := importCheckpoint{}
+	for file, line := range checkpoint {
+		resumeFrom[file] = line
+	}
+	var readErr error
+	var skipped int64
+	go func() {
+		defer close(lines)
+		readErr = i.readFiles(files, resumeFrom, inFlight, lines, stop, &skipped)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	//Lines are written out of order, so the lines of a file that have been imported but which follow one that
+	//hasn't are held back from the checkpoint until it has
+	imported := map[string]map[int]bool{}
+	var writeErr error
+	start := time.Now()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case r, ok := <-results:
+			stats.Skipped = int(atomic.LoadInt64(&skipped))
+			if !ok {
+				if err := i.saveCheckpoint(checkpoint); err != nil {
+					return stats, err
+				}
+				logProgress(stats, start)
+				if writeErr != nil {
+					return stats, writeErr
+				}
+				return stats, readErr
+			}
+
+			if r.result.Error != nil && r.result.Error.Type == bulkUnavailable {
+				logger.WithFields(map[string]interface{}{"file": r.file, "line": r.number}).Errorf("Stopping import as concept could not be written: %s", r.result.Error.Message)
+				if writeErr == nil {
+					writeErr = fmt.Errorf("Import stopped at line %d of %s: %s", r.number, r.file, r.result.Error.Message)
+					close(stop)
+				}
+				continue
+			}
+
+			if r.result.Error != nil {
+				logger.WithFields(map[string]interface{}{"file": r.file, "line": r.number, "uuid": r.result.UUID}).Errorf("Skipping concept that could not be imported: %s", r.result.Error.Message)
+				stats.Failed++
+			} else if r.result.Status != 0 {
+				stats.Written++
+			}
+
+			if imported[r.file] == nil {
+				imported[r.file] = map[int]bool{}
+			}
+			imported[r.file][r.number] = true
+			for imported[r.file][checkpoint[r.file]+1] {
+				delete(imported[r.file], checkpoint[r.file]+1)
+				checkpoint[r.file]++
+			}
+		case <-ticker.C:
+			stats.Skipped = int(atomic.LoadInt64(&skipped))
+			if err := i.saveCheckpoint(checkpoint); err != nil {
+				logger.WithError(err).Error("Could not save import checkpoint")
+			}
+			logProgress(stats, start)
+		}
+	}
+}
+
+func (i *Importer) importLine(line importLine) BulkWriteResult {
+	if len(bytes.TrimSpace(line.data)) == 0 {
+		return BulkWriteResult{Line: line.number}
+	}
+	return writeBulkLine(context.Background(), i.ConceptsService, line.data, line.number, transactionidutils.NewTransactionID())
+}
+
+//Read the lines of each file in turn, waiting for the earlier lines with any of the same uuids to be written before
+//handing each line to the workers
+func (i *Importer) readFiles(files []string, resumeFrom importCheckpoint, inFlight *importGate, lines chan<- importLine, stop <-chan struct{}, skipped *int64) error {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+
+		reader := bufio.NewReader(f)
+		for number := 1; ; number++ {
+			data, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				f.Close()
+				return err
+			}
+			if err == io.EOF && len(data) == 0 {
+				break
+			}
+
+			if number <= resumeFrom[file] {
+				atomic.AddInt64(skipped, 1)
+			} else {
+				line := importLine{file, number, data, importLineUUIDs(data)}
+				inFlight.acquire(line.uuids)
+				select {
+				case lines <- line:
+				case <-stop:
+					inFlight.release(line.uuids)
+					f.Close()
+					return nil
+				}
+			}
+
+			if err == io.EOF {
+				break
+			}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+//The prefUUID and source uuids of the concept on the line, or none if it isn't a concept, in which case it fails
+//without writing anything
+func importLineUUIDs(data []byte) []string {
+	var concept struct {
+		PrefUUID              string `json:"prefUUID"`
+		SourceRepresentations []struct {
+			UUID string `json:"uuid"`
+		} `json:"sourceRepresentations"`
+	}
+	if err := json.Unmarshal(data, &concept); err != nil {
+		return nil
+	}
+
+	var uuids []string
+	if concept.PrefUUID != "" {
+		uuids = append(uuids, concept.PrefUUID)
+	}
+	for _, source := range concept.SourceRepresentations {
+		if source.UUID != "" && source.UUID != concept.PrefUUID {
+			uuids = append(uuids, source.UUID)
+		}
+	}
+	return uuids
+}
+
+//The uuids of the lines being written, which a line waits for before it is written
+type importGate struct {
+	sync.Mutex
+	released *sync.Cond
+	uuids    map[string]bool
+}
+
+func newImportGate() *importGate {
+	g := &importGate{uuids: map[string]bool{}}
+	g.released = sync.NewCond(g)
+	return g
+}
+
+//Wait until none of the uuids are being written, then hold them
+func (g *importGate) acquire(uuids []string) {
+	g.Lock()
+	defer g.Unlock()
+	for g.anyHeld(uuids) {
+		g.released.Wait()
+	}
+	for _, uuid := range uuids {
+		g.uuids[uuid] = true
+	}
+}
+
+func (g *importGate) release(uuids []string) {
+	g.Lock()
+	defer g.Unlock()
+	for _, uuid := range uuids {
+		delete(g.uuids, uuid)
+	}
+	g.released.Broadcast()
+}
+
+func (g *importGate) anyHeld(uuids []string) bool {
+	for _, uuid := range uuids {
+		if g.uuids[uuid] {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Importer) loadCheckpoint() (importCheckpoint, error) {
+	checkpoint := importCheckpoint{}
+	if i.CheckpointFile == "" {
+		return checkpoint, nil
+	}
+
+	data, err := ioutil.ReadFile(i.CheckpointFile)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, errors.New("Invalid import checkpoint file: " + err.Error())
+	}
+	return checkpoint, nil
+}
+
+//The checkpoint is replaced rather than rewritten, so that an import killed while saving it can still be resumed
+func (i *Importer) saveCheckpoint(checkpoint importCheckpoint) error {
+	if i.CheckpointFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := i.CheckpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, i.CheckpointFile)
+}
+
+func logProgress(stats ImportStats, start time.Time) {
+	elapsed := time.Since(start)
+	logger.Infof("Imported %d concepts, %d failed and %d skipped from checkpoint in %s (%.1f concepts/s)",
+		stats.Written, stats.Failed, stats.Skipped, elapsed.Round(time.Second), float64(stats.Written+stats.Failed)/elapsed.Seconds())
+}
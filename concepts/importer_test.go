@@ -0,0 +1,182 @@
+package concepts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingConceptService struct {
+	mockConceptService
+	sync.Mutex
+	written []string
+}
+
+func newRecordingConceptService(write func(uuid string) error) *recordingConceptService {
+	s := &recordingConceptService{}
+	s.decodeJSON = func(decoder *json.Decoder) (interface{}, string, error) {
+		concept := AggregatedConcept{}
+		err := decoder.Decode(&concept)
+		return concept, concept.PrefUUID, err
+	}
+	s.write = func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+		uuid := thing.(AggregatedConcept).PrefUUID
+		if err := write(uuid); err != nil {
+			return nil, err
+		}
+		s.Lock()
+		defer s.Unlock()
+		s.written = append(s.written, uuid)
+		return ConceptChanges{UpdatedIds: []string{uuid}}, nil
+	}
+	return s
+}
+
+func (s *recordingConceptService) writtenUUIDs() []string {
+	s.Lock()
+	defer s.Unlock()
+	sort.Strings(s.written)
+	return s.written
+}
+
+func writeImportFile(t *testing.T, dir string, name string, uuids ...string) string {
+	var lines []string
+	for _, uuid := range uuids {
+		if uuid == "" || strings.HasPrefix(uuid, "{") {
+			lines = append(lines, uuid)
+			continue
+		}
+		lines = append(lines, `{"prefUUID":"`+uuid+`"}`)
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first := writeImportFile(t, dir, "first.ndjson", "1", "", "2", "{\"prefUUID\":")
+	second := writeImportFile(t, dir, "second.ndjson", "3", "4")
+	service := newRecordingConceptService(func(uuid string) error {
+		if uuid == "4" {
+			return requestError{"TEST invalid REQUEST"}
+		}
+		return nil
+	})
+
+	importer := Importer{ConceptsService: service, Workers: 3}
+	stats, err := importer.Import([]string{first, second})
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Written: 3, Failed: 2}, stats)
+	assert.Equal(t, []string{"1", "2", "3"}, service.writtenUUIDs())
+}
+
+func TestImportResumesFromCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := writeImportFile(t, dir, "concepts.ndjson", "1", "2", "3", "4", "5")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	unavailable := true
+	service := newRecordingConceptService(func(uuid string) error {
+		if uuid == "3" && unavailable {
+			return errors.New("TEST failing to CONNECT")
+		}
+		return nil
+	})
+
+	importer := Importer{ConceptsService: service, Workers: 1, CheckpointFile: checkpoint}
+	stats, err := importer.Import([]string{file})
+	assert.EqualError(t, err, "Import stopped at line 3 of "+file+": TEST failing to CONNECT")
+	// lines already handed to a worker are still written, but none after the failed line are checkpointed
+	assert.True(t, stats.Written >= 2, "Lines before the failed line should have been written")
+	assert.Equal(t, 0, stats.Failed)
+
+	saved, err := ioutil.ReadFile(checkpoint)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"`+file+`":2}`, string(saved))
+
+	unavailable = false
+	stats, err = importer.Import([]string{file})
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Written: 3, Skipped: 2}, stats)
+	assert.Subset(t, service.writtenUUIDs(), []string{"1", "2", "3", "4", "5"})
+
+	saved, err = ioutil.ReadFile(checkpoint)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"`+file+`":5}`, string(saved))
+}
+
+func TestImportWritesLinesWithTheSameUUIDsInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	//a lone concept, a concordance which includes it, and an unrelated concept
+	file := writeImportFile(t, dir, "concepts.ndjson",
+		"1",
+		`{"prefUUID":"2","sourceRepresentations":[{"uuid":"2"},{"uuid":"1"}]}`,
+		"3",
+	)
+	service := newRecordingConceptService(func(uuid string) error {
+		if uuid == "1" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil
+	})
+
+	importer := Importer{ConceptsService: service, Workers: 3}
+	stats, err := importer.Import([]string{file})
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Written: 3}, stats)
+
+	service.Lock()
+	defer service.Unlock()
+	assert.Equal(t, []string{"1", "2"}, filterStrings(service.written, "1", "2"), "The concordance should wait for the lone concept it includes to be written")
+}
+
+//The strings which are one of those given, in the order they are in
+func filterStrings(strs []string, keep ...string) []string {
+	var filtered []string
+	for _, s := range strs {
+		if stringInArr(s, keep) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+func TestImportLineUUIDs(t *testing.T) {
+	assert.Equal(t, []string{"2", "1"}, importLineUUIDs([]byte(`{"prefUUID":"2","sourceRepresentations":[{"uuid":"2"},{"uuid":"1"}]}`)))
+	assert.Equal(t, []string{"1"}, importLineUUIDs([]byte(`{"prefUUID":"1"}`)))
+	assert.Empty(t, importLineUUIDs([]byte(`{"prefUUID":`)))
+}
+
+func TestImportInvalidCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	assert.NoError(t, ioutil.WriteFile(checkpoint, []byte("not a checkpoint"), 0644))
+
+	importer := Importer{ConceptsService: newRecordingConceptService(func(uuid string) error { return nil }), CheckpointFile: checkpoint}
+	_, err = importer.Import([]string{writeImportFile(t, dir, "concepts.ndjson", "1")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package concepts
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+//Mutexes keyed by uuid, which only exist while they are held or waited for
+type keyedLocks struct {
+	sync.Mutex
+	locks map[string]*keyedLock
+}
+
+//Held by sending to sem and released by receiving from it, so that waiting for it can be given up on
+type keyedLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+func newKeyedLocks() *keyedLocks {
+	return &keyedLocks{locks: map[string]*keyedLock{}}
+}
+
+//Lock all the keys, in sorted order so that two callers locking overlapping keys can't deadlock, returning a
+//function which unlocks them all again. If the context is done before they are all locked, those that were are
+//unlocked and its error is returned.
+func (k *keyedLocks) lock(ctx context.Context, keys []string) (func(), error) {
+	keys = sortedUniqueKeys(keys)
+	held := make([]*keyedLock, 0, len(keys))
+	unlock := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			<-held[i].sem
+			k.release(keys[i], held[i])
+		}
+	}
+
+	for _, key := range keys {
+		k.Lock()
+		l, ok := k.locks[key]
+		if !ok {
+			l = &keyedLock{sem: make(chan struct{}, 1)}
+			k.locks[key] = l
+		}
+		l.refs++
+		k.Unlock()
+
+		select {
+		case l.sem <- struct{}{}:
+			held = append(held, l)
+		case <-ctx.Done():
+			k.release(key, l)
+			unlock()
+			return nil, ctx.Err()
+		}
+	}
+	return unlock, nil
+}
+
+//Stop holding or waiting for the lock, removing it once no one else is
+func (k *keyedLocks) release(key string, l *keyedLock) {
+	k.Lock()
+	defer k.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, key)
+	}
+}
+
+func sortedUniqueKeys(keys []string) []string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+//Whether every key is in held
+func keysHeld(keys []string, held []string) bool {
+	for _, key := range keys {
+		if key != "" && !stringInArr(key, held) {
+			return false
+		}
+	}
+	return true
+}
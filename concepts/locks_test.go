@@ -0,0 +1,88 @@
+package concepts
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedLocksSerialiseOverlappingKeys(t *testing.T) {
+	locks := newKeyedLocks()
+	unlock := mustLock(t, locks, []string{"b", "a"})
+
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		mustLock(t, locks, []string{"c", "b"})()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Overlapping keys should not be locked while they are held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// keys that don't overlap can be locked at the same time
+	mustLock(t, locks, []string{"c", "d"})()
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Overlapping keys should be locked once they have been released")
+	}
+	assert.Empty(t, locks.locks, "Locks that are no longer held should be removed")
+}
+
+func TestKeyedLocksDoNotDeadlock(t *testing.T) {
+	locks := newKeyedLocks()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			mustLock(t, locks, []string{"a", "b", "a", ""})()
+		}()
+		go func() {
+			defer wg.Done()
+			mustLock(t, locks, []string{"b", "a"})()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Locking the same keys in a different order should not deadlock")
+	}
+	assert.Empty(t, locks.locks, "Locks that are no longer held should be removed")
+}
+
+func TestKeyedLocksGiveUpWhenTheContextIsDone(t *testing.T) {
+	locks := newKeyedLocks()
+	unlock := mustLock(t, locks, []string{"b"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := locks.lock(ctx, []string{"a", "b", "c"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the key locked before giving up is unlocked again
+	mustLock(t, locks, []string{"a"})()
+
+	unlock()
+	assert.Empty(t, locks.locks, "Locks that are no longer held or waited for should be removed")
+}
+
+func mustLock(t *testing.T, locks *keyedLocks, keys []string) func() {
+	unlock, err := locks.lock(context.Background(), keys)
+	assert.NoError(t, err)
+	return unlock
+}
@@ -0,0 +1,696 @@
+package concepts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+)
+
+//Properties of the canonical node that are read back as the properties of the concept
+var canonicalReadProperties = []string{
+	"aggregateHash", "aggregateHashVersion", "aliases", "descriptionXML", "emailAddress", "facebookPage", "figiCode",
+	"imageUrl", "inceptionDate", "inceptionDateEpoch", "prefLabel", "prefUUID", "scopeNote", "shortLabel", "strapline",
+	"terminationDate", "terminationDateEpoch", "twitterHandle", "properName", "shortName", "tradeNames", "formerNames",
+	"countryCode", "countryOfIncorporation", "countryOfOperations", "countryOfRisk", "postalCode", "yearFounded",
+	"leiCode", "isDeprecated", "salutation", "birthYear", "iso31661",
+}
+
+//Properties of a source node that are read back as the properties of the source
+var sourceReadProperties = []string{
+	"authority", "authorityValue", "figiCode", "lastModifiedEpoch", "prefLabel", "uuid", "isDeprecated",
+}
+
+//Relationships of a source node read back as lists of the uuids of the things they are to
+var sourceReadRelationshipLists = map[string]string{
+	"HAS_BROADER":   "broaderUUIDs",
+	"SUPERSEDED_BY": "supersededByUUIDs",
+	"HAS_PARENT":    "parentUUIDs",
+	"IS_RELATED_TO": "relatedUUIDs",
+	"IMPLIED_BY":    "impliedByUUIDs",
+	"HAS_FOCUS":     "hasFocusUUIDs",
+}
+
+//Relationships of a source node read back as the uuid of the first thing they are to
+var sourceReadRelationships = map[string]string{
+	"ISSUED_BY":                "issuedBy",
+	"HAS_ORGANISATION":         "organisationUUID",
+	"HAS_MEMBER":               "personUUID",
+	"SUB_ORGANISATION_OF":      "parentOrganisation",
+	"COUNTRY_OF_INCORPORATION": "countryOfIncorporationUUID",
+	"COUNTRY_OF_OPERATIONS":    "countryOfOperationsUUID",
+	"COUNTRY_OF_RISK":          "countryOfRiskUUID",
+}
+
+//MemoryConceptStore - store of concepts held in memory, for local development and tests. Canonical nodes, source nodes
+//and the things they have relationships to are kept as they would be stored in Neo4j, and are read and written with the
+//same equivalence, identifier and relationship semantics. A write is applied only if its guards pass, all at once.
+type MemoryConceptStore struct {
+	sync.RWMutex
+	//Canonical nodes by prefUUID
+	canonicals map[string]*storedNode
+	//Source nodes, and the things that relationships are to, by uuid. Their EQUIVALENT_TO relationships are to the
+	//prefUUID of their canonical node.
+	things   map[string]*storedNode
+	outbox   []memoryOutboxEvent
+	sequence int64
+}
+
+//Writes are committed one at a time, so events are given ids in the order they were written and need no settle delay
+type memoryOutboxEvent struct {
+	cursor  eventCursor
+	payload string
+}
+
+//NewMemoryConceptStore - an empty in-memory store
+func NewMemoryConceptStore() *MemoryConceptStore {
+	return &MemoryConceptStore{
+		canonicals: map[string]*storedNode{},
+		things:     map[string]*storedNode{},
+	}
+}
+
+//The memory store starts empty every time, so has no schema or data to migrate
+func (s *MemoryConceptStore) migrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+func (s *MemoryConceptStore) migrate(ctx context.Context) ([]MigrationStatus, error) {
+	return nil, nil
+}
+
+func (s *MemoryConceptStore) check() error {
+	return nil
+}
+
+func (s *MemoryConceptStore) readConcept(ctx context.Context, prefUUID string, transID string) (AggregatedConcept, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return AggregatedConcept{}, false, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	result, found := s.aggregate(prefUUID)
+	if !found {
+		return AggregatedConcept{}, false, nil
+	}
+	aggregatedConcept, err := buildAggregatedConcept(result, transID)
+	if err != nil {
+		return AggregatedConcept{}, false, err
+	}
+	return aggregatedConcept, true, nil
+}
+
+func (s *MemoryConceptStore) exportConcepts(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	var prefUUIDs []string
+	for prefUUID, canonical := range s.canonicals {
+		if prefUUID > after && stringInArr(conceptType, canonical.Labels) {
+			prefUUIDs = append(prefUUIDs, prefUUID)
+		}
+	}
+	sort.Strings(prefUUIDs)
+	if len(prefUUIDs) > limit {
+		prefUUIDs = prefUUIDs[:limit]
+	}
+
+	concepts := make([]AggregatedConcept, 0, len(prefUUIDs))
+	for _, prefUUID := range prefUUIDs {
+		result, found := s.aggregate(prefUUID)
+		if !found {
+			continue
+		}
+		aggregatedConcept, err := buildAggregatedConcept(result, transID)
+		if err != nil {
+			// a single concept with inconsistent types should not stop the rest being exported
+			logger.WithError(err).WithTransactionID(transID).WithUUID(prefUUID).Warn("Skipping concept which could not be exported")
+			continue
+		}
+		concepts = append(concepts, aggregatedConcept)
+	}
+
+	next := ""
+	if len(prefUUIDs) == limit {
+		next = prefUUIDs[len(prefUUIDs)-1]
+	}
+	return concepts, next, nil
+}
+
+func (s *MemoryConceptStore) readEquivalence(ctx context.Context, sourceUUID string, transID string) ([]equivalenceResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+	return s.equivalence(sourceUUID), nil
+}
+
+func (s *MemoryConceptStore) readIssued(ctx context.Context, issuerUUID string, transID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.things[issuerUUID]; !ok {
+		return nil, nil
+	}
+	var fiUUIDs []string
+	for uuid, thing := range s.things {
+		if hasRelationship(thing, "ISSUED_BY", issuerUUID) {
+			fiUUIDs = append(fiUUIDs, uuid)
+		}
+	}
+	sort.Strings(fiUUIDs)
+	return fiUUIDs, nil
+}
+
+func (s *MemoryConceptStore) readDependants(ctx context.Context, prefUUID string, transID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, exists := s.canonicals[prefUUID]; !exists {
+		return nil, nil
+	}
+	sources := map[string]bool{}
+	for uuid, thing := range s.things {
+		if hasRelationship(thing, "EQUIVALENT_TO", prefUUID) {
+			sources[uuid] = true
+		}
+	}
+
+	var dependants []string
+	for uuid, thing := range s.things {
+		if sources[uuid] {
+			continue
+		}
+		for _, rel := range thing.Relationships {
+			if rel.Type != "EQUIVALENT_TO" && sources[rel.UUID] {
+				dependants = append(dependants, uuid)
+				break
+			}
+		}
+	}
+	sort.Strings(dependants)
+	return dependants, nil
+}
+
+func (s *MemoryConceptStore) readIdentified(ctx context.Context, authority string, value string, transID string) ([]identifiedConcept, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	label, ok := authorityToIdentifierLabelMap[authority]
+	if !ok {
+		label, ok = naturalKeyToIdentifierLabelMap[authority]
+	}
+
+	var results []identifiedConcept
+	if !ok {
+		for prefUUID, canonical := range s.canonicals {
+			if stringInArr(naturalKeyToLabelMap[authority], canonical.Labels) && sameValue(canonical.Properties[authority], value) {
+				results = append(results, identifiedConcept{UUID: prefUUID, PrefUUID: prefUUID, Types: append([]string{}, canonical.Labels...)})
+			}
+		}
+	} else {
+		identifier := nodeIdentifier{Label: label, Value: value}
+		for uuid, thing := range s.things {
+			if !stringInArr("Concept", thing.Labels) || !hasIdentifier(thing, identifier) {
+				continue
+			}
+			prefUUIDs := s.equivalentTo(thing)
+			if len(prefUUIDs) == 0 {
+				results = append(results, identifiedConcept{UUID: uuid, Types: append([]string{}, thing.Labels...)})
+			}
+			for _, prefUUID := range prefUUIDs {
+				results = append(results, identifiedConcept{UUID: uuid, PrefUUID: prefUUID, Types: append([]string{}, s.canonicals[prefUUID].Labels...)})
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].UUID < results[j].UUID
+	})
+	return results, nil
+}
+
+//Check the guards of the write against the stored nodes, then apply it in the same order as the Neo4j batch. Nothing
+//waits once the store is locked, so the context is only checked before the write is started.
+func (s *MemoryConceptStore) write(ctx context.Context, w conceptWrite, transID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if reason := s.failedGuard(w); reason != "" {
+		logger.WithTransactionID(transID).WithUUID(w.prefUUID).Debugf("Write not applied to the memory store: %s", reason)
+		return rwapi.ConstraintOrTransactionError{Message: "Error with a query inside a transaction.", Details: []string{reason}}
+	}
+
+	var payloads []string
+	for _, event := range w.events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			logger.WithError(err).WithTransactionID(transID).WithUUID(w.prefUUID).Error("Error encoding events for the outbox")
+			return err
+		}
+		payloads = append(payloads, string(payload))
+	}
+
+	for _, concept := range w.unconcorded {
+		if thing, ok := s.things[concept.UUID]; ok {
+			removeRelationship(thing, "EQUIVALENT_TO:"+w.prefUUID)
+		}
+	}
+	for _, prefUUID := range w.deletedCanonicals {
+		delete(s.canonicals, prefUUID)
+		for _, thing := range s.things {
+			removeRelationship(thing, "EQUIVALENT_TO:"+prefUUID)
+		}
+	}
+	for _, concept := range w.unconcorded {
+		s.writeUnconcordedCanonical(concept)
+	}
+
+	if w.concept != nil {
+		s.writeConcept(*w.concept)
+		for _, fiUUID := range w.reissued {
+			if fi, ok := s.things[fiUUID]; ok {
+				if _, ok := s.things[w.concept.IssuedBy]; ok {
+					removeRelationship(fi, "ISSUED_BY:"+w.concept.IssuedBy)
+				}
+			}
+		}
+	}
+
+	createdAt := time.Now().UnixNano() / int64(time.Millisecond)
+	if len(s.outbox) > 0 && s.outbox[len(s.outbox)-1].cursor.createdAt > createdAt {
+		createdAt = s.outbox[len(s.outbox)-1].cursor.createdAt
+	}
+	for _, payload := range payloads {
+		s.sequence++
+		s.outbox = append(s.outbox, memoryOutboxEvent{cursor: eventCursor{createdAt, fmt.Sprintf("%020d", s.sequence)}, payload: payload})
+	}
+	return nil
+}
+
+func (s *MemoryConceptStore) readEvents(ctx context.Context, after eventCursor, limit int, transID string) ([]OutboxEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	events := []OutboxEvent{}
+	for _, outboxEvent := range s.outbox {
+		if len(events) >= limit {
+			break
+		}
+		if !outboxEvent.cursor.after(after) {
+			continue
+		}
+		event, err := decodeOutboxEvent(outboxEvent.cursor, outboxEvent.payload, transID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *MemoryConceptStore) acknowledgeEvents(ctx context.Context, upTo eventCursor, transID string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	var remaining []memoryOutboxEvent
+	for _, outboxEvent := range s.outbox {
+		if outboxEvent.cursor.after(upTo) {
+			remaining = append(remaining, outboxEvent)
+		}
+	}
+	acknowledged := len(s.outbox) - len(remaining)
+	s.outbox = remaining
+	return acknowledged, nil
+}
+
+func (s *MemoryConceptStore) expireEvents(ctx context.Context, olderThan time.Duration, transID string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	expiredBefore := time.Now().Add(-olderThan).UnixNano() / int64(time.Millisecond)
+	var remaining []memoryOutboxEvent
+	for _, outboxEvent := range s.outbox {
+		if outboxEvent.cursor.createdAt >= expiredBefore {
+			remaining = append(remaining, outboxEvent)
+		}
+	}
+	expired := len(s.outbox) - len(remaining)
+	s.outbox = remaining
+	return expired, nil
+}
+
+//The reason the write's guards fail, as the guard queries of the Neo4j batch would, or an empty string if they pass
+func (s *MemoryConceptStore) failedGuard(w conceptWrite) string {
+	canonical, exists := s.canonicals[w.prefUUID]
+	aggregateHash := ""
+	if exists {
+		aggregateHash, _ = canonical.Properties["aggregateHash"].(string)
+	}
+	if len(s.sourcesOf(w.prefUUID)) != w.sourceCount || aggregateHash != w.aggregateHash {
+		return fmt.Sprintf("concordance of %s has changed since it was read", w.prefUUID)
+	}
+
+	for sourceUUID, read := range w.transferred {
+		equivalence := s.equivalence(sourceUUID)
+		if len(equivalence) != len(read) || (len(read) == 1 && (equivalence[0].PrefUUID != read[0].PrefUUID || equivalence[0].Equivalence != read[0].Equivalence)) {
+			return fmt.Sprintf("equivalence of source %s has changed since it was read", sourceUUID)
+		}
+	}
+
+	if w.precondition != nil {
+		if stringInArr("*", w.precondition.IfNoneMatch) {
+			if exists {
+				return fmt.Sprintf("concept %s already exists", w.prefUUID)
+			}
+		} else if !w.precondition.isSatisfiedBy(exists, aggregateHash) {
+			return fmt.Sprintf("precondition of %s is no longer satisfied", w.prefUUID)
+		}
+	}
+	return ""
+}
+
+//Give a source removed from a concordance a canonical node of its own, with the source's properties
+func (s *MemoryConceptStore) writeUnconcordedCanonical(concept Concept) {
+	thing, ok := s.things[concept.UUID]
+	if !ok {
+		return
+	}
+	canonical, ok := s.canonicals[concept.UUID]
+	if !ok {
+		canonical = &storedNode{PrefUUID: concept.UUID, Labels: []string{"Thing"}}
+		s.canonicals[concept.UUID] = canonical
+	}
+	canonical.Properties = storedProperties(setProps(concept, concept.UUID, false))
+	addLabels(canonical, strings.Split(getAllLabels(concept.Type), ":"))
+	if !hasRelationship(thing, "EQUIVALENT_TO", concept.UUID) {
+		thing.Relationships = append(thing.Relationships, nodeRelationship{Type: "EQUIVALENT_TO", UUID: concept.UUID})
+	}
+}
+
+//Write the concept's canonical node and source nodes, changing only what differs from the stored nodes, as the Neo4j
+//write plan does. Nodes are written before the relationships and identifiers added to them.
+func (s *MemoryConceptStore) writeConcept(aggregatedConcept AggregatedConcept) {
+	s.writeNode(s.canonicals, canonicalNodeState(aggregatedConcept))
+
+	var sources []nodeState
+	for _, sourceConcept := range aggregatedConcept.SourceRepresentations {
+		sources = append(sources, sourceNodeState(sourceConcept, aggregatedConcept.PrefUUID))
+	}
+
+	var added []nodeState
+	for _, source := range sources {
+		added = append(added, s.writeNode(s.things, source))
+	}
+	for i, source := range sources {
+		thing := s.things[source.id]
+		for _, rel := range added[i].relationships {
+			s.addRelationship(thing, rel)
+		}
+		for _, identifier := range added[i].identifiers {
+			addIdentifier(thing, identifier)
+		}
+	}
+}
+
+//Write the labels and properties of the node, removing the relationships and identifiers it no longer has, and
+//return the relationships and identifiers to be added to it
+func (s *MemoryConceptStore) writeNode(nodes map[string]*storedNode, node nodeState) nodeState {
+	stored, ok := nodes[node.id]
+	if !ok {
+		stored = &storedNode{Labels: []string{"Thing"}, Properties: storedProperties(node.props)}
+		addLabels(stored, node.labels)
+		if node.key == "prefUUID" {
+			stored.PrefUUID = node.id
+		} else {
+			stored.UUID = node.id
+		}
+		nodes[node.id] = stored
+		return nodeState{relationships: node.relationships, identifiers: node.identifiers}
+	}
+
+	diff := diffNode(node, stored)
+	for _, id := range diff.relationshipsToRemove {
+		removeRelationship(stored, id)
+	}
+	for _, id := range diff.identifiersToRemove {
+		var identifiers []nodeIdentifier
+		for _, identifier := range stored.Identifiers {
+			if identifier.id() != id {
+				identifiers = append(identifiers, identifier)
+			}
+		}
+		stored.Identifiers = identifiers
+	}
+	var labels []string
+	for _, label := range stored.Labels {
+		if !stringInArr(label, diff.labelsToRemove) {
+			labels = append(labels, label)
+		}
+	}
+	stored.Labels = labels
+	addLabels(stored, diff.labelsToAdd)
+	for _, key := range diff.propsToRemove {
+		delete(stored.Properties, key)
+	}
+	for key, value := range storedProperties(diff.propsToSet) {
+		stored.Properties[key] = value
+	}
+	return nodeState{relationships: diff.relationshipsToAdd, identifiers: diff.identifiersToAdd}
+}
+
+//Add the relationship to the thing, unless it already has it, creating the thing it is to if need be. Relationships
+//only ever made between concepts are only added from a concept, and the things that relationships other than
+//equivalence, roles and issuers are to are identified by their uuid.
+func (s *MemoryConceptStore) addRelationship(thing *storedNode, rel nodeRelationship) {
+	switch {
+	case rel.Type == "EQUIVALENT_TO":
+		if _, ok := s.canonicals[rel.UUID]; !ok {
+			return
+		}
+	case stringInArr(rel.Type, conceptRelationshipTypes):
+		if !stringInArr("Concept", thing.Labels) {
+			return
+		}
+	}
+
+	if rel.Type != "EQUIVALENT_TO" {
+		target, ok := s.things[rel.UUID]
+		if !ok {
+			target = &storedNode{UUID: rel.UUID, Labels: []string{"Thing"}, Properties: map[string]interface{}{"uuid": rel.UUID}}
+			s.things[rel.UUID] = target
+		}
+		if rel.Type != "HAS_ROLE" && rel.Type != "ISSUED_BY" {
+			addIdentifier(target, nodeIdentifier{Label: authorityToIdentifierLabelMap["UPP"], Value: rel.UUID})
+		}
+	}
+	if !hasRelationship(thing, rel.Type, rel.UUID) {
+		if len(rel.Properties) > 0 {
+			rel.Properties = storedProperties(rel.Properties)
+		} else {
+			rel.Properties = nil
+		}
+		thing.Relationships = append(thing.Relationships, rel)
+	}
+}
+
+//The canonical node and its sources, as read by readConceptReturnClause. A canonical node without sources isn't read.
+func (s *MemoryConceptStore) aggregate(prefUUID string) (neoAggregatedConcept, bool) {
+	canonical, ok := s.canonicals[prefUUID]
+	sourceNodes := s.sourcesOf(prefUUID)
+	if !ok || len(sourceNodes) == 0 {
+		return neoAggregatedConcept{}, false
+	}
+
+	row := map[string]interface{}{}
+	for _, key := range canonicalReadProperties {
+		if value, ok := canonical.Properties[key]; ok {
+			row[key] = value
+		}
+	}
+	row["types"] = canonical.Labels
+	row["membershipRoles"] = []interface{}{}
+
+	var sources []map[string]interface{}
+	for _, sourceNode := range sourceNodes {
+		source := map[string]interface{}{"types": sourceNode.Labels}
+		for _, key := range sourceReadProperties {
+			if value, ok := sourceNode.Properties[key]; ok {
+				source[key] = value
+			}
+		}
+		var roles []interface{}
+		for _, rel := range sourceNode.Relationships {
+			if key, ok := sourceReadRelationshipLists[rel.Type]; ok {
+				uuids, _ := source[key].([]string)
+				source[key] = append(uuids, rel.UUID)
+			}
+			if key, ok := sourceReadRelationships[rel.Type]; ok {
+				if _, set := source[key]; !set {
+					source[key] = rel.UUID
+				}
+			}
+			if rel.Type == "HAS_ROLE" {
+				role := map[string]interface{}{"membershipRoleUUID": rel.UUID}
+				for key, value := range rel.Properties {
+					role[key] = value
+				}
+				roles = append(roles, role)
+			}
+		}
+		source["membershipRoles"] = roles
+
+		for _, key := range []string{"issuedBy", "organisationUUID", "personUUID"} {
+			if _, set := row[key]; !set && source[key] != nil {
+				row[key] = source[key]
+			}
+		}
+		if len(roles) > 0 && len(row["membershipRoles"].([]interface{})) == 0 {
+			row["membershipRoles"] = roles
+		}
+		sources = append(sources, source)
+	}
+	row["sourceRepresentations"] = sources
+
+	result := neoAggregatedConcept{}
+	data, err := json.Marshal(row)
+	if err == nil {
+		err = json.Unmarshal(data, &result)
+	}
+	if err != nil {
+		logger.WithError(err).WithUUID(prefUUID).Error("Concept in the memory store could not be read")
+		return neoAggregatedConcept{}, false
+	}
+	return result, true
+}
+
+//The source nodes equivalent to the canonical node with the prefUUID, ordered by uuid
+func (s *MemoryConceptStore) sourcesOf(prefUUID string) []*storedNode {
+	if _, ok := s.canonicals[prefUUID]; !ok {
+		return nil
+	}
+	var sources []*storedNode
+	for _, thing := range s.things {
+		if hasRelationship(thing, "EQUIVALENT_TO", prefUUID) {
+			sources = append(sources, thing)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].UUID < sources[j].UUID
+	})
+	return sources
+}
+
+//The prefUUIDs of the canonical nodes the thing is equivalent to
+func (s *MemoryConceptStore) equivalentTo(thing *storedNode) []string {
+	var prefUUIDs []string
+	for _, rel := range thing.Relationships {
+		if _, ok := s.canonicals[rel.UUID]; ok && rel.Type == "EQUIVALENT_TO" {
+			prefUUIDs = append(prefUUIDs, rel.UUID)
+		}
+	}
+	return prefUUIDs
+}
+
+//The source node with the uuid, if it exists, with each canonical node it is equivalent to
+func (s *MemoryConceptStore) equivalence(sourceUUID string) []equivalenceResult {
+	thing, ok := s.things[sourceUUID]
+	if !ok {
+		return nil
+	}
+	authority, _ := thing.Properties["authority"].(string)
+	result := equivalenceResult{
+		SourceUUID: sourceUUID,
+		Types:      append([]string{}, thing.Labels...),
+		Authority:  authority,
+	}
+
+	var results []equivalenceResult
+	for _, prefUUID := range s.equivalentTo(thing) {
+		equivalent := result
+		equivalent.PrefUUID = prefUUID
+		equivalent.Equivalence = len(s.sourcesOf(prefUUID))
+		results = append(results, equivalent)
+	}
+	if len(results) == 0 {
+		results = append(results, result)
+	}
+	return results
+}
+
+func hasRelationship(thing *storedNode, relationshipType string, uuid string) bool {
+	return containsRelationship(thing.Relationships, relationshipType+":"+uuid)
+}
+
+func removeRelationship(thing *storedNode, id string) {
+	var relationships []nodeRelationship
+	for _, rel := range thing.Relationships {
+		if rel.id() != id {
+			relationships = append(relationships, rel)
+		}
+	}
+	thing.Relationships = relationships
+}
+
+func hasIdentifier(thing *storedNode, identifier nodeIdentifier) bool {
+	for _, stored := range thing.Identifiers {
+		if stored.id() == identifier.id() {
+			return true
+		}
+	}
+	return false
+}
+
+func addIdentifier(thing *storedNode, identifier nodeIdentifier) {
+	if !hasIdentifier(thing, identifier) {
+		thing.Identifiers = append(thing.Identifiers, identifier)
+	}
+}
+
+func addLabels(node *storedNode, labels []string) {
+	for _, label := range labels {
+		if !stringInArr(label, node.Labels) {
+			node.Labels = append(node.Labels, label)
+		}
+	}
+}
+
+//The properties as they would be read back from Neo4j
+func storedProperties(props map[string]interface{}) map[string]interface{} {
+	stored, ok := storedValue(props).(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	return stored
+}
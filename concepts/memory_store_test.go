@@ -0,0 +1,112 @@
+package concepts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreFailsWritesWhoseGuardsFail(t *testing.T) {
+	store := NewMemoryConceptStore()
+	concept := AggregatedConcept{
+		PrefUUID:  basicConceptUUID,
+		PrefLabel: "The Best Label",
+		Type:      "Brand",
+		SourceRepresentations: []Concept{{
+			UUID:           basicConceptUUID,
+			PrefLabel:      "The Best Label",
+			Type:           "Brand",
+			Authority:      "TME",
+			AuthorityValue: "1234",
+		}},
+		AggregatedHash: "1",
+	}
+	assert.NoError(t, store.write(context.Background(), conceptWrite{prefUUID: basicConceptUUID, concept: &concept}, "test_tid"))
+
+	tests := []struct {
+		name string
+		w    conceptWrite
+	}{
+		{"Concordance changed", conceptWrite{prefUUID: basicConceptUUID, concept: &concept}},
+		{"Aggregate hash changed", conceptWrite{prefUUID: basicConceptUUID, sourceCount: 1, aggregateHash: "2", concept: &concept}},
+		{"Source transferred", conceptWrite{prefUUID: anotherBasicConceptUUID, transferred: map[string][]equivalenceResult{basicConceptUUID: nil}}},
+		{"Concept created by another writer", conceptWrite{prefUUID: basicConceptUUID, sourceCount: 1, aggregateHash: "1", precondition: &Precondition{IfNoneMatch: []string{"*"}}}},
+		{"Precondition not satisfied", conceptWrite{prefUUID: basicConceptUUID, sourceCount: 1, aggregateHash: "1", precondition: &Precondition{IfMatch: []string{"2"}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.w.events = []Event{{ConceptUUID: test.w.prefUUID}}
+			assert.IsType(t, rwapi.ConstraintOrTransactionError{}, store.write(context.Background(), test.w, "test_tid"))
+
+			events, err := store.readEvents(context.Background(), eventCursor{}, 10, "test_tid")
+			assert.NoError(t, err)
+			assert.Empty(t, events, "The events of a failed write should not be added to the outbox")
+		})
+	}
+
+	read, found, err := store.readConcept(context.Background(), basicConceptUUID, "test_tid")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "1", read.AggregatedHash, "A failed write should not change the concept")
+}
+
+func TestMemoryStoreGivesUpWhenTheContextIsDone(t *testing.T) {
+	store := NewMemoryConceptStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Read", func() error { _, _, err := store.readConcept(ctx, basicConceptUUID, "test_tid"); return err }},
+		{"Export", func() error { _, _, err := store.exportConcepts(ctx, "Brand", "", 10, "test_tid"); return err }},
+		{"Read equivalence", func() error { _, err := store.readEquivalence(ctx, basicConceptUUID, "test_tid"); return err }},
+		{"Read issued", func() error { _, err := store.readIssued(ctx, basicConceptUUID, "test_tid"); return err }},
+		{"Read dependants", func() error { _, err := store.readDependants(ctx, basicConceptUUID, "test_tid"); return err }},
+		{"Read identified", func() error { _, err := store.readIdentified(ctx, "TME", "1234", "test_tid"); return err }},
+		{"Write", func() error { return store.write(ctx, conceptWrite{prefUUID: basicConceptUUID}, "test_tid") }},
+		{"Read events", func() error { _, err := store.readEvents(ctx, eventCursor{}, 10, "test_tid"); return err }},
+		{"Acknowledge events", func() error { _, err := store.acknowledgeEvents(ctx, eventCursor{}, "test_tid"); return err }},
+		{"Expire events", func() error { _, err := store.expireEvents(ctx, time.Hour, "test_tid"); return err }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, context.Canceled, test.call())
+		})
+	}
+}
+
+func TestMemoryStoreExportSkipsConceptsThatCantBeBuilt(t *testing.T) {
+	store := NewMemoryConceptStore()
+	for _, prefUUID := range []string{basicConceptUUID, anotherBasicConceptUUID} {
+		concept := AggregatedConcept{
+			PrefUUID:  prefUUID,
+			PrefLabel: "The Best Label",
+			Type:      "Brand",
+			SourceRepresentations: []Concept{{
+				UUID:           prefUUID,
+				PrefLabel:      "The Best Label",
+				Type:           "Brand",
+				Authority:      "TME",
+				AuthorityValue: prefUUID,
+			}},
+		}
+		assert.NoError(t, store.write(context.Background(), conceptWrite{prefUUID: prefUUID, concept: &concept}, "test_tid"))
+	}
+	first, second := basicConceptUUID, anotherBasicConceptUUID
+	if second < first {
+		first, second = second, first
+	}
+	//a canonical node of two types that aren't one a kind of the other
+	store.canonicals[first].Labels = append(store.canonicals[first].Labels, "Person")
+
+	concepts, next, err := store.exportConcepts(context.Background(), "Brand", "", 2, "test_tid")
+	assert.NoError(t, err)
+	assert.Len(t, concepts, 1, "A concept that can't be built should be skipped")
+	assert.Equal(t, second, concepts[0].PrefUUID)
+	assert.Equal(t, second, next, "A page with a skipped concept should still be followed by the next")
+}
@@ -0,0 +1,53 @@
+package concepts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+//Apply an RFC 7396 JSON merge patch to a JSON document
+func applyMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decodeJSONValue(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	patchValue, err := decodeJSONValue(patch)
+	if err != nil {
+		return nil, errors.New("Invalid merge patch: " + err.Error())
+	}
+
+	return json.Marshal(mergePatch(target, patchValue))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+func decodeJSONValue(data []byte) (interface{}, error) {
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,42 @@
+package concepts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	// examples from appendix A of RFC 7396
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+	}{
+		{"ReplaceValue", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"AddValue", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"RemoveValue", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"RemoveOneOfMany", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"ReplaceArray", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"ArrayReplacesValue", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"NestedObjects", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"ArraysAreReplaced", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"NonObjectPatch", `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{"NullValuesInsideNewObject", `{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{"NonObjectTarget", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"NestedNewObject", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{"NumbersArePreserved", `{"yearFounded":1951}`, `{"a":"b"}`, `{"a":"b","yearFounded":1951}`},
+	}
+
+	for _, test := range tests {
+		actual, err := applyMergePatch([]byte(test.doc), []byte(test.patch))
+		assert.NoError(t, err, test.name)
+		assert.JSONEq(t, test.expected, string(actual), test.name)
+	}
+}
+
+func TestApplyMergePatchInvalidPatch(t *testing.T) {
+	_, err := applyMergePatch([]byte(`{"a":"b"}`), []byte(`{"a":`))
+	assert.Error(t, err)
+}
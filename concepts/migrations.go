@@ -0,0 +1,460 @@
+package concepts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/jmcvetta/neoism"
+)
+
+const (
+	migrationLockName = "migrations"
+	//How long the lock is held for without being refreshed, which it is before each migration, so that the lock of a
+	//replica which stopped while applying migrations is taken over once it expires
+	migrationLockTTL          = 15 * time.Minute
+	migrationLockPollInterval = time.Second
+	migrationBatchSize        = 1000
+)
+
+//Constraints the migrations rely on, so that each migration is only recorded once and there is only ever one lock.
+//They are ensured before the lock is taken, as it is the constraint that stops two replicas creating a lock each.
+var migrationConstraints = map[string]string{
+	"SchemaMigration":     "version",
+	"SchemaMigrationLock": "name",
+}
+
+var errMigrationLockLost = errors.New("the migration lock expired and was taken by another replica")
+
+// MigrationStatus - a migration of the schema or data of the store, and when it was applied, if it has been
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   time.Time
+}
+
+// Applied - whether the migration has been applied
+func (m MigrationStatus) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+// MigrateUp - applies every migration of the store which is yet to be, in order of version, and returns those applied.
+// If another replica is applying them it waits for it to finish first, until the context is done.
+func (s *ConceptService) MigrateUp(ctx context.Context) ([]MigrationStatus, error) {
+	return s.store.migrate(ctx)
+}
+
+// MigrationStatus - every migration of the store, in order of version, with when each was applied
+func (s *ConceptService) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return s.store.migrationStatus(ctx)
+}
+
+//A versioned change to the schema or data of Neo4j. A migration is recorded by a :SchemaMigration node once it has been
+//applied, in a transaction of its own as Neo4j doesn't allow schema and data changes in the same one, so it must be
+//safe to apply again in case the replica stopped before recording it.
+type neo4jMigration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, s *neo4jConceptStore, transID string) error
+}
+
+//Migrations of Neo4j, in order of version. Applied migrations must never be changed or removed, only added to.
+var neo4jMigrations = []neo4jMigration{
+	{1, "Create the indexes and constraints concepts are read and written by", ensureConceptSchema},
+	{2, "Backfill the aggregate hash of canonical nodes written without one", backfillAggregateHashes},
+	{3, "Drop orphaned identifier nodes which no longer identify a concept", dropOrphanedIdentifiers},
+	{4, "Order outbox events by when they were written and an id instead of a single sequence node", keyOutboxEventsByTime},
+}
+
+func (s *neo4jConceptStore) migrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	var results []struct {
+		Version   int   `json:"version"`
+		AppliedAt int64 `json:"appliedAt"`
+	}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (migration:SchemaMigration)
+			RETURN migration.version as version, migration.appliedAt as appliedAt`,
+		Result: &results,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+		logger.WithError(err).Error("Error executing neo4j migration status query")
+		return nil, err
+	}
+
+	applied := map[int]int64{}
+	for _, result := range results {
+		applied[result.Version] = result.AppliedAt
+	}
+	return neo4jMigrationStatus(applied), nil
+}
+
+//The status of every migration given the epoch millis each applied version was applied at
+func neo4jMigrationStatus(applied map[int]int64) []MigrationStatus {
+	var status []MigrationStatus
+	for _, migration := range neo4jMigrations {
+		m := MigrationStatus{Version: migration.version, Description: migration.description}
+		if appliedAt, ok := applied[migration.version]; ok {
+			m.AppliedAt = time.Unix(0, appliedAt*int64(time.Millisecond)).UTC()
+		}
+		status = append(status, m)
+	}
+	return status
+}
+
+func (s *neo4jConceptStore) migrate(ctx context.Context) ([]MigrationStatus, error) {
+	transID := transactionidutils.NewTransactionID()
+	if err := s.conn.EnsureConstraints(migrationConstraints); err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not run db migration constraints")
+		return nil, err
+	}
+
+	lock := migrationLock{store: s, owner: migrationLockOwner()}
+	if err := lock.acquire(ctx, transID); err != nil {
+		return nil, err
+	}
+	defer lock.release(transID)
+
+	//read once the lock is held, so that migrations applied by the replica which held it before aren't applied again
+	status, err := s.migrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []MigrationStatus
+	for i, migration := range neo4jMigrations {
+		if status[i].Applied() {
+			continue
+		}
+		if err := lock.refresh(ctx); err != nil {
+			return applied, err
+		}
+
+		logger.WithTransactionID(transID).WithField("version", migration.version).Infof("Applying migration: %s", migration.description)
+		if err := migration.up(ctx, s, transID); err != nil {
+			logger.WithError(err).WithTransactionID(transID).WithField("version", migration.version).Error("Migration failed")
+			return applied, fmt.Errorf("migration %d failed: %w", migration.version, err)
+		}
+		appliedAt, err := s.recordMigration(ctx, migration)
+		if err != nil {
+			logger.WithError(err).WithTransactionID(transID).WithField("version", migration.version).Error("Could not record migration")
+			return applied, err
+		}
+		status[i].AppliedAt = appliedAt
+		applied = append(applied, status[i])
+	}
+	return applied, nil
+}
+
+func (s *neo4jConceptStore) recordMigration(ctx context.Context, migration neo4jMigration) (time.Time, error) {
+	var results []struct {
+		AppliedAt int64 `json:"appliedAt"`
+	}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MERGE (migration:SchemaMigration {version: $version})
+			ON CREATE SET migration.description = $description, migration.appliedAt = timestamp()
+			RETURN migration.appliedAt as appliedAt`,
+		Parameters: map[string]interface{}{
+			"version":     migration.version,
+			"description": migration.description,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return time.Time{}, err
+	}
+	if len(results) == 0 {
+		return time.Time{}, fmt.Errorf("migration %d was not recorded", migration.version)
+	}
+	return time.Unix(0, results[0].AppliedAt*int64(time.Millisecond)).UTC(), nil
+}
+
+//Lock held in Neo4j by the replica applying migrations
+type migrationLock struct {
+	store *neo4jConceptStore
+	owner string
+}
+
+func migrationLockOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), rand.Int63())
+}
+
+//Wait for the lock until it is free or has expired, or the context is done
+func (l migrationLock) acquire(ctx context.Context, transID string) error {
+	for attempt := 0; ; attempt++ {
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil {
+			logger.WithError(err).WithTransactionID(transID).Error("Could not take the migration lock")
+			return err
+		}
+		if acquired {
+			return nil
+		}
+
+		if attempt == 0 {
+			logger.WithTransactionID(transID).Info("Waiting for another replica to finish applying migrations")
+		}
+		if !sleepContext(ctx, migrationLockPollInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+//Take the lock if it is free or has expired, or extend it if it is already held by this owner
+func (l migrationLock) tryAcquire(ctx context.Context) (bool, error) {
+	var results []struct {
+		Owner string `json:"owner"`
+	}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MERGE (lock:SchemaMigrationLock {name: $name})
+			WITH lock
+			WHERE lock.owner IS NULL OR lock.owner = $owner OR lock.expires < timestamp()
+			SET lock.owner = $owner, lock.expires = timestamp() + $ttl
+			RETURN lock.owner as owner`,
+		Parameters: map[string]interface{}{
+			"name":  migrationLockName,
+			"owner": l.owner,
+			"ttl":   migrationLockTTL.Milliseconds(),
+		},
+		Result: &results,
+	}
+	if err := l.store.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return false, err
+	}
+	return len(results) == 1, nil
+}
+
+func (l migrationLock) refresh(ctx context.Context) error {
+	held, err := l.tryAcquire(ctx)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return errMigrationLockLost
+	}
+	return nil
+}
+
+//Released even if the context is done, so that other replicas don't wait for it to expire
+func (l migrationLock) release(transID string) {
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (lock:SchemaMigrationLock {name: $name, owner: $owner})
+			REMOVE lock.owner, lock.expires`,
+		Parameters: map[string]interface{}{
+			"name":  migrationLockName,
+			"owner": l.owner,
+		},
+	}
+	if err := l.store.conn.CypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not release the migration lock, it will expire instead")
+	}
+}
+
+// Would this be better as an extension in Neo4j? i.e. that any Thing has this constraint added on creation
+func ensureConceptSchema(ctx context.Context, s *neo4jConceptStore, transID string) error {
+	err := s.conn.EnsureIndexes(map[string]string{
+		"Identifier": "value",
+		"Concept":    "leiCode",
+	})
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not run db index")
+		return err
+	}
+
+	err = s.conn.EnsureIndexes(map[string]string{
+		"Thing":   "authorityValue",
+		"Concept": "authorityValue",
+	})
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not run DB constraints")
+		return err
+	}
+
+	err = s.conn.EnsureConstraints(map[string]string{
+		"Thing":    "prefUUID",
+		"Concept":  "prefUUID",
+		"Location": "iso31661",
+	})
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not run db constraints")
+		return err
+	}
+
+	//the constraints of the outbox as it was first written, which were superseded by migration 4
+	err = s.conn.EnsureConstraints(map[string]string{
+		"OutboxSequence": "name",
+		"OutboxEvent":    "sequence",
+	})
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not run db outbox constraints")
+		return err
+	}
+	return s.conn.EnsureConstraints(constraintMap)
+}
+
+//Canonical nodes written before concepts were hashed can't be written conditionally, as they have no hash to match. They
+//are given the hash of the concept as it is read back, which is the hash a GET returns for them. That isn't necessarily
+//the hash a PUT of the same concept would have, as a concept read back isn't the same shape as the payload it was written
+//with, so the first PUT to them may still rewrite them without changing anything, as it would have without a hash.
+func backfillAggregateHashes(ctx context.Context, s *neo4jConceptStore, transID string) error {
+	after := ""
+	for {
+		var page []struct {
+			PrefUUID string `json:"prefUUID"`
+		}
+		pageQuery := &neoism.CypherQuery{
+			Statement: `
+				MATCH (canonical:Thing)
+				WHERE canonical.prefUUID > $after AND canonical.aggregateHash IS NULL
+				RETURN canonical.prefUUID as prefUUID
+				ORDER BY prefUUID
+				LIMIT $limit`,
+			Parameters: map[string]interface{}{
+				"after": after,
+				"limit": migrationBatchSize,
+			},
+			Result: &page,
+		}
+		if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{pageQuery}); err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+
+		var prefUUIDs []string
+		for _, p := range page {
+			prefUUIDs = append(prefUUIDs, p.PrefUUID)
+		}
+		var results []neoAggregatedConcept
+		query := &neoism.CypherQuery{
+			Statement: `
+				MATCH (canonical:Thing)<-[:EQUIVALENT_TO]-(source:Thing)
+				WHERE canonical.prefUUID IN $prefUUIDs` + readConceptReturnClause,
+			Parameters: map[string]interface{}{
+				"prefUUIDs": prefUUIDs,
+			},
+			Result: &results,
+		}
+		if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+
+		var rows []map[string]interface{}
+		for _, result := range results {
+			concept, err := buildAggregatedConcept(result, transID)
+			if err != nil {
+				//left without a hash, as it was before, to be given one when it is next written
+				continue
+			}
+			hash, err := hashConcept(concept, currentHashVersion)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, map[string]interface{}{"prefUUID": concept.PrefUUID, "hash": hash})
+		}
+		if len(rows) > 0 {
+			hashQuery := &neoism.CypherQuery{
+				Statement: `
+					UNWIND $rows as row
+					MATCH (canonical:Thing {prefUUID: row.prefUUID})
+					WHERE canonical.aggregateHash IS NULL
+					SET canonical.aggregateHash = row.hash, canonical.aggregateHashVersion = $version`,
+				Parameters: map[string]interface{}{
+					"rows":    rows,
+					"version": currentHashVersion,
+				},
+			}
+			if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{hashQuery}); err != nil {
+				return err
+			}
+		}
+		logger.WithTransactionID(transID).Infof("Backfilled the aggregate hash of %d concepts", len(rows))
+		after = prefUUIDs[len(prefUUIDs)-1]
+	}
+}
+
+//Identifier nodes are deleted along with their relationship by the writer, but the identifiers of things that were deleted
+//by other means are left behind, identifying nothing. Only these orphaned ones are dropped, rather than Identifier nodes as
+//a whole, as GET /__identifiers resolves identifiers through them and the writer still writes them for every source.
+func dropOrphanedIdentifiers(ctx context.Context, s *neo4jConceptStore, transID string) error {
+	for {
+		var results []struct {
+			Deleted int `json:"deleted"`
+		}
+		query := &neoism.CypherQuery{
+			Statement: `
+				MATCH (identifier:Identifier)
+				WHERE NOT (identifier)-[:IDENTIFIES]->()
+				WITH identifier LIMIT $limit
+				DETACH DELETE identifier
+				RETURN count(*) as deleted`,
+			Parameters: map[string]interface{}{
+				"limit": migrationBatchSize,
+			},
+			Result: &results,
+		}
+		if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) == 0 || results[0].Deleted == 0 {
+			return nil
+		}
+		logger.WithTransactionID(transID).Infof("Dropped %d orphaned identifier nodes", results[0].Deleted)
+	}
+}
+
+//Outbox events used to be numbered from a single sequence node, which every write locked until it was committed. Events
+//written that way are given an id from their number, which keeps them in order among those written in the same
+//millisecond, and the sequence node is deleted.
+func keyOutboxEventsByTime(ctx context.Context, s *neo4jConceptStore, transID string) error {
+	if err := s.conn.EnsureConstraints(outboxConstraints); err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not run db outbox constraints")
+		return err
+	}
+	if err := s.conn.EnsureIndexes(outboxIndexes); err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Could not run db outbox indexes")
+		return err
+	}
+
+	for {
+		var results []struct {
+			Keyed int `json:"keyed"`
+		}
+		query := &neoism.CypherQuery{
+			Statement: `
+				MATCH (event:OutboxEvent)
+				WHERE event.id IS NULL
+				WITH event LIMIT $limit
+				WITH event, toString(event.sequence) AS sequence
+				SET event.id = substring('00000000000000000000', size(sequence)) + sequence
+				RETURN count(*) as keyed`,
+			Parameters: map[string]interface{}{
+				"limit": migrationBatchSize,
+			},
+			Result: &results,
+		}
+		if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) == 0 || results[0].Keyed == 0 {
+			break
+		}
+		logger.WithTransactionID(transID).Infof("Keyed %d outbox events by when they were written", results[0].Keyed)
+	}
+
+	return s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{{
+		Statement: `
+			MATCH (sequence:OutboxSequence)
+			DELETE sequence`,
+	}})
+}
@@ -0,0 +1,143 @@
+package concepts
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+//Connection standing in for Neo4j while migrating, which holds the applied migrations and the lock
+type migratingConnection struct {
+	sync.Mutex
+	applied     map[int]int64
+	lockOwner   string
+	constraints []map[string]string
+	statements  []string
+}
+
+func (c *migratingConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, query := range queries {
+		c.statements = append(c.statements, query.Statement)
+		var rows []map[string]interface{}
+		switch {
+		case strings.Contains(query.Statement, "MATCH (migration:SchemaMigration)"):
+			for version, appliedAt := range c.applied {
+				rows = append(rows, map[string]interface{}{"version": version, "appliedAt": appliedAt})
+			}
+		case strings.Contains(query.Statement, "MERGE (migration:SchemaMigration"):
+			version := query.Parameters["version"].(int)
+			if _, ok := c.applied[version]; !ok {
+				c.applied[version] = int64(version) * 1000
+			}
+			rows = append(rows, map[string]interface{}{"appliedAt": c.applied[version]})
+		case strings.Contains(query.Statement, "MERGE (lock:SchemaMigrationLock"):
+			owner := query.Parameters["owner"].(string)
+			if c.lockOwner == "" || c.lockOwner == owner {
+				c.lockOwner = owner
+				rows = append(rows, map[string]interface{}{"owner": owner})
+			}
+		case strings.Contains(query.Statement, "REMOVE lock.owner"):
+			if c.lockOwner == query.Parameters["owner"].(string) {
+				c.lockOwner = ""
+			}
+		}
+
+		if query.Result != nil {
+			data, _ := json.Marshal(rows)
+			if err := json.Unmarshal(data, query.Result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *migratingConnection) EnsureConstraints(constraints map[string]string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.constraints = append(c.constraints, constraints)
+	return nil
+}
+
+func (c *migratingConnection) EnsureIndexes(indexes map[string]string) error {
+	return nil
+}
+
+func TestMigrationsAreVersionedInOrder(t *testing.T) {
+	for i, migration := range neo4jMigrations {
+		assert.Equal(t, i+1, migration.version, "Migrations should be numbered from 1 in the order they are applied")
+		assert.NotEmpty(t, migration.description)
+		assert.NotNil(t, migration.up)
+	}
+}
+
+func TestMigrateUp(t *testing.T) {
+	latest := neo4jMigrations[len(neo4jMigrations)-1].version
+	tests := []struct {
+		name             string
+		applied          map[int]int64
+		expectedVersions []int
+	}{
+		{"New database", map[int]int64{}, []int{1, 2, 3, 4}},
+		{"Partly migrated database", map[int]int64{1: 1000}, []int{2, 3, 4}},
+		{"Migrated database", map[int]int64{1: 1000, 2: 2000, 3: 3000, 4: 4000}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &migratingConnection{applied: test.applied}
+			service := NewConceptServiceWithStore(NewNeo4jConceptStore(conn), nil)
+
+			applied, err := service.MigrateUp(context.Background())
+			assert.NoError(t, err)
+			var versions []int
+			for _, migration := range applied {
+				versions = append(versions, migration.Version)
+				assert.Equal(t, time.Unix(int64(migration.Version), 0).UTC(), migration.AppliedAt)
+			}
+			assert.Equal(t, test.expectedVersions, versions)
+			assert.Contains(t, conn.constraints, migrationConstraints, "The lock should only be taken once its constraint exists")
+			assert.Empty(t, conn.lockOwner, "The lock should be released")
+
+			status, err := service.MigrationStatus(context.Background())
+			assert.NoError(t, err)
+			assert.Len(t, status, latest)
+			for _, migration := range status {
+				assert.True(t, migration.Applied(), "Migration %d should be applied", migration.Version)
+			}
+		})
+	}
+}
+
+func TestMigrateUpWaitsForTheLock(t *testing.T) {
+	conn := &migratingConnection{applied: map[int]int64{}, lockOwner: "another-replica"}
+	service := NewConceptServiceWithStore(NewNeo4jConceptStore(conn), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	applied, err := service.MigrateUp(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, applied)
+	assert.Empty(t, conn.applied, "No migration should be applied while another replica holds the lock")
+	assert.Equal(t, "another-replica", conn.lockOwner, "The lock of another replica should not be released")
+}
+
+func TestMemoryStoreHasNoMigrations(t *testing.T) {
+	service := NewConceptServiceWithStore(NewMemoryConceptStore(), nil)
+
+	applied, err := service.MigrateUp(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+	status, err := service.MigrationStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, status)
+}
@@ -24,6 +24,7 @@ type AggregatedConcept struct {
 	OrganisationUUID      string           `json:"organisationUUID,omitempty"`
 	PersonUUID            string           `json:"personUUID,omitempty"`
 	AggregatedHash        string           `json:"aggregateHash,omitempty"`
+	AggregatedHashVersion int              `json:"-" hash:"ignore"`
 	SourceRepresentations []Concept        `json:"sourceRepresentations,omitempty"`
 	MembershipRoles       []MembershipRole `json:"membershipRoles,omitempty"`
 	InceptionDate         string           `json:"inceptionDate,omitempty"`
@@ -109,6 +110,28 @@ type Concept struct {
 	BirthYear  int    `json:"birthYear,omitempty"`
 }
 
+// IdentifierResolution - the concept identified by an authority value or natural key
+type IdentifierResolution struct {
+	UUID     string `json:"uuid"`
+	PrefUUID string `json:"prefUUID,omitempty"`
+	Type     string `json:"type"`
+}
+
+// BulkWriteResult - the outcome of writing a single line of a bulk write
+type BulkWriteResult struct {
+	Line    int             `json:"line"`
+	UUID    string          `json:"uuid,omitempty"`
+	Status  int             `json:"status"`
+	Changes interface{}     `json:"changes,omitempty"`
+	Error   *BulkWriteError `json:"error,omitempty"`
+}
+
+// BulkWriteError - why a line of a bulk write was not written, classified as invalidRequest, conflict or unavailable
+type BulkWriteError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
 type ConceptChanges struct {
 	ChangedRecords []Event  `json:"events"`
 	UpdatedIds     []string `json:"updatedIDs"`
@@ -122,6 +145,13 @@ type Event struct {
 	EventDetails  interface{} `json:"eventDetails"`
 }
 
+// OutboxEvent - an event persisted in the same transaction as the change that caused it, along with the cursor
+// giving its position in the outbox
+type OutboxEvent struct {
+	Cursor string `json:"cursor"`
+	Event  Event  `json:"event"`
+}
+
 type ConceptEvent struct {
 	Type string `json:"eventType"`
 }
@@ -187,3 +217,15 @@ var authorityToIdentifierLabelMap = map[string]string{
 	"Smartlogic": "SmartlogicIdentifier",
 	"FACTSET":    "FactsetIdentifier",
 }
+
+// Map of natural keys and the label of the canonical nodes that hold them - these are indexed or
+// constrained by the first migration
+var naturalKeyToLabelMap = map[string]string{
+	"leiCode":  "Concept",
+	"iso31661": "Location",
+}
+
+// Map of natural keys which are written as identifier nodes rather than properties
+var naturalKeyToIdentifierLabelMap = map[string]string{
+	"figiCode": "FIGIIdentifier",
+}
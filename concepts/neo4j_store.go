@@ -0,0 +1,312 @@
+package concepts
+
+import (
+	"context"
+	"fmt"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rcrowley/go-metrics"
+)
+
+type neo4jConceptStore struct {
+	conn          *resilientConnection
+	checkWritable func() error
+	statements    prometheus.Histogram
+}
+
+//Connection which checks whether it can be written to itself, rather than by the role of the server
+type writableChecker interface {
+	CheckWritable() error
+}
+
+// NewNeo4jConceptStore - store of concepts in Neo4j, which tries batches that fail with transient errors again
+func NewNeo4jConceptStore(conn neoutils.NeoConnection) ConceptStore {
+	store := &neo4jConceptStore{
+		conn:       newResilientConnection(conn, metrics.DefaultRegistry),
+		statements: newWriteStatementsHistogram(prometheus.DefaultRegisterer),
+	}
+	if checker, ok := conn.(writableChecker); ok {
+		store.checkWritable = checker.CheckWritable
+	} else {
+		store.checkWritable = func() error {
+			return neoutils.CheckWritable(store.conn)
+		}
+	}
+	return store
+}
+
+func (s *neo4jConceptStore) check() error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	return neoutils.Check(s.conn)
+}
+
+func (s *neo4jConceptStore) readConcept(ctx context.Context, prefUUID string, transID string) (AggregatedConcept, bool, error) {
+	var results []neoAggregatedConcept
+
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (canonical:Thing {prefUUID:$uuid})<-[:EQUIVALENT_TO]-(source:Thing)` + readConceptReturnClause,
+		Parameters: map[string]interface{}{
+			"uuid": prefUUID,
+		},
+		Result: &results,
+	}
+
+	err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query})
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(prefUUID).Error("Error executing neo4j read query")
+		return AggregatedConcept{}, false, err
+	}
+	if len(results) == 0 {
+		return AggregatedConcept{}, false, nil
+	}
+
+	aggregatedConcept, err := buildAggregatedConcept(results[0], transID)
+	if err != nil {
+		return AggregatedConcept{}, false, err
+	}
+	return aggregatedConcept, true, nil
+}
+
+func (s *neo4jConceptStore) exportConcepts(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+	var page []struct {
+		PrefUUID string `json:"prefUUID"`
+	}
+	pageQuery := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`
+			MATCH (canonical:Thing:%s)
+			WHERE canonical.prefUUID > $after
+			RETURN canonical.prefUUID as prefUUID
+			ORDER BY prefUUID
+			LIMIT $limit`, conceptType),
+		Parameters: map[string]interface{}{
+			"after": after,
+			"limit": limit,
+		},
+		Result: &page,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{pageQuery}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithField("type", conceptType).Error("Error executing neo4j export page query")
+		return nil, "", err
+	}
+	if len(page) == 0 {
+		return []AggregatedConcept{}, "", nil
+	}
+
+	var prefUUIDs []string
+	for _, p := range page {
+		prefUUIDs = append(prefUUIDs, p.PrefUUID)
+	}
+
+	var results []neoAggregatedConcept
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (canonical:Thing)<-[:EQUIVALENT_TO]-(source:Thing)
+			WHERE canonical.prefUUID IN $prefUUIDs` + readConceptReturnClause,
+		Parameters: map[string]interface{}{
+			"prefUUIDs": prefUUIDs,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithField("type", conceptType).Error("Error executing neo4j export query")
+		return nil, "", err
+	}
+
+	concepts := make([]AggregatedConcept, 0, len(results))
+	for _, result := range results {
+		// as with Read, only the first row for each canonical node is used
+		if len(concepts) > 0 && concepts[len(concepts)-1].PrefUUID == result.PrefUUID {
+			continue
+		}
+		aggregatedConcept, err := buildAggregatedConcept(result, transID)
+		if err != nil {
+			// a single concept with inconsistent types should not stop the rest being exported
+			logger.WithError(err).WithTransactionID(transID).WithUUID(result.PrefUUID).Warn("Skipping concept which could not be exported")
+			continue
+		}
+		concepts = append(concepts, aggregatedConcept)
+	}
+
+	next := ""
+	if len(page) == limit {
+		next = prefUUIDs[len(prefUUIDs)-1]
+	}
+	return concepts, next, nil
+}
+
+func (s *neo4jConceptStore) readEquivalence(ctx context.Context, sourceUUID string, transID string) ([]equivalenceResult, error) {
+	var result []equivalenceResult
+	equivQuery := &neoism.CypherQuery{
+		Statement: `
+				MATCH (t:Thing {uuid:$id})
+				OPTIONAL MATCH (t)-[:EQUIVALENT_TO]->(c)
+				OPTIONAL MATCH (c)<-[eq:EQUIVALENT_TO]-(x:Thing)
+				RETURN t.uuid as sourceUuid, labels(t) as types, c.prefUUID as prefUuid, t.authority as authority, COUNT(DISTINCT eq) as count`,
+		Parameters: map[string]interface{}{
+			"id": sourceUUID,
+		},
+		Result: &result,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{equivQuery}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(sourceUUID).Error("Requests for source nodes canonical information resulted in error")
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *neo4jConceptStore) readIssued(ctx context.Context, issuerUUID string, transID string) ([]string, error) {
+	var fiRes []map[string]string
+	issuerQuery := &neoism.CypherQuery{
+		Statement: `
+				MATCH (issuer:Thing {uuid: $issuerUUID})<-[:ISSUED_BY]-(fi)
+				RETURN fi.uuid AS fiUUID
+			`,
+		Parameters: map[string]interface{}{
+			"issuerUUID": issuerUUID,
+		},
+		Result: &fiRes,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{issuerQuery}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(issuerUUID).Error("Could not get existing issuer.")
+		return nil, err
+	}
+
+	var fiUUIDs []string
+	for _, fi := range fiRes {
+		if fiUUID, ok := fi["fiUUID"]; ok {
+			fiUUIDs = append(fiUUIDs, fiUUID)
+		}
+	}
+	return fiUUIDs, nil
+}
+
+func (s *neo4jConceptStore) readDependants(ctx context.Context, prefUUID string, transID string) ([]string, error) {
+	var results []struct {
+		UUID string `json:"uuid"`
+	}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (canonical:Thing {prefUUID:$uuid})<-[:EQUIVALENT_TO]-(source:Thing)
+			MATCH (source)<-[]-(dependant:Thing)
+			WHERE NOT (dependant)-[:EQUIVALENT_TO]->(canonical)
+			RETURN DISTINCT dependant.uuid as uuid
+			ORDER BY uuid`,
+		Parameters: map[string]interface{}{
+			"uuid": prefUUID,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithUUID(prefUUID).Error("Request for dependant concepts resulted in error")
+		return nil, err
+	}
+
+	var dependants []string
+	for _, r := range results {
+		dependants = append(dependants, r.UUID)
+	}
+	return dependants, nil
+}
+
+func (s *neo4jConceptStore) readIdentified(ctx context.Context, authority string, value string, transID string) ([]identifiedConcept, error) {
+	var statement string
+	if label, ok := authorityToIdentifierLabelMap[authority]; ok {
+		statement = identifierResolutionStatement(label)
+	} else if label, ok := naturalKeyToIdentifierLabelMap[authority]; ok {
+		statement = identifierResolutionStatement(label)
+	} else {
+		statement = fmt.Sprintf(`
+			MATCH (canonical:%s {%s:$value})
+			WHERE canonical.prefUUID IS NOT NULL
+			RETURN canonical.prefUUID as uuid, canonical.prefUUID as prefUUID, labels(canonical) as types
+			ORDER BY uuid`, naturalKeyToLabelMap[authority], authority)
+	}
+
+	var results []identifiedConcept
+	query := &neoism.CypherQuery{
+		Statement: statement,
+		Parameters: map[string]interface{}{
+			"value": value,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithField("authority", authority).Error("Error executing neo4j identifier query")
+		return nil, err
+	}
+	return results, nil
+}
+
+//Write the concept in a single batch. The guards run first, checking the concordances as they were read before anything
+//in the batch changes them, and the events are added to the outbox last, so that they are committed if and only if
+//everything else is.
+func (s *neo4jConceptStore) write(ctx context.Context, w conceptWrite, transID string) error {
+	queryBatch := []*neoism.CypherQuery{concordanceGuardQuery(w.prefUUID, w.sourceCount, w.aggregateHash)}
+	for sourceUUID, read := range w.transferred {
+		queryBatch = append(queryBatch, equivalenceGuardQuery(sourceUUID, read))
+	}
+	if w.precondition != nil {
+		queryBatch = append(queryBatch, preconditionGuardQueries(w.prefUUID, *w.precondition)...)
+	}
+
+	//Canonical nodes are deleted before those of unconcorded sources are created, so that a source unconcorded from one
+	//canonical node can be given a lone canonical node of its own in place of another deleted by the same write
+	if len(w.unconcorded) > 0 {
+		queryBatch = append(queryBatch, removeEquivalenceQuery(w.prefUUID, w.unconcorded))
+	}
+	for _, prefUUID := range w.deletedCanonicals {
+		queryBatch = append(queryBatch, deleteLonePrefUUID(prefUUID))
+	}
+	for _, concept := range w.unconcorded {
+		queryBatch = append(queryBatch, canonicalNodeForUnconcordedConceptQuery(concept))
+	}
+
+	if w.concept != nil {
+		var sourceUUIDs []string
+		for _, source := range w.concept.SourceRepresentations {
+			sourceUUIDs = append(sourceUUIDs, source.UUID)
+		}
+		storedCanonical, storedSources, err := s.readStoredNodes(ctx, w.prefUUID, sourceUUIDs, transID)
+		if err != nil {
+			return err
+		}
+		queryBatch = append(queryBatch, conceptWritePlan(*w.concept, storedCanonical, storedSources).queries()...)
+
+		for _, fiUUID := range w.reissued {
+			queryBatch = append(queryBatch, &neoism.CypherQuery{
+				Statement: `
+					MATCH (issuer:Thing {uuid: $issuerUUID})
+					MATCH (fi:Thing {uuid: $fiUUID})
+					MATCH (issuer)<-[issuerRel:ISSUED_BY]-(fi)
+					DELETE issuerRel
+				`,
+				Parameters: map[string]interface{}{
+					"issuerUUID": w.concept.IssuedBy,
+					"fiUUID":     fiUUID,
+				},
+			})
+		}
+	}
+
+	if len(w.events) > 0 {
+		eventsQuery, err := outboxQuery(w.events)
+		if err != nil {
+			logger.WithError(err).WithTransactionID(transID).WithUUID(w.prefUUID).Error("Error encoding events for the outbox")
+			return err
+		}
+		queryBatch = append(queryBatch, eventsQuery)
+	}
+
+	logger.WithTransactionID(transID).WithUUID(w.prefUUID).Debugf("Executing %d queries", len(queryBatch))
+	for _, query := range queryBatch {
+		logger.WithTransactionID(transID).WithUUID(w.prefUUID).Debug(fmt.Sprintf("Query: %v", query))
+	}
+	s.statements.Observe(float64(len(queryBatch)))
+	return s.conn.CypherBatchContext(ctx, queryBatch)
+}
@@ -0,0 +1,267 @@
+package concepts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/jmcvetta/neoism"
+)
+
+var (
+	//How long an event is kept out of the feed after it was written, which must be longer than a write can take, so
+	//that a write committed after a later one has been read can't be skipped past by a reader following the cursor
+	outboxSettleDelay = time.Minute
+	//How many events are deleted from the outbox in each transaction, so that a large backlog of acknowledged or
+	//expired events doesn't have to be deleted in one
+	outboxDeleteBatchSize = 1000
+)
+
+//Constraints and indexes the outbox relies on, so that its events are looked up by cursor
+var (
+	outboxConstraints = map[string]string{
+		"OutboxEvent": "id",
+	}
+	outboxIndexes = map[string]string{
+		"OutboxEvent": "createdAt",
+	}
+)
+
+//Position of an event in the outbox. Events are ordered by the time the write that added them was run, and then by their
+//id, which keeps the events of a write together and in order.
+type eventCursor struct {
+	createdAt int64
+	id        string
+}
+
+func (c eventCursor) String() string {
+	return strconv.FormatInt(c.createdAt, 10) + "-" + c.id
+}
+
+//Whether the event at the cursor comes after the given one
+func (c eventCursor) after(other eventCursor) bool {
+	return c.createdAt > other.createdAt || (c.createdAt == other.createdAt && c.id > other.id)
+}
+
+//Query persisting the events of a write as outbox nodes, to be run as the last query of the write's batch so that the
+//events are committed if and only if the write is. No node is shared by the events of different writes, so concurrent
+//writes aren't held up by each other's events. Instead the feed leaves out events until outboxSettleDelay has passed,
+//by which time every write run before them has been committed or rolled back.
+func outboxQuery(events []Event) (*neoism.CypherQuery, error) {
+	writeID, err := newOutboxWriteID()
+	if err != nil {
+		return nil, err
+	}
+
+	var outboxEvents []map[string]interface{}
+	for i, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		outboxEvents = append(outboxEvents, map[string]interface{}{
+			"id":            fmt.Sprintf("%s.%06d", writeID, i),
+			"conceptUUID":   event.ConceptUUID,
+			"transactionID": event.TransactionID,
+			"payload":       string(payload),
+		})
+	}
+
+	return &neoism.CypherQuery{
+		Statement: `
+			UNWIND $events AS event
+			CREATE (:OutboxEvent {
+				id: event.id,
+				conceptUUID: event.conceptUUID,
+				transactionID: event.transactionID,
+				payload: event.payload,
+				createdAt: timestamp()
+			})`,
+		Parameters: map[string]interface{}{
+			"events": outboxEvents,
+		},
+	}, nil
+}
+
+//Random id shared by the events of a write, which orders them among the events of other writes run at the same time
+func newOutboxWriteID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Events - returns at most limit events from the outbox that have not been acknowledged, oldest first and starting
+// after the given cursor. An empty cursor starts from the oldest event that has not been acknowledged.
+func (s *ConceptService) Events(ctx context.Context, after string, limit int, transID string) ([]OutboxEvent, error) {
+	afterCursor, err := parseEventCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.readEvents(ctx, afterCursor, limit, transID)
+}
+
+// AcknowledgeEvents - removes every event up to and including the given cursor from the outbox, once they have been
+// forwarded, and returns how many were removed
+func (s *ConceptService) AcknowledgeEvents(ctx context.Context, upTo string, transID string) (int, error) {
+	upToCursor, err := parseEventCursor(upTo)
+	if err != nil {
+		return 0, err
+	}
+
+	acknowledged, err := s.store.acknowledgeEvents(ctx, upToCursor, transID)
+	if err != nil {
+		return 0, err
+	}
+	logger.WithTransactionID(transID).WithField("cursor", upTo).Infof("Acknowledged %d events", acknowledged)
+	return acknowledged, nil
+}
+
+// ExpireEvents - removes events older than the retention period from the outbox every interval, whether or not they
+// have been acknowledged, so that the outbox doesn't grow without limit when nothing acknowledges them. It returns
+// once the context is done.
+func (s *ConceptService) ExpireEvents(ctx context.Context, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		transID := transactionidutils.NewTransactionID()
+		expired, err := s.store.expireEvents(ctx, retention, transID)
+		if err != nil {
+			logger.WithError(err).WithTransactionID(transID).Error("Could not expire events from the outbox")
+			continue
+		}
+		if expired > 0 {
+			logger.WithTransactionID(transID).Warnf("Expired %d events from the outbox which were not acknowledged within %s", expired, retention)
+		}
+	}
+}
+
+func (s *neo4jConceptStore) readEvents(ctx context.Context, after eventCursor, limit int, transID string) ([]OutboxEvent, error) {
+	var results []struct {
+		CreatedAt int64  `json:"createdAt"`
+		ID        string `json:"id"`
+		Payload   string `json:"payload"`
+	}
+	query := &neoism.CypherQuery{
+		Statement: `
+			MATCH (event:OutboxEvent)
+			WHERE (event.createdAt > $afterCreatedAt OR (event.createdAt = $afterCreatedAt AND event.id > $afterID))
+				AND event.createdAt <= timestamp() - $settleDelay
+			RETURN event.createdAt as createdAt, event.id as id, event.payload as payload
+			ORDER BY createdAt, id
+			LIMIT $limit`,
+		Parameters: map[string]interface{}{
+			"afterCreatedAt": after.createdAt,
+			"afterID":        after.id,
+			"settleDelay":    outboxSettleDelay.Milliseconds(),
+			"limit":          limit,
+		},
+		Result: &results,
+	}
+	if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Error executing neo4j outbox query")
+		return nil, err
+	}
+
+	events := make([]OutboxEvent, 0, len(results))
+	for _, result := range results {
+		event, err := decodeOutboxEvent(eventCursor{result.CreatedAt, result.ID}, result.Payload, transID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *neo4jConceptStore) acknowledgeEvents(ctx context.Context, upTo eventCursor, transID string) (int, error) {
+	acknowledged, err := s.deleteEvents(ctx, "event.createdAt < $upToCreatedAt OR (event.createdAt = $upToCreatedAt AND event.id <= $upToID)", map[string]interface{}{
+		"upToCreatedAt": upTo.createdAt,
+		"upToID":        upTo.id,
+	})
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Error executing neo4j outbox acknowledgement query")
+	}
+	return acknowledged, err
+}
+
+func (s *neo4jConceptStore) expireEvents(ctx context.Context, olderThan time.Duration, transID string) (int, error) {
+	expired, err := s.deleteEvents(ctx, "event.createdAt < timestamp() - $olderThan", map[string]interface{}{
+		"olderThan": olderThan.Milliseconds(),
+	})
+	if err != nil {
+		logger.WithError(err).WithTransactionID(transID).Error("Error executing neo4j outbox expiry query")
+	}
+	return expired, err
+}
+
+//Delete the events matching the condition, at most outboxDeleteBatchSize in each transaction, returning how many were
+//deleted. Events deleted by earlier transactions stay deleted if a later one fails.
+func (s *neo4jConceptStore) deleteEvents(ctx context.Context, condition string, parameters map[string]interface{}) (int, error) {
+	parameters["limit"] = outboxDeleteBatchSize
+	deleted := 0
+	for {
+		var results []struct {
+			Deleted int `json:"deleted"`
+		}
+		query := &neoism.CypherQuery{
+			Statement: `
+				MATCH (event:OutboxEvent)
+				WHERE ` + condition + `
+				WITH event LIMIT $limit
+				DELETE event
+				RETURN count(*) as deleted`,
+			Parameters: parameters,
+			Result:     &results,
+		}
+		if err := s.conn.CypherBatchContext(ctx, []*neoism.CypherQuery{query}); err != nil {
+			return deleted, err
+		}
+		if len(results) == 0 {
+			return deleted, nil
+		}
+		deleted += results[0].Deleted
+		if results[0].Deleted < outboxDeleteBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+//Decode an event as it was persisted in the outbox
+func decodeOutboxEvent(cursor eventCursor, payload string, transID string) (OutboxEvent, error) {
+	event := Event{}
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		logger.WithError(err).WithTransactionID(transID).WithField("cursor", cursor.String()).Error("Outbox event could not be decoded")
+		return OutboxEvent{}, err
+	}
+	return OutboxEvent{Cursor: cursor.String(), Event: event}, nil
+}
+
+func parseEventCursor(cursor string) (eventCursor, error) {
+	if cursor == "" {
+		return eventCursor{}, nil
+	}
+	parts := strings.SplitN(cursor, "-", 2)
+	if len(parts) != 2 {
+		return eventCursor{}, requestError{fmt.Sprintf("Invalid event cursor: '%v'", cursor)}
+	}
+	createdAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || createdAt < 0 {
+		return eventCursor{}, requestError{fmt.Sprintf("Invalid event cursor: '%v'", cursor)}
+	}
+	return eventCursor{createdAt, parts[1]}, nil
+}
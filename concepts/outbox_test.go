@@ -0,0 +1,57 @@
+package concepts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxQueryKeepsTheEventsOfAWriteInOrder(t *testing.T) {
+	events := []Event{{ConceptUUID: "1"}, {ConceptUUID: "2"}, {ConceptUUID: "3"}}
+	query, err := outboxQuery(events)
+	assert.NoError(t, err)
+	assert.NotContains(t, query.Statement, "OutboxSequence", "Writes should not share a node that they would each have to lock")
+
+	outboxEvents := query.Parameters["events"].([]map[string]interface{})
+	assert.Len(t, outboxEvents, len(events))
+	for i := 1; i < len(outboxEvents); i++ {
+		previous := eventCursor{id: outboxEvents[i-1]["id"].(string)}
+		current := eventCursor{id: outboxEvents[i]["id"].(string)}
+		assert.True(t, current.after(previous), "The events of a write should be in the order they were made")
+	}
+
+	another, err := outboxQuery(events)
+	assert.NoError(t, err)
+	assert.NotEqual(t, outboxEvents[0]["id"], another.Parameters["events"].([]map[string]interface{})[0]["id"], "The events of each write should have ids of their own")
+}
+
+func TestParseEventCursor(t *testing.T) {
+	tests := []struct {
+		cursor   string
+		expected eventCursor
+		valid    bool
+	}{
+		{"", eventCursor{}, true},
+		{"1600000000000-0a1b2c3d4e5f6a7b.000002", eventCursor{1600000000000, "0a1b2c3d4e5f6a7b.000002"}, true},
+		{"1600000000000-", eventCursor{1600000000000, ""}, true},
+		{"42", eventCursor{}, false},
+		{"-1-abc", eventCursor{}, false},
+		{"abc-def", eventCursor{}, false},
+	}
+	for _, test := range tests {
+		cursor, err := parseEventCursor(test.cursor)
+		if !test.valid {
+			assert.IsType(t, requestError{}, err, test.cursor)
+			continue
+		}
+		assert.NoError(t, err, test.cursor)
+		assert.Equal(t, test.expected, cursor)
+		if test.cursor != "" {
+			assert.Equal(t, test.cursor, cursor.String())
+		}
+	}
+
+	assert.True(t, eventCursor{2, "a"}.after(eventCursor{1, "b"}))
+	assert.True(t, eventCursor{2, "b"}.after(eventCursor{2, "a"}))
+	assert.False(t, eventCursor{2, "a"}.after(eventCursor{2, "a"}))
+}
@@ -0,0 +1,90 @@
+package concepts
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const unknownConceptType = "unknown"
+
+//Alert tags logged alongside the writes that need someone to look at the data, and counted by tag
+const (
+	alertDodgyData            = "ConceptLoadingDodgyData"
+	alertInvalidConcordance   = "ConceptLoadingInvalidConcordance"
+	alertStaleData            = "ConceptLoadingStaleData"
+	alertLedToDifferentIssuer = "ConceptLoadingLedToDifferentIssuer"
+)
+
+//Prometheus metrics of the write path, which are served on /metrics alongside the Go and process metrics
+type conceptMetrics struct {
+	readDuration  *prometheus.HistogramVec
+	writeDuration *prometheus.HistogramVec
+	unchanged     *prometheus.CounterVec
+	events        *prometheus.CounterVec
+	alerts        *prometheus.CounterVec
+}
+
+func newConceptMetrics(registerer prometheus.Registerer) *conceptMetrics {
+	return &conceptMetrics{
+		readDuration: getOrRegister(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "concepts_read_duration_seconds",
+			Help:    "Time taken to read a concept, by the type of the concept read.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"concept_type"})).(*prometheus.HistogramVec),
+		writeDuration: getOrRegister(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "concepts_write_duration_seconds",
+			Help:    "Time taken to write a concept, by the type of the concept written.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"concept_type"})).(*prometheus.HistogramVec),
+		unchanged: getOrRegister(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "concepts_unchanged_writes_total",
+			Help: "Writes skipped as the concept's hash had not changed, by the type of the concept.",
+		}, []string{"concept_type"})).(*prometheus.CounterVec),
+		events: getOrRegister(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "concepts_events_total",
+			Help: "Events of the writes and deletes which have been committed, by event type.",
+		}, []string{"event_type"})).(*prometheus.CounterVec),
+		alerts: getOrRegister(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "concepts_alerts_total",
+			Help: "Writes logged with an alert tag, by tag.",
+		}, []string{"alert_tag"})).(*prometheus.CounterVec),
+	}
+}
+
+//Statements in each batch a write commits, which is counted by the store that builds the batch
+func newWriteStatementsHistogram(registerer prometheus.Registerer) prometheus.Histogram {
+	return getOrRegister(registerer, prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "concepts_write_statements",
+		Help:    "Cypher statements in the batch of each write.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})).(prometheus.Histogram)
+}
+
+//Registers the collector, or returns the one already registered under the same name, so that every service created in a
+//process shares the same metrics
+func getOrRegister(registerer prometheus.Registerer, collector prometheus.Collector) prometheus.Collector {
+	if err := registerer.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			return alreadyRegistered.ExistingCollector
+		}
+		panic(err)
+	}
+	return collector
+}
+
+func (m *conceptMetrics) countEvents(events []Event) {
+	for _, event := range events {
+		switch details := event.EventDetails.(type) {
+		case ConceptEvent:
+			m.events.WithLabelValues(details.Type).Inc()
+		case ConcordanceEvent:
+			m.events.WithLabelValues(details.Type).Inc()
+		}
+	}
+}
+
+func (m *conceptMetrics) alert(tag string) {
+	m.alerts.WithLabelValues(tag).Inc()
+}
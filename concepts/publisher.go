@@ -0,0 +1,82 @@
+package concepts
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// EventPublisher - forwards the events of a write or delete once it has been committed
+type EventPublisher interface {
+	Publish(events []Event, transID string) error
+}
+
+//Publisher used unless another is given, leaving the events to be relayed from the response
+type responseOnlyEventPublisher struct{}
+
+func (p responseOnlyEventPublisher) Publish(events []Event, transID string) error {
+	return nil
+}
+
+// FileEventPublisher - appends each event to a file as a line of newline delimited JSON
+type FileEventPublisher struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFileEventPublisher - opens the file to append events to, creating it if it doesn't exist
+func NewFileEventPublisher(path string) (*FileEventPublisher, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileEventPublisher{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Publish - writes the events of a single write together, so that they are not interleaved with those of another
+func (p *FileEventPublisher) Publish(events []Event, transID string) error {
+	p.Lock()
+	defer p.Unlock()
+	for _, event := range events {
+		if err := p.enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close - closes the file events are appended to
+func (p *FileEventPublisher) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.file.Close()
+}
+
+// MemoryEventPublisher - keeps every event published, for tests
+type MemoryEventPublisher struct {
+	sync.Mutex
+	events []Event
+}
+
+// Publish - records the events
+func (p *MemoryEventPublisher) Publish(events []Event, transID string) error {
+	p.Lock()
+	defer p.Unlock()
+	p.events = append(p.events, events...)
+	return nil
+}
+
+// Events - returns the events published so far, in the order they were published
+func (p *MemoryEventPublisher) Events() []Event {
+	p.Lock()
+	defer p.Unlock()
+	return append([]Event(nil), p.events...)
+}
+
+// Reset - forgets the events published so far
+func (p *MemoryEventPublisher) Reset() {
+	p.Lock()
+	defer p.Unlock()
+	p.events = nil
+}
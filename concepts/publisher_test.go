@@ -0,0 +1,51 @@
+package concepts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileEventPublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.ndjson")
+
+	updated := Event{ConceptType: "Brand", ConceptUUID: "1", AggregateHash: "123", TransactionID: "tid_1", EventDetails: ConceptEvent{Type: UpdatedEvent}}
+	removed := Event{ConceptType: "Brand", ConceptUUID: "2", AggregateHash: "123", TransactionID: "tid_1", EventDetails: ConcordanceEvent{Type: RemovedEvent, OldID: "1", NewID: "2"}}
+
+	publisher, err := NewFileEventPublisher(path)
+	assert.NoError(t, err)
+	assert.NoError(t, publisher.Publish([]Event{updated, removed}, "tid_1"))
+	assert.NoError(t, publisher.Close())
+
+	// events are appended to an existing file
+	publisher, err = NewFileEventPublisher(path)
+	assert.NoError(t, err)
+	assert.NoError(t, publisher.Publish([]Event{updated}, "tid_2"))
+	assert.NoError(t, publisher.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"Brand","uuid":"1","aggregateHash":"123","transactionID":"tid_1","eventDetails":{"eventType":"CONCEPT_UPDATED"}}
+{"type":"Brand","uuid":"2","aggregateHash":"123","transactionID":"tid_1","eventDetails":{"eventType":"CONCORDANCE_REMOVED","oldID":"1","newID":"2"}}
+{"type":"Brand","uuid":"1","aggregateHash":"123","transactionID":"tid_1","eventDetails":{"eventType":"CONCEPT_UPDATED"}}
+`, string(data))
+}
+
+func TestMemoryEventPublisher(t *testing.T) {
+	publisher := &MemoryEventPublisher{}
+	updated := Event{ConceptUUID: "1", EventDetails: ConceptEvent{Type: UpdatedEvent}}
+	added := Event{ConceptUUID: "2", EventDetails: ConcordanceEvent{Type: AddedEvent, OldID: "2", NewID: "1"}}
+
+	assert.NoError(t, publisher.Publish([]Event{updated}, "tid_1"))
+	assert.NoError(t, publisher.Publish([]Event{added}, "tid_2"))
+	assert.Equal(t, []Event{updated, added}, publisher.Events())
+
+	publisher.Reset()
+	assert.Empty(t, publisher.Events())
+}
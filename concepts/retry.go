@@ -0,0 +1,264 @@
+package concepts
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/jmcvetta/neoism"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	//How often a batch that failed with a transient error is tried again, and how long to wait before doing so
+	neo4jRetries        = 3
+	neo4jRetryBaseDelay = 50 * time.Millisecond
+	neo4jRetryMaxDelay  = time.Second
+
+	//How many batches in a row can fail to reach Neo4j before the circuit is opened, and how long it stays open
+	neo4jCircuitThreshold = 5
+	neo4jCircuitCooldown  = 10 * time.Second
+
+	errCircuitOpen = errors.New("Neo4j is unavailable, not trying to reach it again until the circuit closes")
+)
+
+//Messages of the transaction errors that Neo4j rolls back without them being the fault of the batch, such as
+//deadlocks between concurrent transactions, so that the batch can be tried again as it is. Their codes are lost by
+//neoutils, which only keeps the messages.
+var transientErrorMessages = []string{
+	"deadlock",
+	"can't acquire",
+	"transienterror",
+}
+
+//Connection which tries batches that failed with a transient error again, with jittered exponential backoff, and
+//which stops trying to reach Neo4j for a while after failing to reach it too many times in a row
+type resilientConnection struct {
+	neoutils.NeoConnection
+	breaker   *circuitBreaker
+	retries   int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	sleep     func(context.Context, time.Duration) bool
+	retried   metrics.Counter
+}
+
+func newResilientConnection(conn neoutils.NeoConnection, registry metrics.Registry) *resilientConnection {
+	return &resilientConnection{
+		NeoConnection: conn,
+		breaker:       newCircuitBreaker(neo4jCircuitThreshold, neo4jCircuitCooldown, registry),
+		retries:       neo4jRetries,
+		baseDelay:     neo4jRetryBaseDelay,
+		maxDelay:      neo4jRetryMaxDelay,
+		sleep:         sleepContext,
+		retried:       metrics.GetOrRegisterCounter("concepts.neo4j.retries", registry),
+	}
+}
+
+//Connection which gives up on a batch once the context is done, rolling it back
+type contextConnection interface {
+	CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error
+}
+
+func (c *resilientConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherBatchContext(context.Background(), queries)
+}
+
+//Run the batch, trying it again while it fails with transient errors, until the context is done
+func (c *resilientConnection) CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) (err error) {
+	ctx, span := startSpan(ctx, "CypherBatch", statementCountKey.Int(len(queries)))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !c.breaker.allow() {
+			return errCircuitOpen
+		}
+		span.SetAttributes(attemptsKey.Int(attempt + 1))
+		err := c.cypherBatch(ctx, queries)
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			c.breaker.abandon()
+			return ctxErr
+		}
+		c.breaker.record(isUnavailable(err))
+		if err == nil || attempt >= c.retries || !isTransient(err) {
+			return err
+		}
+
+		c.retried.Inc(1)
+		logger.WithError(err).Debugf("Transient error from Neo4j, trying the batch again (retry %d of %d)", attempt+1, c.retries)
+		if !c.sleep(ctx, c.backoff(attempt)) {
+			return ctx.Err()
+		}
+	}
+}
+
+//Connections which can't give up on a batch, such as those over HTTP, are left to finish it in the background, and as
+//it could still be committed the context's error is returned as it would be for a batch given up on while committing
+func (c *resilientConnection) cypherBatch(ctx context.Context, queries []*neoism.CypherQuery) error {
+	if conn, ok := c.NeoConnection.(contextConnection); ok {
+		return conn.CypherBatchContext(ctx, queries)
+	}
+	if ctx.Done() == nil {
+		return c.NeoConnection.CypherBatch(queries)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.NeoConnection.CypherBatch(queries)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Full jitter: a random delay up to the exponential backoff for the attempt, so that batches which failed together,
+//such as both sides of a deadlock, aren't tried again together
+func (c *resilientConnection) backoff(attempt int) time.Duration {
+	delay := c.maxDelay
+	if attempt < 30 && c.baseDelay<<uint(attempt) < c.maxDelay {
+		delay = c.baseDelay << uint(attempt)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+//Sleep for the delay, returning false without waiting for it to pass if the context is done first
+func sleepContext(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//Whether the batch failed without changing anything, for a reason that is likely to have gone when it is tried again:
+//a transaction rolled back because of a deadlock or other transient error, or a failure to connect to Neo4j at all.
+//Errors which Neo4j could have committed the batch before returning, such as a connection dropped while waiting for the
+//response, are not transient, as trying the batch again would fail its guards.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case rwapi.ConstraintOrTransactionError:
+		for _, message := range append([]string{e.Message}, e.Details...) {
+			for _, transient := range transientErrorMessages {
+				if strings.Contains(strings.ToLower(message), transient) {
+					return true
+				}
+			}
+		}
+	case *url.Error:
+		if opErr, ok := e.Err.(*net.OpError); ok {
+			return opErr.Op == "dial"
+		}
+	case boltConnectError:
+		return true
+	}
+	return false
+}
+
+//Whether Neo4j could not be reached at all, rather than returning an error
+func isUnavailable(err error) bool {
+	switch err.(type) {
+	case *url.Error, net.Error, boltConnectError:
+		return true
+	}
+	return neo4j.IsConnectivityError(err)
+}
+
+//Circuit breaker which is opened when too many attempts in a row fail to reach Neo4j, failing every attempt until the
+//cooldown has passed. Then a single attempt is allowed through, which closes the circuit again if it reaches Neo4j.
+type circuitBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	open      bool
+	probing   bool
+	openedAt  time.Time
+	now       func() time.Time
+	opened    metrics.Counter
+	state     metrics.Gauge
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, registry metrics.Registry) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		opened:    metrics.GetOrRegisterCounter("concepts.neo4j.circuit.opened", registry),
+		state:     metrics.GetOrRegisterGauge("concepts.neo4j.circuit.open", registry),
+	}
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+//Forget an attempt that was allowed but given up on before it was known whether Neo4j could be reached, so that a
+//probe cut off by its context lets the next attempt through rather than leaving the circuit open for good
+func (b *circuitBreaker) abandon() {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+}
+
+//Record the outcome of an attempt that was allowed
+func (b *circuitBreaker) record(unavailable bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if !unavailable {
+		if b.open {
+			logger.Info("Neo4j is available again, closing the circuit")
+		}
+		b.failures = 0
+		b.open = false
+		b.probing = false
+		b.state.Update(0)
+		return
+	}
+
+	b.failures++
+	if b.probing || (!b.open && b.failures >= b.threshold) {
+		if !b.open {
+			logger.Warnf("Failed to reach Neo4j %d times in a row, opening the circuit for %v", b.failures, b.cooldown)
+		}
+		b.open = true
+		b.probing = false
+		b.openedAt = b.now()
+		b.opened.Inc(1)
+		b.state.Update(1)
+	}
+}
@@ -0,0 +1,219 @@
+package concepts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/jmcvetta/neoism"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+//Connection which fails with each of the errors in turn, then succeeds
+type failingConnection struct {
+	errs  []error
+	calls int
+}
+
+func (c *failingConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	c.calls++
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func (c *failingConnection) EnsureConstraints(indexes map[string]string) error {
+	return nil
+}
+
+func (c *failingConnection) EnsureIndexes(indexes map[string]string) error {
+	return nil
+}
+
+var (
+	deadlockError = rwapi.ConstraintOrTransactionError{
+		Message: "Error with a query inside a transaction.",
+		Details: []string{"ForsetiClient[3] can't acquire ExclusiveLock{owner=ForsetiClient[7]} on NODE(42), because holders of that lock are waiting for ForsetiClient[3]."},
+	}
+	guardError = rwapi.ConstraintOrTransactionError{
+		Message: "Error with a query inside a transaction.",
+		Details: []string{"/ by zero"},
+	}
+	dialError         = &url.Error{Op: "Post", URL: "http://localhost:7474/db/data/transaction", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	boltDeadlockError = boltError(&neo4j.Neo4jError{Code: "Neo.TransientError.Transaction.DeadlockDetected", Msg: "ForsetiClient[3] can't acquire ExclusiveLock"}, false)
+	readError         = &url.Error{Op: "Post", URL: "http://localhost:7474/db/data/transaction", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+)
+
+func testResilientConnection(conn *failingConnection, registry metrics.Registry) *resilientConnection {
+	c := newResilientConnection(conn, registry)
+	c.sleep = func(context.Context, time.Duration) bool { return true }
+	return c
+}
+
+func TestCypherBatchRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		errs          []error
+		expectedErr   error
+		expectedCalls int
+	}{
+		{"Success", nil, nil, 1},
+		{"Deadlock", []error{deadlockError}, nil, 2},
+		{"Failure to connect", []error{dialError, dialError}, nil, 3},
+		{"Deadlock over Bolt", []error{boltDeadlockError}, nil, 2},
+		{"Failure to connect over Bolt", []error{boltConnectError{errors.New("connection refused")}}, nil, 2},
+		{"Transient errors every time", []error{deadlockError, deadlockError, deadlockError, deadlockError, deadlockError}, deadlockError, 4},
+		{"Failed guard", []error{guardError}, guardError, 1},
+		{"Connection lost after the batch was sent", []error{readError}, readError, 1},
+		{"Neo4j error", []error{neoism.NeoError{Message: "Invalid syntax"}}, neoism.NeoError{Message: "Invalid syntax"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := metrics.NewRegistry()
+			conn := &failingConnection{errs: test.errs}
+			err := testResilientConnection(conn, registry).CypherBatch(nil)
+			assert.Equal(t, test.expectedErr, err)
+			assert.Equal(t, test.expectedCalls, conn.calls)
+			assert.Equal(t, int64(test.expectedCalls-1), registry.Get("concepts.neo4j.retries").(metrics.Counter).Count())
+		})
+	}
+}
+
+func TestBackoffIsJitteredAndLimited(t *testing.T) {
+	c := testResilientConnection(&failingConnection{}, metrics.NewRegistry())
+	for attempt := 0; attempt < 40; attempt++ {
+		delay := c.backoff(attempt)
+		assert.True(t, delay >= 0 && delay < c.maxDelay, "Delay %v for attempt %d should be less than %v", delay, attempt, c.maxDelay)
+		if attempt < 4 {
+			assert.True(t, delay < c.baseDelay<<uint(attempt))
+		}
+	}
+}
+
+func TestCircuitBreakerOpensAndCloses(t *testing.T) {
+	registry := metrics.NewRegistry()
+	errs := make([]error, 2*neo4jCircuitThreshold)
+	for i := range errs {
+		errs[i] = readError
+	}
+	conn := &failingConnection{errs: errs}
+	c := testResilientConnection(conn, registry)
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < neo4jCircuitThreshold; i++ {
+		assert.Equal(t, readError, c.CypherBatch(nil))
+	}
+	assert.Equal(t, errCircuitOpen, c.CypherBatch(nil), "The circuit should be open after too many failures in a row")
+	assert.Equal(t, neo4jCircuitThreshold, conn.calls, "Neo4j should not be tried while the circuit is open")
+	assert.Equal(t, int64(1), registry.Get("concepts.neo4j.circuit.open").(metrics.Gauge).Value())
+
+	now = now.Add(neo4jCircuitCooldown)
+	assert.Equal(t, readError, c.CypherBatch(nil), "A single attempt should be allowed once the circuit has cooled down")
+	assert.Equal(t, errCircuitOpen, c.CypherBatch(nil), "The circuit should open again if the attempt fails")
+	assert.Equal(t, int64(2), registry.Get("concepts.neo4j.circuit.opened").(metrics.Counter).Count())
+
+	now = now.Add(neo4jCircuitCooldown)
+	conn.errs = nil
+	assert.NoError(t, c.CypherBatch(nil))
+	assert.NoError(t, c.CypherBatch(nil), "The circuit should close once Neo4j can be reached")
+	assert.Equal(t, int64(0), registry.Get("concepts.neo4j.circuit.open").(metrics.Gauge).Value())
+}
+
+func TestCircuitBreakerIgnoresErrorsFromNeo4j(t *testing.T) {
+	errs := make([]error, 2*neo4jCircuitThreshold)
+	for i := range errs {
+		errs[i] = guardError
+	}
+	conn := &failingConnection{errs: errs}
+	c := testResilientConnection(conn, metrics.NewRegistry())
+	for range errs {
+		assert.Equal(t, guardError, c.CypherBatch(nil))
+	}
+	assert.Equal(t, len(errs), conn.calls, "Errors returned by Neo4j should not open the circuit")
+}
+
+//Connection which doesn't respond until it is released
+type stuckConnection struct {
+	failingConnection
+	release chan struct{}
+}
+
+func (c *stuckConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	<-c.release
+	return c.failingConnection.CypherBatch(queries)
+}
+
+func TestCypherBatchGivesUpWhenTheContextIsDone(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn := &failingConnection{}
+	assert.Equal(t, context.Canceled, testResilientConnection(conn, metrics.NewRegistry()).CypherBatchContext(cancelled, nil))
+	assert.Equal(t, 0, conn.calls, "A batch should not be started once the context is done")
+
+	stuck := &stuckConnection{release: make(chan struct{})}
+	defer close(stuck.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	registry := metrics.NewRegistry()
+	assert.Equal(t, context.DeadlineExceeded, newResilientConnection(stuck, registry).CypherBatchContext(ctx, nil))
+	assert.Equal(t, int64(0), registry.Get("concepts.neo4j.circuit.open").(metrics.Gauge).Value(), "A batch that timed out should not open the circuit")
+
+	deadlocked := &failingConnection{errs: []error{deadlockError, deadlockError}}
+	c := newResilientConnection(deadlocked, metrics.NewRegistry())
+	c.baseDelay = 24 * time.Hour
+	c.maxDelay = 24 * time.Hour
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.CypherBatchContext(ctx, nil), "The batch should not be tried again after the deadline")
+	assert.Equal(t, 1, deadlocked.calls)
+}
+
+//Connection which fails with each of its errors in turn, and which doesn't respond until the context is done while stuck
+type probedConnection struct {
+	failingConnection
+	stuck bool
+}
+
+func (c *probedConnection) CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	if c.stuck {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return c.failingConnection.CypherBatch(queries)
+}
+
+func TestCircuitBreakerProbesAgainAfterAProbeIsGivenUpOn(t *testing.T) {
+	errs := make([]error, neo4jCircuitThreshold)
+	for i := range errs {
+		errs[i] = readError
+	}
+	conn := &probedConnection{failingConnection: failingConnection{errs: errs}}
+	c := newResilientConnection(conn, metrics.NewRegistry())
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+	for range errs {
+		assert.Equal(t, readError, c.CypherBatch(nil))
+	}
+	assert.Equal(t, errCircuitOpen, c.CypherBatch(nil))
+
+	now = now.Add(neo4jCircuitCooldown)
+	conn.stuck = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.CypherBatchContext(ctx, nil))
+
+	conn.stuck = false
+	assert.NoError(t, c.CypherBatch(nil), "Another probe should be allowed once one has been given up on")
+	assert.NoError(t, c.CypherBatch(nil), "The circuit should close once a probe reaches Neo4j")
+}
@@ -0,0 +1,231 @@
+package concepts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	logger "github.com/Financial-Times/go-logger"
+)
+
+// StoreConnector - connects to the store concepts are kept in. A store may be returned along with an error, if it was
+// created but could not reach the database, in which case it is kept and checked again rather than connected to again.
+type StoreConnector func() (ConceptStore, error)
+
+//Error of everything asked of a service before it has connected to its store and applied the migrations
+type notReadyError struct {
+	cause error
+}
+
+func (e notReadyError) Error() string {
+	return fmt.Sprintf("Not ready to read or write concepts until connected to Neo4j and migrated: %v", e.cause)
+}
+
+var errStarting = errors.New("still starting")
+
+//Service which may not be ready to read or write concepts yet, and whose requests are turned away until it is
+type readinessChecker interface {
+	Ready() error
+}
+
+//Store which fails every call with a notReadyError until the store it stands in for has been started, after which
+//it passes every call on to that store
+type startingStore struct {
+	sync.RWMutex
+	store ConceptStore
+	err   error
+}
+
+// NewStartingConceptService - service which can't read or write concepts until Start has connected to the store and
+// applied the migrations, and which publishes the events of every write and delete if it is given a publisher
+func NewStartingConceptService(publisher EventPublisher) ConceptService {
+	return NewConceptServiceWithStore(&startingStore{err: errStarting}, publisher)
+}
+
+// Start - connects to the store and, if migrate is set, applies the migrations yet to be applied, trying again every
+// interval until both succeed or the context is done. Until then the service isn't ready and fails the healthcheck.
+func (s *ConceptService) Start(ctx context.Context, connect StoreConnector, migrate bool, interval time.Duration) error {
+	starting, ok := s.store.(*startingStore)
+	if !ok {
+		return nil
+	}
+
+	var store ConceptStore
+	for attempt := 1; ; attempt++ {
+		var err error
+		store, err = startStore(ctx, store, connect, migrate)
+		if err == nil {
+			starting.started(store)
+			logger.Info("Ready to read and write concepts")
+			return nil
+		}
+
+		starting.failed(err)
+		logger.WithError(err).Errorf("Could not start, trying again in %s (attempt %d)", interval, attempt)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+//Connect to the store, unless an earlier attempt already created it, check it can be reached and apply the migrations,
+//returning the store so that the next attempt can carry on with it
+func startStore(ctx context.Context, store ConceptStore, connect StoreConnector, migrate bool) (ConceptStore, error) {
+	if store == nil {
+		var err error
+		if store, err = connect(); store == nil {
+			return nil, err
+		}
+	}
+	if err := store.check(); err != nil {
+		return store, err
+	}
+	if !migrate {
+		return store, nil
+	}
+
+	applied, err := store.migrate(ctx)
+	for _, migration := range applied {
+		logger.Infof("Applied migration %d: %s", migration.Version, migration.Description)
+	}
+	return store, err
+}
+
+// Ready - nil once the service has connected to its store and applied the migrations, or why it hasn't yet
+func (s *ConceptService) Ready() error {
+	if starting, ok := s.store.(*startingStore); ok {
+		_, err := starting.current()
+		return err
+	}
+	return nil
+}
+
+func (s *startingStore) started(store ConceptStore) {
+	s.Lock()
+	defer s.Unlock()
+	s.store = store
+	s.err = nil
+}
+
+func (s *startingStore) failed(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = err
+}
+
+func (s *startingStore) current() (ConceptStore, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.store == nil {
+		return nil, notReadyError{s.err}
+	}
+	return s.store, nil
+}
+
+func (s *startingStore) migrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return store.migrationStatus(ctx)
+}
+
+func (s *startingStore) migrate(ctx context.Context) ([]MigrationStatus, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return store.migrate(ctx)
+}
+
+func (s *startingStore) check() error {
+	store, err := s.current()
+	if err != nil {
+		return err
+	}
+	return store.check()
+}
+
+func (s *startingStore) readConcept(ctx context.Context, prefUUID string, transID string) (AggregatedConcept, bool, error) {
+	store, err := s.current()
+	if err != nil {
+		return AggregatedConcept{}, false, err
+	}
+	return store.readConcept(ctx, prefUUID, transID)
+}
+
+func (s *startingStore) exportConcepts(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, "", err
+	}
+	return store.exportConcepts(ctx, conceptType, after, limit, transID)
+}
+
+func (s *startingStore) readEquivalence(ctx context.Context, sourceUUID string, transID string) ([]equivalenceResult, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return store.readEquivalence(ctx, sourceUUID, transID)
+}
+
+func (s *startingStore) readIssued(ctx context.Context, issuerUUID string, transID string) ([]string, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return store.readIssued(ctx, issuerUUID, transID)
+}
+
+func (s *startingStore) readDependants(ctx context.Context, prefUUID string, transID string) ([]string, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return store.readDependants(ctx, prefUUID, transID)
+}
+
+func (s *startingStore) readIdentified(ctx context.Context, authority string, value string, transID string) ([]identifiedConcept, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return store.readIdentified(ctx, authority, value, transID)
+}
+
+func (s *startingStore) write(ctx context.Context, w conceptWrite, transID string) error {
+	store, err := s.current()
+	if err != nil {
+		return err
+	}
+	return store.write(ctx, w, transID)
+}
+
+func (s *startingStore) readEvents(ctx context.Context, after eventCursor, limit int, transID string) ([]OutboxEvent, error) {
+	store, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return store.readEvents(ctx, after, limit, transID)
+}
+
+func (s *startingStore) acknowledgeEvents(ctx context.Context, upTo eventCursor, transID string) (int, error) {
+	store, err := s.current()
+	if err != nil {
+		return 0, err
+	}
+	return store.acknowledgeEvents(ctx, upTo, transID)
+}
+
+func (s *startingStore) expireEvents(ctx context.Context, olderThan time.Duration, transID string) (int, error) {
+	store, err := s.current()
+	if err != nil {
+		return 0, err
+	}
+	return store.expireEvents(ctx, olderThan, transID)
+}
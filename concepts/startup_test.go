@@ -0,0 +1,123 @@
+package concepts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+//Store which can't be reached until it has been checked a number of times
+type unreachableStore struct {
+	ConceptStore
+	failedChecks int
+	migrations   int
+}
+
+func (s *unreachableStore) check() error {
+	if s.failedChecks > 0 {
+		s.failedChecks--
+		return errors.New("TEST Neo4j is unreachable")
+	}
+	return nil
+}
+
+func (s *unreachableStore) migrate(ctx context.Context) ([]MigrationStatus, error) {
+	s.migrations++
+	return nil, nil
+}
+
+func TestStartTriesAgainUntilConnectedAndMigrated(t *testing.T) {
+	tests := []struct {
+		name               string
+		failedConnections  int
+		failedChecks       int
+		migrate            bool
+		expectedAttempts   int
+		expectedMigrations int
+	}{
+		{"Connected first time", 0, 0, true, 1, 1},
+		{"Connection refused", 2, 0, true, 3, 1},
+		{"Created but unreachable", 0, 2, true, 1, 1},
+		{"Without migrating", 0, 0, false, 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &unreachableStore{ConceptStore: NewMemoryConceptStore(), failedChecks: test.failedChecks}
+			attempts := 0
+			connect := func() (ConceptStore, error) {
+				attempts++
+				if attempts <= test.failedConnections {
+					return nil, errors.New("TEST connection refused")
+				}
+				return store, nil
+			}
+
+			service := NewStartingConceptService(nil)
+			assert.Error(t, service.Ready(), "The service should not be ready before it has started")
+			err := service.Start(context.Background(), connect, test.migrate, time.Millisecond)
+			assert.NoError(t, err)
+			assert.NoError(t, service.Ready())
+			assert.NoError(t, service.Check())
+			assert.Equal(t, test.expectedAttempts, attempts, "A store created but not reached should be checked again rather than connected to again")
+			assert.Equal(t, test.expectedMigrations, store.migrations)
+		})
+	}
+}
+
+func TestStartGivesUpWhenTheContextIsDone(t *testing.T) {
+	connect := func() (ConceptStore, error) {
+		return nil, errors.New("TEST connection refused")
+	}
+	service := NewStartingConceptService(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := service.Start(ctx, connect, true, time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.EqualError(t, service.Ready(), "Not ready to read or write concepts until connected to Neo4j and migrated: TEST connection refused")
+}
+
+func TestRequestsAreTurnedAwayUntilReady(t *testing.T) {
+	service := NewStartingConceptService(nil)
+	handler := ConceptsHandler{ConceptsService: &service}
+	r := mux.NewRouter()
+	handler.RegisterHandlers(r)
+	handler.RegisterAdminHandlers(r, "", "", "")
+	notReady := fmt.Sprintf("{\"message\": \"%s\"}\n", notReadyError{errStarting})
+
+	tests := []struct {
+		req        *http.Request
+		statusCode int
+		body       string
+	}{
+		{newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t), http.StatusServiceUnavailable, notReady},
+		{newRequest("PUT", fmt.Sprintf("/dummies/%s", knownUUID), t), http.StatusServiceUnavailable, notReady},
+		{newRequest("GET", "/__export?type=Dummy", t), http.StatusServiceUnavailable, notReady},
+		{newRequest("GET", "/__gtg", t), http.StatusServiceUnavailable, notReadyError{errStarting}.Error()},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, test.req)
+		assert.Equal(t, test.statusCode, rec.Code, "%s %s", test.req.Method, test.req.URL)
+		assert.Equal(t, test.body, rec.Body.String(), "%s %s", test.req.Method, test.req.URL)
+	}
+
+	err := service.Start(context.Background(), func() (ConceptStore, error) {
+		return NewMemoryConceptStore(), nil
+	}, true, time.Millisecond)
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "Requests should be served once the service is ready")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", "/__gtg", t))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
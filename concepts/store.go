@@ -0,0 +1,67 @@
+package concepts
+
+import (
+	"context"
+	"time"
+)
+
+//ConceptStore - the graph that concepts are stored in. The concordance rules, events and locking are the service's, while
+//the store reads and writes the canonical nodes, the source nodes equivalent to them, and their relationships and
+//identifiers. It is implemented by the Neo4j store and by the in-memory store used for local development and tests.
+//Reads and writes give up with the context's error once it is done.
+type ConceptStore interface {
+	//Every migration of the store's schema and data, and when each was applied
+	migrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	//Apply the migrations yet to be applied, in order of version, while no other replica is, returning those applied
+	migrate(ctx context.Context) ([]MigrationStatus, error)
+	check() error
+	//The canonical node with the prefUUID, aggregated with its sources, if it has any
+	readConcept(ctx context.Context, prefUUID string, transID string) (AggregatedConcept, bool, error)
+	//A page of the canonical nodes of a type, ordered by prefUUID, and the prefUUID to start the next page after
+	exportConcepts(ctx context.Context, conceptType string, after string, limit int, transID string) ([]AggregatedConcept, string, error)
+	//The source node with the uuid, if it exists, along with the canonical node it is equivalent to and how many
+	//sources that canonical node has
+	readEquivalence(ctx context.Context, sourceUUID string, transID string) ([]equivalenceResult, error)
+	//The uuids of the financial instruments issued by the organisation
+	readIssued(ctx context.Context, issuerUUID string, transID string) ([]string, error)
+	//The uuids of things outside of the concordance that have relationships to any of the sources of the prefUUID
+	readDependants(ctx context.Context, prefUUID string, transID string) ([]string, error)
+	//The concepts with the identifier or natural key of the authority, which must be a known one
+	readIdentified(ctx context.Context, authority string, value string, transID string) ([]identifiedConcept, error)
+	write(ctx context.Context, w conceptWrite, transID string) error
+	//At most limit events from the outbox after the given position, oldest first
+	readEvents(ctx context.Context, after eventCursor, limit int, transID string) ([]OutboxEvent, error)
+	//Remove every event up to and including the given position from the outbox, returning how many were removed
+	acknowledgeEvents(ctx context.Context, upTo eventCursor, transID string) (int, error)
+	//Remove every event older than the given age from the outbox, returning how many were removed
+	expireEvents(ctx context.Context, olderThan time.Duration, transID string) (int, error)
+}
+
+//A write or delete of a concept, committed by the store as a single transaction and only if none of the concordances
+//it was decided on have changed since they were read. Otherwise the store fails with a
+//rwapi.ConstraintOrTransactionError, as it does for any other conflicting write.
+type conceptWrite struct {
+	prefUUID string
+	//The number of sources and aggregate hash the canonical node was read with, which it must still have
+	sourceCount   int
+	aggregateHash string
+	precondition  *Precondition
+	//The equivalence of each source being concorded to the concept, as it was read
+	transferred map[string][]equivalenceResult
+	//Sources removed from the concordance, which are each given a canonical node of their own
+	unconcorded []Concept
+	//Canonical nodes to delete, as their sources are concorded to the concept or it is being deleted
+	deletedCanonicals []string
+	//The concept to write, or nil if it is being deleted
+	concept *AggregatedConcept
+	//Financial instruments which are no longer issued by the concept's issuer, as the concept is now
+	reissued []string
+	events   []Event
+}
+
+//A concept resolved from an identifier: the source with the identifier and its canonical node, if it has one
+type identifiedConcept struct {
+	UUID     string   `json:"uuid"`
+	PrefUUID string   `json:"prefUUID"`
+	Types    []string `json:"types"`
+}
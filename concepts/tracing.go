@@ -0,0 +1,99 @@
+package concepts
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Financial-Times/concepts-rw-neo4j/concepts"
+
+//Exporters spans can be sent to
+const (
+	OTLPTraceExporter   = "otlp"
+	StdoutTraceExporter = "stdout"
+)
+
+//Span attributes
+const (
+	transactionIDKey  = attribute.Key("transaction_id")
+	prefUUIDKey       = attribute.Key("concept.pref_uuid")
+	conceptTypeKey    = attribute.Key("concept.type")
+	sourceCountKey    = attribute.Key("concept.source_count")
+	statementCountKey = attribute.Key("db.statement_count")
+	attemptsKey       = attribute.Key("db.attempts")
+)
+
+// NewTracerProvider - provider of tracers which batch the spans of the given service to the OTLP endpoint configured by the
+// standard OTEL_EXPORTER_OTLP_* environment variables, or print them to stdout. It must be shut down to send the last of them.
+func NewTracerProvider(ctx context.Context, exporterName string, serviceName string) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case OTLPTraceExporter:
+		exporter, err = otlptracehttp.New(ctx)
+	case StdoutTraceExporter:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		err = fmt.Errorf("unknown trace exporter %q, expected %q or %q", exporterName, OTLPTraceExporter, StdoutTraceExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)), nil
+}
+
+//Starts a span with the tracer of the global tracer provider, which doesn't record anything unless tracing is enabled
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+//Ends the span, recording the error it ended with, if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//Starts a span of the request, continuing the trace propagated in its headers if there is one. The returned function ends
+//the span with the status code of the response, which is only an error for a 5xx response.
+func traceRequest(w http.ResponseWriter, r *http.Request, name string, attributes ...attribute.KeyValue) (http.ResponseWriter, *http.Request, func()) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := startSpan(ctx, name, append(attributes, semconv.HTTPMethodKey.String(r.Method), semconv.HTTPTargetKey.String(r.URL.Path))...)
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	return recorder, r.WithContext(ctx), func() {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+		span.End()
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,217 @@
+package concepts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jmcvetta/neoism"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//Records the spans of every tracer until the returned function is called, after which nothing is traced
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder, func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}
+}
+
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("No %s span was ended", name)
+	return nil
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func TestWritePhasesAreTraced(t *testing.T) {
+	tests := []struct {
+		name           string
+		setUpConcepts  []string
+		concept        string
+		expectedPhases []string
+	}{
+		{"New concept", nil, "single-concordance.json", []string{"Write.readExisting", "Write.transferAnalysis", "Write.commit"}},
+		{"Unchanged concept", []string{"single-concordance.json"}, "single-concordance.json", []string{"Write.readExisting"}},
+		{"Transferred source", []string{"dual-concordance.json"}, "transfer-source-concordance.json", []string{"Write.readExisting", "Write.transferAnalysis", "Write.commit"}},
+		{"Issued financial instrument", nil, "financial-instrument.json", []string{"Write.readExisting", "Write.transferAnalysis", "Write.issuerCheck", "Write.commit"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer cleanDB(t)
+			for _, name := range test.setUpConcepts {
+				_, err := conceptsDriver.Write(context.Background(), getAggregatedConcept(t, name), "test_tid")
+				assert.NoError(t, err, "Failed to write concept")
+			}
+
+			recorder, stopRecording := recordSpans()
+			defer stopRecording()
+			concept := getAggregatedConcept(t, test.concept)
+			_, err := conceptsDriver.Write(context.Background(), concept, "test_tid")
+			assert.NoError(t, err, "Failed to write concept")
+
+			write := endedSpan(t, recorder, "ConceptService.Write")
+			attributes := spanAttributes(write)
+			assert.Equal(t, "test_tid", attributes[transactionIDKey].AsString())
+			assert.Equal(t, concept.PrefUUID, attributes[prefUUIDKey].AsString())
+			assert.Equal(t, concept.Type, attributes[conceptTypeKey].AsString())
+
+			var phases []string
+			for _, span := range recorder.Ended() {
+				if span.Parent().SpanID() == write.SpanContext().SpanID() {
+					phases = append(phases, span.Name())
+				}
+			}
+			assert.Equal(t, test.expectedPhases, phases)
+		})
+	}
+}
+
+func TestCypherBatchesAreTraced(t *testing.T) {
+	tests := []struct {
+		name               string
+		errs               []error
+		expectedAttempts   int64
+		expectedStatusCode codes.Code
+	}{
+		{"Success", nil, 1, codes.Unset},
+		{"Deadlock", []error{deadlockError}, 2, codes.Unset},
+		{"Failed guard", []error{guardError}, 1, codes.Error},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder, stopRecording := recordSpans()
+			defer stopRecording()
+
+			c := testResilientConnection(&failingConnection{errs: test.errs}, metrics.NewRegistry())
+			c.CypherBatch([]*neoism.CypherQuery{{Statement: "MATCH (n) RETURN n"}, {Statement: "MATCH (n) RETURN n"}})
+
+			span := endedSpan(t, recorder, "CypherBatch")
+			attributes := spanAttributes(span)
+			assert.Equal(t, int64(2), attributes[statementCountKey].AsInt64())
+			assert.Equal(t, test.expectedAttempts, attributes[attemptsKey].AsInt64())
+			assert.Equal(t, test.expectedStatusCode, span.Status().Code)
+		})
+	}
+}
+
+func TestRequestsAreTraced(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name               string
+		req                *http.Request
+		spanName           string
+		service            *mockConceptService
+		expectedStatusCode int64
+		expectedSpanStatus codes.Code
+	}{
+		{
+			name:     "Put",
+			req:      newRequest("PUT", fmt.Sprintf("/dummies/%s", knownUUID), t),
+			spanName: "PutConcept",
+			service: &mockConceptService{
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+				},
+				write: func(ctx context.Context, thing interface{}, transID string) (interface{}, error) {
+					return ConceptChanges{}, nil
+				},
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedSpanStatus: codes.Unset,
+		},
+		{
+			name:     "Patch",
+			req:      newPatchRequest(fmt.Sprintf("/dummies/%s", knownUUID), `{"strapline":"New strapline"}`, t),
+			spanName: "PatchConcept",
+			service: &mockConceptService{
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, true, nil
+				},
+				decodeJSON: func(decoder *json.Decoder) (interface{}, string, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, knownUUID, nil
+				},
+				writeWithOptions: func(ctx context.Context, thing interface{}, transID string, options WriteOptions) (interface{}, error) {
+					return ConceptChanges{}, nil
+				},
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedSpanStatus: codes.Unset,
+		},
+		{
+			name:     "Get",
+			req:      newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t),
+			spanName: "GetConcept",
+			service: &mockConceptService{
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return AggregatedConcept{PrefUUID: knownUUID, Type: "Dummy"}, true, nil
+				},
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedSpanStatus: codes.Unset,
+		},
+		{
+			name:     "GetFailure",
+			req:      newRequest("GET", fmt.Sprintf("/dummies/%s", knownUUID), t),
+			spanName: "GetConcept",
+			service: &mockConceptService{
+				read: func(ctx context.Context, uuid string, transID string) (interface{}, bool, error) {
+					return nil, false, errors.New("TEST failing to READ")
+				},
+			},
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedSpanStatus: codes.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder, stopRecording := recordSpans()
+			defer stopRecording()
+
+			test.req.Header.Set("X-Request-Id", "test_tid")
+			test.req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			r := mux.NewRouter()
+			handler := ConceptsHandler{ConceptsService: test.service}
+			handler.RegisterHandlers(r)
+			r.ServeHTTP(httptest.NewRecorder(), test.req)
+
+			span := endedSpan(t, recorder, test.spanName)
+			attributes := spanAttributes(span)
+			assert.Equal(t, traceID, span.SpanContext().TraceID().String(), "The span should continue the trace of the request")
+			assert.Equal(t, "test_tid", attributes[transactionIDKey].AsString())
+			assert.Equal(t, knownUUID, attributes[prefUUIDKey].AsString())
+			assert.Equal(t, test.expectedStatusCode, attributes["http.status_code"].AsInt64())
+			assert.Equal(t, test.expectedSpanStatus, span.Status().Code)
+		})
+	}
+}
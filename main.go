@@ -1,26 +1,55 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/Financial-Times/concepts-rw-neo4j/concepts"
 	logger "github.com/Financial-Times/go-logger"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
 	"github.com/gorilla/mux"
 	cli "github.com/jawher/mow.cli"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 const appDescription = "A RESTful API for managing Concepts in Neo4j"
 const serviceName = "concepts-rw-neo4j"
 
+//How long an admin response can take, which is longer than any other so that a CPU profile or trace can run for a few
+//minutes, pprof turning away any asked to run for longer
+const adminWriteTimeout = 10 * time.Minute
+
+//How often events older than --events-retention are removed from the outbox
+const eventExpiryInterval = time.Hour
+
+//Number of statements sent to neo4j at a time over HTTP
+const defaultBatchSize = 1024
+
 type ServerConf struct {
 	AppSystemCode    string
 	AppName          string
 	Port             int
+	AdminPort        int
 	RequestLoggingOn bool
+	//Certificate and key to serve the API over TLS with, if both are set
+	TLSCertFile string
+	TLSKeyFile  string
+	//Timeouts of the API's connections
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	//How long in-flight requests have to finish when the server is stopped
+	ShutdownTimeout time.Duration
 }
 
 func main() {
@@ -40,19 +69,85 @@ func main() {
 	neoURL := app.String(cli.StringOpt{
 		Name:   "neo-url",
 		Value:  "http://localhost:7474/db/data",
-		Desc:   "neo4j endpoint URL",
+		Desc:   "neo4j endpoint URL, either bolt://, neo4j:// (or their +s and +ssc encrypted schemes) or http://.../db/data, or \"memory\" to keep concepts in memory for local development",
 		EnvVar: "NEO_URL",
 	})
+	neoUsername := app.String(cli.StringOpt{
+		Name:   "neo-username",
+		Value:  "",
+		Desc:   "Username to connect to neo4j over bolt with, or empty to connect without authentication",
+		EnvVar: "NEO_USERNAME",
+	})
+	neoPassword := app.String(cli.StringOpt{
+		Name:   "neo-password",
+		Value:  "",
+		Desc:   "Password to connect to neo4j over bolt with",
+		EnvVar: "NEO_PASSWORD",
+	})
+	neoCAFile := app.String(cli.StringOpt{
+		Name:   "neo-ca-file",
+		Value:  "",
+		Desc:   "PEM file of the certificate authorities to verify neo4j's certificate with over bolt+s or neo4j+s, instead of those of the system",
+		EnvVar: "NEO_CA_FILE",
+	})
 	port := app.Int(cli.IntOpt{
 		Name:   "port",
 		Value:  8080,
 		Desc:   "Port to listen on",
 		EnvVar: "APP_PORT",
 	})
+	adminPort := app.Int(cli.IntOpt{
+		Name:   "admin-port",
+		Value:  8081,
+		Desc:   "Port to serve the healthcheck, good to go, build info, metrics and pprof endpoints on",
+		EnvVar: "ADMIN_PORT",
+	})
+	tlsCertFile := app.String(cli.StringOpt{
+		Name:   "tls-cert-file",
+		Value:  "",
+		Desc:   "PEM file of the certificate to serve the API over TLS with, along with --tls-key-file",
+		EnvVar: "TLS_CERT_FILE",
+	})
+	tlsKeyFile := app.String(cli.StringOpt{
+		Name:   "tls-key-file",
+		Value:  "",
+		Desc:   "PEM file of the private key of --tls-cert-file",
+		EnvVar: "TLS_KEY_FILE",
+	})
+	httpReadHeaderTimeout := app.String(cli.StringOpt{
+		Name:   "http-read-header-timeout",
+		Value:  "10s",
+		Desc:   "How long a client can take to send the headers of a request",
+		EnvVar: "HTTP_READ_HEADER_TIMEOUT",
+	})
+	httpReadTimeout := app.String(cli.StringOpt{
+		Name:   "http-read-timeout",
+		Value:  "5m",
+		Desc:   "How long a client can take to send a whole request, including the body of a bulk write",
+		EnvVar: "HTTP_READ_TIMEOUT",
+	})
+	httpWriteTimeout := app.String(cli.StringOpt{
+		Name:   "http-write-timeout",
+		Value:  "5m",
+		Desc:   "How long a response can take, from the end of the request's headers, including streaming an export or the results of a bulk write",
+		EnvVar: "HTTP_WRITE_TIMEOUT",
+	})
+	httpIdleTimeout := app.String(cli.StringOpt{
+		Name:   "http-idle-timeout",
+		Value:  "2m",
+		Desc:   "How long a keep-alive connection is kept open waiting for the next request",
+		EnvVar: "HTTP_IDLE_TIMEOUT",
+	})
+	shutdownTimeout := app.String(cli.StringOpt{
+		Name:   "shutdown-timeout",
+		Value:  "30s",
+		Desc:   "How long in-flight requests have to finish on SIGTERM before the service exits, which should be at least the write timeout",
+		EnvVar: "SHUTDOWN_TIMEOUT",
+	})
 	batchSize := app.Int(cli.IntOpt{
 		Name:   "batchSize",
-		Value:  1024,
-		Desc:   "Maximum number of statements to execute per batch",
+		Value:  defaultBatchSize,
+		Desc:   "Maximum number of statements to execute per batch over HTTP, deprecated as it is ignored over Bolt",
 		EnvVar: "BATCH_SIZE",
 	})
 	requestLoggingOn := app.Bool(cli.BoolOpt{
@@ -67,47 +162,357 @@ func main() {
 		Desc:   "Level of logging to be shown",
 		EnvVar: "LOG_LEVEL",
 	})
+	eventsFile := app.String(cli.StringOpt{
+		Name:   "events-file",
+		Value:  "",
+		Desc:   "File to append the events of every write to as newline delimited JSON, as well as returning them in the response",
+		EnvVar: "EVENTS_FILE",
+	})
+	eventsRetention := app.String(cli.StringOpt{
+		Name:   "events-retention",
+		Value:  "168h",
+		Desc:   "How long events are kept in the outbox if they are not acknowledged, or 0 to keep them until they are",
+		EnvVar: "EVENTS_RETENTION",
+	})
+	readTimeout := app.String(cli.StringOpt{
+		Name:   "read-timeout",
+		Value:  "10s",
+		Desc:   "How long reading a concept can take before a 504 is returned, or 0 for no limit",
+		EnvVar: "READ_TIMEOUT",
+	})
+	writeTimeout := app.String(cli.StringOpt{
+		Name:   "write-timeout",
+		Value:  "30s",
+		Desc:   "How long writing a concept can take before it is rolled back and a 504 is returned, or 0 for no limit",
+		EnvVar: "WRITE_TIMEOUT",
+	})
+	readCacheSize := app.Int(cli.IntOpt{
+		Name:   "read-cache-size",
+		Value:  0,
+		Desc:   "Maximum number of concepts to keep in memory as they are read, or 0 not to cache them",
+		EnvVar: "READ_CACHE_SIZE",
+	})
+	readCacheTTL := app.String(cli.StringOpt{
+		Name:   "read-cache-ttl",
+		Value:  "1m",
+		Desc:   "How long a cached concept is used for before it is read again, or 0 to keep it until it is written",
+		EnvVar: "READ_CACHE_TTL",
+	})
+	migrateOnStart := app.Bool(cli.BoolOpt{
+		Name:   "migrate-on-start",
+		Value:  true,
+		Desc:   "Whether to apply the migrations of neo4j's schema and data which are yet to be before writing concepts, waiting for any other replica applying them to finish",
+		EnvVar: "MIGRATE_ON_START",
+	})
+	startupRetryInterval := app.String(cli.StringOpt{
+		Name:   "startup-retry-interval",
+		Value:  "5s",
+		Desc:   "How long to wait before trying again to connect to neo4j and apply the migrations, while starting up",
+		EnvVar: "STARTUP_RETRY_INTERVAL",
+	})
+	tracingExporter := app.String(cli.StringOpt{
+		Name:   "tracing-exporter",
+		Value:  "",
+		Desc:   "Where to send OpenTelemetry spans: \"otlp\" to the endpoint set by OTEL_EXPORTER_OTLP_ENDPOINT, \"stdout\" to print them, or empty not to trace",
+		EnvVar: "TRACING_EXPORTER",
+	})
 
 	logger.InitLogger(*appName, *logLevel)
-	app.Action = func() {
-		conf := neoutils.DefaultConnectionConfig()
-		conf.BatchSize = *batchSize
-		db, err := neoutils.Connect(*neoURL, conf)
+	boltConf := concepts.BoltConfig{
+		Username: *neoUsername,
+		Password: *neoPassword,
+		CAFile:   *neoCAFile,
+	}
+	app.Command("import", "Write concepts from files of newline delimited JSON to neo4j, without starting the server", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--workers] [--checkpoint] [--progress-interval] FILE..."
+		workers := cmd.Int(cli.IntOpt{
+			Name:   "workers",
+			Value:  8,
+			Desc:   "Number of concepts to write at the same time",
+			EnvVar: "IMPORT_WORKERS",
+		})
+		checkpoint := cmd.String(cli.StringOpt{
+			Name:   "checkpoint",
+			Value:  "",
+			Desc:   "File to record progress in, so that an interrupted import resumes where it stopped",
+			EnvVar: "IMPORT_CHECKPOINT",
+		})
+		progressInterval := cmd.String(cli.StringOpt{
+			Name:   "progress-interval",
+			Value:  "10s",
+			Desc:   "How often to log progress and save the checkpoint",
+			EnvVar: "IMPORT_PROGRESS_INTERVAL",
+		})
+		files := cmd.Strings(cli.StringsArg{
+			Name: "FILE",
+			Desc: "Files of concepts to import, each line being a concept as it would be PUT",
+		})
+
+		cmd.Action = func() {
+			defer startTracing(*tracingExporter, *appSystemCode)()
+			interval := mustParseDuration("progress interval", *progressInterval)
+
+			conceptsService := newConceptService(mustConnect(*neoURL, *batchSize, boltConf), *eventsFile)
+			if *migrateOnStart {
+				if err := migrateUp(&conceptsService); err != nil {
+					logger.Fatalf("Migrations failed, not importing against a schema that may be out of date: %v", err)
+				}
+			}
 
-		if err != nil {
-			logger.Errorf("Could not connect to neo4j, error=[%s]\n", err)
+			importer := concepts.Importer{
+				ConceptsService:  &conceptsService,
+				Workers:          *workers,
+				CheckpointFile:   *checkpoint,
+				ProgressInterval: interval,
+			}
+			stats, err := importer.Import(*files)
+			if err != nil {
+				logger.Fatalf("Import failed after writing %d concepts: %v", stats.Written, err)
+			}
+			logger.Infof("Import finished, %d concepts written and %d failed", stats.Written, stats.Failed)
 		}
+	})
+
+	app.Command("migrate", "Apply or list the migrations of neo4j's schema and data, without starting the server", func(cmd *cli.Cmd) {
+		cmd.Command("up", "Apply the migrations which are yet to be, waiting for any other replica applying them to finish", func(cmd *cli.Cmd) {
+			cmd.Action = func() {
+				conceptsService := newConceptService(mustConnect(*neoURL, *batchSize, boltConf), "")
+				if err := migrateUp(&conceptsService); err != nil {
+					logger.Fatalf("Migrations failed: %v", err)
+				}
+			}
+		})
+		cmd.Command("status", "List the migrations and when each was applied", func(cmd *cli.Cmd) {
+			cmd.Action = func() {
+				conceptsService := newConceptService(mustConnect(*neoURL, *batchSize, boltConf), "")
+				migrations, err := conceptsService.MigrationStatus(context.Background())
+				if err != nil {
+					logger.Fatalf("Could not read the migrations applied: %v", err)
+				}
+				printMigrationStatus(os.Stdout, migrations)
+			}
+		})
+	})
+
+	app.Action = func() {
+		defer startTracing(*tracingExporter, *appSystemCode)()
 
+		if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+			logger.Fatalf("Both --tls-cert-file and --tls-key-file are needed to serve over TLS")
+		}
+		if *adminPort == *port {
+			logger.Fatalf("The admin port must be different to the port the API is served on")
+		}
 		appConf := ServerConf{
-			AppSystemCode:    *appSystemCode,
-			AppName:          *appName,
-			Port:             *port,
-			RequestLoggingOn: *requestLoggingOn,
+			AppSystemCode:     *appSystemCode,
+			AppName:           *appName,
+			Port:              *port,
+			AdminPort:         *adminPort,
+			RequestLoggingOn:  *requestLoggingOn,
+			TLSCertFile:       *tlsCertFile,
+			TLSKeyFile:        *tlsKeyFile,
+			ReadHeaderTimeout: mustParseDuration("HTTP read header timeout", *httpReadHeaderTimeout),
+			ReadTimeout:       mustParseDuration("HTTP read timeout", *httpReadTimeout),
+			WriteTimeout:      mustParseDuration("HTTP write timeout", *httpWriteTimeout),
+			IdleTimeout:       mustParseDuration("HTTP idle timeout", *httpIdleTimeout),
+			ShutdownTimeout:   mustParseDuration("shutdown timeout", *shutdownTimeout),
 		}
 
-		conceptsService := concepts.NewConceptService(db)
-		conceptsService.Initialise()
+		//Requests are turned away and the service isn't good to go until it has connected to neo4j and migrated it,
+		//which is tried again in the background for as long as neither can be done
+		publisher := newEventPublisher(*eventsFile)
+		conceptsService := concepts.NewStartingConceptService(publisher)
+		connect := func() (concepts.ConceptStore, error) {
+			return newConceptStore(*neoURL, *batchSize, boltConf)
+		}
+		//the cache is enabled before anything else can use the service, as enabling it isn't safe while it is in use
+		if *readCacheSize > 0 {
+			conceptsService.EnableReadCache(*readCacheSize, mustParseDuration("read cache ttl", *readCacheTTL))
+		}
+		retryInterval := mustParseDuration("startup retry interval", *startupRetryInterval)
+		go conceptsService.Start(context.Background(), connect, *migrateOnStart, retryInterval)
+		if retention := mustParseDuration("events retention", *eventsRetention); retention > 0 {
+			go conceptsService.ExpireEvents(context.Background(), retention, eventExpiryInterval)
+		}
 
-		handler := concepts.ConceptsHandler{ConceptsService: &conceptsService}
+		handler := concepts.ConceptsHandler{
+			ConceptsService: &conceptsService,
+			ReadTimeout:     mustParseDuration("read timeout", *readTimeout),
+			WriteTimeout:    mustParseDuration("write timeout", *writeTimeout),
+		}
 		runServerWithParams(handler, appConf)
+		closeEventPublisher(publisher)
 	}
 	logger.Infof("Application started with args %s", os.Args)
 	app.Run(os.Args)
 }
 
+func newConceptStore(neoURL string, batchSize int, boltConf concepts.BoltConfig) (concepts.ConceptStore, error) {
+	if neoURL == "memory" {
+		logger.Warn("Keeping concepts in memory, they will be lost when the service stops")
+		return concepts.NewMemoryConceptStore(), nil
+	}
+	if concepts.IsBoltURL(neoURL) {
+		if batchSize != defaultBatchSize {
+			logger.Warnf("Ignoring a batch size of %d, as over Bolt each write runs as a single transaction", batchSize)
+		}
+		conn, err := concepts.NewBoltConnection(neoURL, boltConf)
+		if conn == nil {
+			logger.Fatalf("Invalid bolt configuration: %v", err)
+		}
+		return concepts.NewNeo4jConceptStore(conn), err
+	}
+
+	conf := neoutils.DefaultConnectionConfig()
+	conf.BatchSize = batchSize
+	db, err := neoutils.Connect(neoURL, conf)
+	if err != nil {
+		return nil, err
+	}
+	return concepts.NewNeo4jConceptStore(db), nil
+}
+
+//Sends spans to the exporter, if there is one, returning the function which sends the last of them
+func startTracing(exporter string, systemCode string) func() {
+	if exporter == "" {
+		return func() {}
+	}
+
+	provider, err := concepts.NewTracerProvider(context.Background(), exporter, systemCode)
+	if err != nil {
+		logger.Fatalf("Could not start tracing: %v", err)
+	}
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			logger.WithError(err).Error("Could not send the last spans")
+		}
+	}
+}
+
+func mustConnect(neoURL string, batchSize int, boltConf concepts.BoltConfig) concepts.ConceptStore {
+	store, err := newConceptStore(neoURL, batchSize, boltConf)
+	if err != nil {
+		logger.Fatalf("Could not connect to neo4j, error=[%s]\n", err)
+	}
+	return store
+}
+
+//Apply the migrations yet to be applied, logging each one that is, and returning the error of any that couldn't be
+func migrateUp(conceptsService *concepts.ConceptService) error {
+	applied, err := conceptsService.MigrateUp(context.Background())
+	for _, migration := range applied {
+		logger.Infof("Applied migration %d: %s", migration.Version, migration.Description)
+	}
+	return err
+}
+
+func printMigrationStatus(w io.Writer, migrations []concepts.MigrationStatus) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tAPPLIED AT\tDESCRIPTION")
+	for _, migration := range migrations {
+		appliedAt := "pending"
+		if migration.Applied() {
+			appliedAt = migration.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", migration.Version, appliedAt, migration.Description)
+	}
+	tw.Flush()
+}
+
+func newConceptService(store concepts.ConceptStore, eventsFile string) concepts.ConceptService {
+	return concepts.NewConceptServiceWithStore(store, newEventPublisher(eventsFile))
+}
+
+func newEventPublisher(eventsFile string) concepts.EventPublisher {
+	if eventsFile == "" {
+		return nil
+	}
+
+	publisher, err := concepts.NewFileEventPublisher(eventsFile)
+	if err != nil {
+		logger.Fatalf("Could not open events file: %v", err)
+	}
+	return publisher
+}
+
+//Close the file events are appended to, once the servers have stopped and nothing more will be published
+func closeEventPublisher(publisher concepts.EventPublisher) {
+	closer, ok := publisher.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		logger.WithError(err).Error("Could not close the events file")
+	}
+}
+
+func mustParseDuration(name string, value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Fatalf("Invalid %s: %v", name, err)
+	}
+	return duration
+}
+
+//Serve the API and the admin endpoints on their own ports until SIGTERM or an interrupt, then stop accepting requests and
+//wait for those in flight to finish, so that writes aren't cut off part way through
 func runServerWithParams(handler concepts.ConceptsHandler, appConf ServerConf) {
 	router := mux.NewRouter()
 	logger.Info("Registering handlers")
 	handler.RegisterHandlers(router)
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(appConf.Port),
+		Handler:           concepts.MonitoringHandler(router, appConf.RequestLoggingOn),
+		ReadHeaderTimeout: appConf.ReadHeaderTimeout,
+		ReadTimeout:       appConf.ReadTimeout,
+		WriteTimeout:      appConf.WriteTimeout,
+		IdleTimeout:       appConf.IdleTimeout,
+	}
 
-	mr := handler.RegisterAdminHandlers(router, appConf.AppSystemCode, appConf.AppName, appDescription, appConf.RequestLoggingOn)
-
-	http.Handle("/", mr)
+	adminRouter := mux.NewRouter()
+	handler.RegisterAdminHandlers(adminRouter, appConf.AppSystemCode, appConf.AppName, appDescription)
+	adminServer := &http.Server{
+		Addr:              ":" + strconv.Itoa(appConf.AdminPort),
+		Handler:           adminRouter,
+		ReadHeaderTimeout: appConf.ReadHeaderTimeout,
+		ReadTimeout:       appConf.ReadTimeout,
+		WriteTimeout:      adminWriteTimeout,
+		IdleTimeout:       appConf.IdleTimeout,
+	}
 
-	logger.Printf("listening on %d", appConf.Port)
+	errs := make(chan error, 2)
+	go func() {
+		if appConf.TLSCertFile != "" {
+			logger.Printf("listening on %d over TLS", appConf.Port)
+			errs <- server.ListenAndServeTLS(appConf.TLSCertFile, appConf.TLSKeyFile)
+			return
+		}
+		logger.Printf("listening on %d", appConf.Port)
+		errs <- server.ListenAndServe()
+	}()
+	go func() {
+		logger.Printf("admin endpoints listening on %d", appConf.AdminPort)
+		errs <- adminServer.ListenAndServe()
+	}()
 
-	if err := http.ListenAndServe(":"+strconv.Itoa(appConf.Port), mr); err != nil {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-errs:
 		logger.Fatalf("Unable to start: %v", err)
+	case sig := <-stop:
+		logger.Infof("Received %s, waiting up to %s for in-flight requests to finish", sig, appConf.ShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), appConf.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.WithError(err).Error("In-flight requests did not finish before the shutdown timeout")
+	}
+	if err := adminServer.Shutdown(ctx); err != nil {
+		logger.WithError(err).Error("Could not stop the admin endpoints")
 	}
 	logger.Printf("exiting on %s", serviceName)
 }